@@ -0,0 +1,60 @@
+package rgeo
+
+import (
+	"math"
+	"slices"
+
+	"github.com/golang/geo/s2"
+	"github.com/twpayne/go-geom"
+)
+
+// AnnotateCoords returns the Location of each of the given coordinates, like
+// calling ReverseGeocode for each of them, in the same order. Coordinates
+// that aren't in any location, or are invalid, get an empty Location, as do
+// all coordinates if RequireBuild was called but the index isn't built. If r
+// was returned by WithAlwaysSnap, coordinates that aren't in any location are
+// snapped like ReverseGeocodeSnapping does. The Hooks are called for each
+// valid coordinate, in the order they are looked up.
+//
+// It is faster than looking up each coordinate on its own for large inputs,
+// since the coordinates are queried in the order of the s2 Hilbert curve, so
+// that consecutive lookups mostly hit the same parts of the index.
+func (r *Rgeo) AnnotateCoords(coords []geom.Coord) []Location {
+	locs := make([]Location, len(coords))
+	if r.checkBuilt() != nil {
+		return locs
+	}
+
+	// The points are sorted by their cell, so that consecutive lookups mostly
+	// touch the same parts of the index. To keep sorting cheap, each key holds
+	// the upper half of the cell ID, which is precise to less than a
+	// kilometer, and the index of the coordinate in the lower half.
+	var (
+		points = make([]s2.Point, len(coords))
+		keys   = make([]uint64, 0, len(coords))
+	)
+	for i, c := range coords {
+		if validateCoord(c) != nil {
+			continue
+		}
+		points[i] = pointFromCoord(c)
+		cell := uint64(s2.CellFromPoint(points[i]).ID())
+		keys = append(keys, cell&^math.MaxUint32|uint64(i))
+	}
+	slices.Sort(keys)
+
+	query := s2.NewContainsPointQuery(r.index, s2.VertexModelOpen)
+	for _, k := range keys {
+		i := int(k & math.MaxUint32)
+		r.Hooks.query()
+		err := ErrLocationNotFound
+		if res := query.ContainingShapes(points[i]); len(res) > 0 {
+			locs[i], err = r.combineLocations(res), nil
+		} else if r.alwaysSnap {
+			locs[i], err = r.reverseGeocodeSnappingCached(coords[i])
+		}
+		r.Hooks.result(coords[i], locs[i], err)
+	}
+
+	return locs
+}
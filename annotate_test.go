@@ -0,0 +1,90 @@
+package rgeo
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/go-test/deep"
+	"github.com/twpayne/go-geom"
+)
+
+func TestAnnotateCoords(t *testing.T) {
+	r, err := New(testDataset(t, distanceTestData))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	in := []geom.Coord{
+		{0, 0.5},
+		{20, 20},
+		{0, 91},
+		{3, 0},
+	}
+	expected := make([]Location, len(in))
+	for i, c := range in {
+		expected[i], _ = r.ReverseGeocode(c)
+	}
+	if expected[0] == (Location{}) || expected[3] == (Location{}) {
+		t.Fatal("expected test coordinates to be in a location")
+	}
+
+	if diff := deep.Equal(expected, r.AnnotateCoords(in)); diff != nil {
+		t.Error(diff)
+	}
+}
+
+func TestAnnotateCoords_Snapping(t *testing.T) {
+	r, err := New(testDataset(t, distanceTestData))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r = r.WithAlwaysSnap(20)
+
+	var queries, hits, misses int
+	r.Hooks = Hooks{
+		OnQuery: func() { queries++ },
+		OnHit:   func(Location) { hits++ },
+		OnMiss:  func(geom.Coord) { misses++ },
+	}
+
+	// Inside, snapped from about 11km north, out of range and invalid
+	in := []geom.Coord{{2, 0}, {2, 1.1}, {20, 20}, {0, 91}}
+	expected := []Location{{CountryCode3: "TST"}, {CountryCode3: "TST"}, {}, {}}
+	if diff := deep.Equal(expected, r.AnnotateCoords(in)); diff != nil {
+		t.Error(diff)
+	}
+	if queries != 3 || hits != 2 || misses != 1 {
+		t.Errorf("expected 3 queries, 2 hits and 1 miss, got %d, %d and %d", queries, hits, misses)
+	}
+}
+
+func BenchmarkAnnotateCoords(b *testing.B) {
+	r, err := New(Countries10)
+	if err != nil {
+		b.Fatal(err)
+	}
+	r.Build()
+
+	coords := make([]geom.Coord, 100000)
+	for i := range coords {
+		coords[i] = geom.Coord{
+			(rand.Float64() * 360) - 180,
+			(rand.Float64() * 180) - 90,
+		}
+	}
+
+	b.Run("loop", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			locs := make([]Location, len(coords))
+			for j, c := range coords {
+				locs[j], _ = r.ReverseGeocode(c)
+			}
+		}
+	})
+
+	b.Run("sorted", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			_ = r.AnnotateCoords(coords)
+		}
+	})
+}
@@ -0,0 +1,186 @@
+package rgeo
+
+import (
+	"container/list"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/twpayne/go-geom"
+)
+
+// cacheKey is a coordinate rounded to the cache's precision.
+type cacheKey struct {
+	lon, lat float64
+}
+
+// cacheTTLDecimals is the number of decimals coordinates are rounded to by
+// the cache of EnableCacheTTL, which is about 10m.
+const cacheTTLDecimals = 4
+
+// cacheEntry is the cached result of a lookup.
+type cacheEntry struct {
+	key cacheKey
+	loc Location
+	err error
+
+	// expires is when the entry is dropped, if the cache has a TTL
+	expires time.Time
+}
+
+// lruCache is a fixed size least recently used cache of lookup results, keyed
+// by rounded coordinates. It is safe for concurrent use.
+//
+// Entries expire after ttl, unless it is zero. The generation gen is bumped
+// by clear, so that results looked up before, but put after, are discarded.
+type lruCache struct {
+	mu      sync.Mutex
+	size    int
+	scale   float64
+	ttl     time.Duration
+	now     func() time.Time
+	gen     uint64
+	entries map[cacheKey]*list.Element
+	order   *list.List // front is most recently used
+
+	hits, misses uint64
+}
+
+func newLRUCache(size int, decimals int) *lruCache {
+	return &lruCache{
+		size:    size,
+		scale:   math.Pow10(decimals),
+		now:     time.Now,
+		entries: make(map[cacheKey]*list.Element, size),
+		order:   list.New(),
+	}
+}
+
+// key rounds the coordinate to the configured number of decimals.
+func (c *lruCache) key(coord geom.Coord) cacheKey {
+	return cacheKey{
+		lon: math.Round(coord.X()*c.scale) / c.scale,
+		lat: math.Round(coord.Y()*c.scale) / c.scale,
+	}
+}
+
+func (c *lruCache) get(k cacheKey) (*cacheEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.entries[k]
+	if ok && c.ttl > 0 && !c.now().Before(e.Value.(*cacheEntry).expires) {
+		c.order.Remove(e)
+		delete(c.entries, k)
+		ok = false
+	}
+	if !ok {
+		c.misses++
+		return nil, false
+	}
+
+	c.hits++
+	c.order.MoveToFront(e)
+	return e.Value.(*cacheEntry), true
+}
+
+// generation returns the current generation of the cache, which has to be
+// passed to put with the result of a lookup started afterwards.
+func (c *lruCache) generation() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.gen
+}
+
+// put adds the result of a lookup, unless the cache was cleared since getting
+// gen from generation, i.e. the result may be stale.
+func (c *lruCache) put(k cacheKey, gen uint64, loc Location, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if gen != c.gen {
+		return
+	}
+
+	entry := &cacheEntry{key: k, loc: loc, err: err}
+	if c.ttl > 0 {
+		entry.expires = c.now().Add(c.ttl)
+	}
+
+	if e, ok := c.entries[k]; ok {
+		c.order.MoveToFront(e)
+		e.Value = entry
+		return
+	}
+
+	c.entries[k] = c.order.PushFront(entry)
+	if c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cacheEntry).key)
+	}
+}
+
+// emptyCopy returns a new cache with the same size, precision and TTL as c.
+func (c *lruCache) emptyCopy() *lruCache {
+	e := newLRUCache(c.size, 0)
+	e.scale, e.ttl, e.now = c.scale, c.ttl, c.now
+	return e
+}
+
+// clear drops all entries and starts a new generation, the hit and miss
+// counters are kept.
+func (c *lruCache) clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.gen++
+	c.entries = make(map[cacheKey]*list.Element, c.size)
+	c.order.Init()
+}
+
+// shrink is like clear, but releases the memory of the entries rather than
+// keeping it for reuse.
+func (c *lruCache) shrink() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.entries = make(map[cacheKey]*list.Element)
+	c.order.Init()
+}
+
+// EnableCache enables caching of ReverseGeocodeSnapping results for up to size
+// coordinates. Coordinates are rounded to the given number of decimals before
+// being used as the cache key, so nearby points share an entry. A size of zero
+// or less disables the cache.
+//
+// The cache is cleared whenever the data or the snapping distance change.
+func (r *Rgeo) EnableCache(size int, decimals int) {
+	if size <= 0 {
+		r.cache = nil
+		return
+	}
+	r.cache = newLRUCache(size, decimals)
+}
+
+// EnableCacheTTL is like EnableCache with coordinates rounded to 4 decimals,
+// about 10m, but cached results are dropped ttl after they were looked up, e.g.
+// for a long-running service whose datasets change independently of it. A ttl
+// of zero or less never drops them, like EnableCache.
+//
+// Lookups that were already running when the data changed don't add their
+// results to the cache, so it never returns results from before the change.
+func (r *Rgeo) EnableCacheTTL(size int, ttl time.Duration) {
+	r.EnableCache(size, cacheTTLDecimals)
+	if r.cache != nil && ttl > 0 {
+		r.cache.ttl = ttl
+	}
+}
+
+// clearCache drops all cached results, if there is a cache.
+func (r *Rgeo) clearCache() {
+	if r.cache != nil {
+		r.cache.clear()
+	}
+}
@@ -0,0 +1,138 @@
+package rgeo
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/go-test/deep"
+	"github.com/twpayne/go-geom"
+)
+
+func TestEnableCache(t *testing.T) {
+	r, err := New(testDataset(t, `{"type":"FeatureCollection","features":[
+		{"type":"Feature","properties":{"ISO_A3_EH":"TST"},
+		 "geometry":{"type":"Polygon",
+		  "coordinates":[[[0,52],[1,52],[1,53],[0,53],[0,52]]]}}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.EnableCache(2, 1)
+
+	// Offshore points repeating at low precision
+	coords := []geom.Coord{
+		{5.01, 5.01}, {5.02, 4.99}, {5.04, 5.03}, {4.96, 5.01},
+		{0.51, 52.52}, {0.49, 52.48}, {5.0, 5.0}, {0.5, 52.5},
+	}
+	for _, c := range coords {
+		if _, err := r.ReverseGeocodeSnapping(c); err != nil &&
+			err != ErrLocationNotFound {
+			t.Fatal(err)
+		}
+	}
+
+	if r.cache.hits != 6 || r.cache.misses != 2 {
+		t.Errorf("expected 6 hits and 2 misses, got %d hits and %d misses",
+			r.cache.hits, r.cache.misses)
+	}
+
+	// Adding a dataset has to invalidate the cached miss
+	r.AddDataset(testDataset(t, `{"type":"FeatureCollection","features":[
+		{"type":"Feature","properties":{"ISO_A3_EH":"NEW"},
+		 "geometry":{"type":"Polygon",
+		  "coordinates":[[[4,4],[6,4],[6,6],[4,6],[4,4]]]}}]}`))
+
+	loc, err := r.ReverseGeocodeSnapping(geom.Coord{5, 5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := deep.Equal(Location{CountryCode3: "NEW"}, loc); diff != nil {
+		t.Error(diff)
+	}
+
+	// The least recently used entry is evicted
+	for _, c := range []geom.Coord{{0.5, 52.5}, {10, 10}, {20, 20}} {
+		_, _ = r.ReverseGeocodeSnapping(c)
+	}
+	if _, ok := r.cache.get(r.cache.key(geom.Coord{0.5, 52.5})); ok {
+		t.Error("expected entry to be evicted")
+	}
+	if len(r.cache.entries) != 2 {
+		t.Errorf("expected 2 entries, got %d", len(r.cache.entries))
+	}
+
+	// Invalid coordinates are rejected before building the key
+	for _, c := range []geom.Coord{{1}, {}, {0, 91}} {
+		if _, err := r.ReverseGeocodeSnapping(c); !errors.Is(err, ErrInvalidCoordinate) {
+			t.Errorf("%v: expected error: %s\n got: %v\n", c, ErrInvalidCoordinate, err)
+		}
+	}
+
+	r.EnableCache(0, 0)
+	if r.cache != nil {
+		t.Error("expected cache to be disabled")
+	}
+}
+
+func TestEnableCacheTTL(t *testing.T) {
+	r, err := New(testDataset(t, `{"type":"FeatureCollection","features":[
+		{"type":"Feature","properties":{"ISO_A3_EH":"TST"},
+		 "geometry":{"type":"Polygon",
+		  "coordinates":[[[0,52],[1,52],[1,53],[0,53],[0,52]]]}}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.EnableCacheTTL(10, time.Minute)
+
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	r.cache.now = func() time.Time { return now }
+
+	lookup := func() {
+		t.Helper()
+		if _, err := r.ReverseGeocodeSnapping(geom.Coord{0.5, 52.5}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expect := func(hits, misses uint64) {
+		t.Helper()
+		if r.cache.hits != hits || r.cache.misses != misses {
+			t.Errorf("expected %d hits and %d misses, got %d hits and %d misses",
+				hits, misses, r.cache.hits, r.cache.misses)
+		}
+	}
+
+	lookup()
+	now = now.Add(59 * time.Second)
+	lookup()
+	expect(1, 1)
+
+	// Expired a minute after the first lookup
+	now = now.Add(time.Second)
+	lookup()
+	lookup()
+	expect(2, 2)
+
+	// A result looked up before the data changed isn't added afterwards
+	key := r.cache.key(geom.Coord{5, 5})
+	gen := r.cache.generation()
+	r.AddDataset(testDataset(t, `{"type":"FeatureCollection","features":[
+		{"type":"Feature","properties":{"ISO_A3_EH":"NEW"},
+		 "geometry":{"type":"Polygon",
+		  "coordinates":[[[4,4],[6,4],[6,6],[4,6],[4,4]]]}}]}`))
+	r.cache.put(key, gen, Location{}, ErrLocationNotFound)
+
+	loc, err := r.ReverseGeocodeSnapping(geom.Coord{5, 5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := deep.Equal(Location{CountryCode3: "NEW"}, loc); diff != nil {
+		t.Error(diff)
+	}
+
+	lookup()
+	expect(2, 4)
+
+	if c := r.Clone(); c.cache.ttl != time.Minute {
+		t.Errorf("expected clone to keep the TTL, got %s", c.cache.ttl)
+	}
+}
@@ -14,9 +14,10 @@ specific language governing permissions and limitations under the License.
 */
 
 /*
-Command datagen converts GeoJSON files into go files containing functions that
-return the compressed GeoJSON, it can also merge properties from one GeoJSON
-file into another using the -merge flag (which it does by matching the country
+Command datagen converts GeoJSON files into the compressed binary format of
+rgeo.Feature.Encode, which is what the included datasets are embedded as and
+what rgeo.LoadAuto reads. It can also merge properties from one GeoJSON file
+into another using the -merge flag (which it does by matching the country
 names). You can use this if you want to use a different dataset to any of those
 included, although that might be somewhat awkward if the properties in your
 GeoJSON file are different.
@@ -24,13 +25,21 @@ GeoJSON file are different.
 package main
 
 import (
+	"bufio"
+	"compress/gzip"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
+	"hash/crc32"
+	"io"
 	"log"
+	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/klauspost/compress/zstd"
 	"github.com/sams96/rgeo"
@@ -40,11 +49,16 @@ import (
 func main() {
 	outPath := flag.String("o", "", "path to output file")
 	propsFilePath := flag.String("merge", "", "path to file to merge properties from")
+	mergeKey := flag.String("merge-key", "ADMIN", "property to match features by when merging, e.g. ISO_A3")
+	useZstd := flag.Bool("zstd", true, "compress output with zstd instead of gzip")
+	trimCitySuffix := flag.Bool("trim-city-suffix", true, `remove the trailing "2" Natural Earth adds to some city names`)
 	flag.Parse()
 
 	if *outPath == "" {
-		_, _ = fmt.Fprintf(os.Stderr, "usage: %s -o outprefix <infile.geojson> [infile2.geojson] [...]\n", os.Args[0])
+		_, _ = fmt.Fprintf(os.Stderr, "usage: %s -o outfile.zst <infile.geojson> [infile2.geojson] [...]\n", os.Args[0])
 		os.Exit(1)
+	} else if filepath.Ext(*outPath) == ".go" {
+		log.Fatal("the output is a data file to embed, not go source, e.g. -o data/Foo.zst")
 	}
 
 	inputFiles := flag.Args()
@@ -58,115 +72,433 @@ func main() {
 		attributionFiles[i] = filepath.Base(path)
 	}
 
-	if fc, err := readInputs(inputFiles, *propsFilePath); err != nil {
-		log.Fatal("error reading inputs: ", err)
-	} else if err := writeFeatures(*outPath, *fc); err != nil {
+	var props propsIndex
+	if *propsFilePath != "" {
+		var err error
+		if props, err = readPropsIndex(*propsFilePath, *mergeKey); err != nil {
+			log.Fatal("error reading props GeoJSON file: ", err)
+		}
+	}
+
+	if n, crc, err := writeFeatures(*outPath, inputFiles, props, *mergeKey, *useZstd,
+		rgeo.GeoJSONOptions{TrimCitySuffix: *trimCitySuffix}); err != nil {
 		log.Fatal("error writing features: ", err)
+	} else if err := verifyOutput(*outPath, *useZstd, n, crc); err != nil {
+		log.Fatal("error verifying output: ", err)
 	} else if err := writeAttribution(*outPath, attributionFiles); err != nil {
 		log.Fatal("error writing attribution: ", err)
 	}
 }
 
-func readInputs(in []string, propsFileName string) (*geojson.FeatureCollection, error) {
-	var props *geojson.FeatureCollection
-	if propsFileName != "" {
-		md, err := readGeoJSON(propsFileName)
+// readInputs calls fn with each feature of the input files in turn, as it is
+// decoded, with the properties from props merged into it and the name of its
+// input file as its source
+func readInputs(in []string, props propsIndex, mergeKey string, fn func(feat *geojson.Feature, source string) error) error {
+	for _, f := range in {
+		source := sourceName(f)
+		err := readGeoJSON(f, func(feat *geojson.Feature) error {
+			if props != nil {
+				if err := extendProps(feat, props, mergeKey); err != nil {
+					return fmt.Errorf("extend properties: %w", err)
+				}
+			}
+			return fn(feat, source)
+		})
 		if err != nil {
-			return nil, fmt.Errorf("read props GeoJSON file: %w", err)
+			return fmt.Errorf("read input GeoJSON file: %w", err)
 		}
-		props = md
 	}
 
-	fc := &geojson.FeatureCollection{}
-	for _, f := range in {
-		s, err := readGeoJSON(f)
-		if err != nil {
-			return nil, fmt.Errorf("read input GeoJSON file: %w", err)
-		}
-		if props != nil {
-			if err := extendProps(s, props); err != nil {
-				return nil, fmt.Errorf("extend properties: %w", err)
+	return nil
+}
+
+// sourceName returns the file name of a path or URL, which is recorded as the
+// source of its features
+func sourceName(path string) string {
+	if u, err := url.Parse(path); err == nil && u.Scheme != "" {
+		path = u.Path
+	}
+	return filepath.Base(path)
+}
+
+// batchSize is the number of features converted at once by writeFeatures,
+// which bounds the converted polygons held in memory
+const batchSize = 256
+
+// batch is a converted part of the input, or the error converting it
+type batch struct {
+	features rgeo.FeatureCollection
+	skipped  int
+	err      error
+}
+
+// errStopped stops reading the inputs once a batch with an error was sent or
+// done is closed
+var errStopped = errors.New("stopped")
+
+// convertBatches reads the inputs and converts their features in batches of
+// at most batchSize, each in parallel by rgeo.LoadGeoJSONWithSkipped, and
+// sends them in order. The next batch is read and converted while the
+// previous one is written. A batch has the features of only one source, and
+// its GeoJSON features are dropped once it is converted. Converting stops at
+// the first error or when done is closed.
+func convertBatches(in []string, props propsIndex, mergeKey string, opts rgeo.GeoJSONOptions, done <-chan struct{}) <-chan batch {
+	batches := make(chan batch, 1)
+	go func() {
+		defer close(batches)
+
+		var (
+			features []*geojson.Feature
+			source   string
+			start    = 1
+		)
+		send := func() error {
+			opts.Source = source
+			var b batch
+			b.features, b.skipped, b.err = rgeo.LoadGeoJSONWithSkipped(
+				geojson.FeatureCollection{Features: features}, opts)
+			if b.err != nil {
+				b.err = fmt.Errorf("load GeoJSON features %d to %d: %w", start, start+len(features)-1, b.err)
 			}
+			start += len(features)
+			features = nil
+
+			select {
+			case batches <- b:
+			case <-done:
+				return errStopped
+			}
+			if b.err != nil {
+				return errStopped
+			}
+			return nil
 		}
-		fc.Features = append(fc.Features, s.Features...)
-	}
 
-	return fc, nil
+		err := readInputs(in, props, mergeKey, func(feat *geojson.Feature, s string) error {
+			if len(features) == batchSize || (len(features) > 0 && s != source) {
+				if err := send(); err != nil {
+					return err
+				}
+			}
+			features = append(features, feat)
+			source = s
+			return nil
+		})
+		if err == nil && len(features) > 0 {
+			err = send()
+		}
+		if err != nil && !errors.Is(err, errStopped) {
+			select {
+			case batches <- batch{err: fmt.Errorf("read inputs: %w", err)}:
+			case <-done:
+			}
+		}
+	}()
+	return batches
 }
 
-func writeFeatures(outPath string, fc geojson.FeatureCollection) error {
+// writeFeatures returns the number of features written, which excludes those
+// skipped for their empty geometry, and the CRC-32 of the uncompressed output
+func writeFeatures(outPath string, in []string, props propsIndex, mergeKey string, useZstd bool, opts rgeo.GeoJSONOptions) (int, uint32, error) {
 	f, err := os.Create(outPath)
 	if err != nil {
-		return fmt.Errorf("create output file: %w", err)
+		return 0, 0, fmt.Errorf("create output file: %w", err)
 	}
 	defer func() { _ = f.Close() }()
 
-	zw, err := zstd.NewWriter(f, zstd.WithEncoderLevel(zstd.SpeedBestCompression))
+	zw, err := newCompressor(f, useZstd)
 	if err != nil {
-		return fmt.Errorf("create zstd writer: %w", err)
+		return 0, 0, err
 	}
 	defer func() { _ = zw.Close() }()
 
-	dataset, err := rgeo.LoadGeoJSON(fc)
-	if err != nil {
-		return fmt.Errorf("load GeoJSON: %w", err)
+	sum := crc32.NewIEEE()
+	w := io.MultiWriter(zw, sum)
+
+	// Read, convert and encode the features in batches, so that neither the
+	// input nor the converted polygons have to be held in memory as a whole
+	done := make(chan struct{})
+	defer close(done)
+
+	written, skipped := 0, 0
+	for b := range convertBatches(in, props, mergeKey, opts, done) {
+		if b.err != nil {
+			return 0, 0, b.err
+		}
+		if err := b.features.Encode(w); err != nil {
+			return 0, 0, fmt.Errorf("encode feature %d: %w", written+1, err)
+		}
+		written += len(b.features)
+		skipped += b.skipped
 	}
 
-	if err := dataset.Encode(zw); err != nil {
-		return fmt.Errorf("encode dataset: %w", err)
+	if skipped > 0 {
+		log.Printf("skipped %d features with empty geometries", skipped)
 	}
 
 	// explicit flush so that zw.Close always succeeds
 	if err := zw.Flush(); err != nil {
-		return fmt.Errorf("flush: %w", err)
+		return 0, 0, fmt.Errorf("flush: %w", err)
+	}
+
+	return written, sum.Sum32(), nil
+}
+
+// verifyOutput checks that the written file decompresses and decodes, as for
+// the included datasets, into n features with the given CRC-32. The features
+// are decoded one at a time, so the output is never held in memory as a whole
+func verifyOutput(outPath string, useZstd bool, n int, crc uint32) error {
+	f, err := os.Open(outPath)
+	if err != nil {
+		return fmt.Errorf("open output file: %w", err)
+	}
+	defer func() { _ = f.Close() }()
+
+	decompress := rgeo.GzipDecompressor
+	if useZstd {
+		decompress = rgeo.ZstdDecompressor
+	}
+	dr, err := decompress(f)
+	if err != nil {
+		return err
+	}
+	if c, ok := dr.(io.Closer); ok {
+		defer func() { _ = c.Close() }()
+	}
+
+	sum := crc32.NewIEEE()
+	r := bufio.NewReader(io.TeeReader(dr, sum))
+	decoded := 0
+	for ; ; decoded++ {
+		var feat rgeo.Feature
+		if err := feat.Decode(r); errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return fmt.Errorf("decode feature %d: %w", decoded+1, err)
+		}
 	}
 
+	if decoded != n {
+		return fmt.Errorf("decoded %d features, expected %d", decoded, n)
+	} else if sum.Sum32() != crc {
+		return fmt.Errorf("CRC-32 of output is %08x, expected %08x", sum.Sum32(), crc)
+	}
 	return nil
 }
 
-// readGeoJSON parses a GeoJSON file as geojson.FeatureCollection
-func readGeoJSON(path string) (*geojson.FeatureCollection, error) {
-	f, err := os.Open(path)
+// compressor is implemented by both zstd.Encoder and gzip.Writer
+type compressor interface {
+	io.WriteCloser
+	Flush() error
+}
+
+// newCompressor returns a zstd or gzip writer to w, both of which are
+// accepted by rgeo.LoadAuto
+func newCompressor(w io.Writer, useZstd bool) (compressor, error) {
+	if !useZstd {
+		gw, err := gzip.NewWriterLevel(w, gzip.BestCompression)
+		if err != nil {
+			return nil, fmt.Errorf("create gzip writer: %w", err)
+		}
+		return gw, nil
+	}
+
+	zw, err := zstd.NewWriter(w, zstd.WithEncoderLevel(zstd.SpeedBestCompression))
 	if err != nil {
-		return nil, fmt.Errorf("open file: %w", err)
+		return nil, fmt.Errorf("create zstd writer: %w", err)
+	}
+	return zw, nil
+}
+
+// downloadTimeout limits how long reading an input from a URL may take
+const downloadTimeout = 10 * time.Minute
+
+// readGeoJSON calls fn with each feature of a GeoJSON FeatureCollection in a
+// file or at an http(s) URL as it is decoded, files ending in .geojsonl or
+// .ndjson are read as one feature per line
+func readGeoJSON(path string, fn func(*geojson.Feature) error) error {
+	f, err := openInput(path)
+	if err != nil {
+		return err
 	}
 	defer func() { _ = f.Close() }()
 
-	var result geojson.FeatureCollection
-	if err := json.NewDecoder(f).Decode(&result); err != nil {
-		return nil, fmt.Errorf("decode GeoJSON: %w", err)
+	decode := decodeFeatureCollection
+	switch strings.ToLower(filepath.Ext(sourceName(path))) {
+	case ".geojsonl", ".ndjson":
+		decode = decodeFeatureLines
+	}
+
+	if err := decode(f, fn); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
 	}
+	return nil
+}
 
-	return &result, nil
+// openInput opens a local file, or streams the response body if path is an
+// http(s) URL
+func openInput(path string) (io.ReadCloser, error) {
+	if !strings.HasPrefix(path, "http://") && !strings.HasPrefix(path, "https://") {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, fmt.Errorf("open file: %w", err)
+		}
+		return f, nil
+	}
+
+	client := &http.Client{Timeout: downloadTimeout}
+	resp, err := client.Get(path)
+	if err != nil {
+		return nil, fmt.Errorf("download %s: %w", path, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		_ = resp.Body.Close()
+		return nil, fmt.Errorf("download %s: %s", path, resp.Status)
+	}
+	return resp.Body, nil
 }
 
-// extendProps merges properties from source into dest based on country name
-func extendProps(dest *geojson.FeatureCollection, source *geojson.FeatureCollection) error {
-	for _, feat := range dest.Features {
-		destCountry, ok := getAdmin(feat)
-		if !ok {
-			return fmt.Errorf("missing country in destination feature: %v", feat)
+// decodeFeatureCollection decodes the features of a GeoJSON
+// FeatureCollection one at a time, so the input never has to be held in
+// memory as a whole
+func decodeFeatureCollection(r io.Reader, fn func(*geojson.Feature) error) error {
+	dec := json.NewDecoder(r)
+	if err := expectDelim(dec, '{'); err != nil {
+		return fmt.Errorf("decode GeoJSON: %w", err)
+	}
+
+	var typ string
+	for dec.More() {
+		t, err := dec.Token()
+		if err != nil {
+			return fmt.Errorf("decode GeoJSON: %w", err)
 		}
-		for _, md := range source.Features {
-			if sourceCountry, _ := getAdmin(md); sourceCountry == destCountry {
-				for k, v := range md.Properties {
-					feat.Properties[k] = v
+
+		switch t {
+		case "type":
+			if err := dec.Decode(&typ); err != nil {
+				return fmt.Errorf("decode GeoJSON type: %w", err)
+			}
+		case "features":
+			if err := expectDelim(dec, '['); err != nil {
+				return fmt.Errorf("decode GeoJSON features: %w", err)
+			}
+			for i := 1; dec.More(); i++ {
+				var feat geojson.Feature
+				if err := dec.Decode(&feat); err != nil {
+					return fmt.Errorf("decode GeoJSON feature %d: %w", i, err)
+				}
+				if err := fn(&feat); err != nil {
+					return err
 				}
 			}
+			if err := expectDelim(dec, ']'); err != nil {
+				return fmt.Errorf("decode GeoJSON features: %w", err)
+			}
+		default:
+			var skip json.RawMessage
+			if err := dec.Decode(&skip); err != nil {
+				return fmt.Errorf("decode GeoJSON %v: %w", t, err)
+			}
 		}
 	}
+
+	if typ != "FeatureCollection" {
+		return fmt.Errorf("decode GeoJSON: %w", geojson.ErrUnsupportedType(typ))
+	}
+	return expectDelim(dec, '}')
+}
+
+// expectDelim reads the next JSON token, which has to be the delimiter d
+func expectDelim(dec *json.Decoder, d json.Delim) error {
+	t, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	if t != d {
+		return fmt.Errorf("expected %v, got %v", d, t)
+	}
 	return nil
 }
 
-// getAdmin compensates for "admin" vs "ADMIN"
-func getAdmin(feat *geojson.Feature) (string, bool) {
-	if country, ok := feat.Properties["ADMIN"].(string); ok {
-		return country, true
-	} else if country, ok := feat.Properties["admin"].(string); ok {
-		return country, true
-	} else {
-		return "", false
+// decodeFeatureLines decodes newline-delimited GeoJSON features one at a time,
+// so the input never has to be held in memory as a whole
+func decodeFeatureLines(r io.Reader, fn func(*geojson.Feature) error) error {
+	dec := json.NewDecoder(r)
+	for i := 1; ; i++ {
+		var feat geojson.Feature
+		if err := dec.Decode(&feat); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("decode GeoJSON feature %d: %w", i, err)
+		}
+		if err := fn(&feat); err != nil {
+			return err
+		}
+	}
+}
+
+// propsIndex maps the values of the merge key to the properties of the
+// features with that value in the file to merge properties from
+type propsIndex map[string][]map[string]interface{}
+
+// readPropsIndex reads the file to merge properties from, keeping only the
+// properties of its features indexed by the given property
+func readPropsIndex(path, key string) (propsIndex, error) {
+	index := make(propsIndex)
+	err := readGeoJSON(path, func(feat *geojson.Feature) error {
+		if v, _ := getProperty(feat, key); !missingValue(v) {
+			index[v] = append(index[v], feat.Properties)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
+
+	return index, nil
+}
+
+// extendProps merges the properties from the features in index into feat
+// based on the given property, which defaults to the country name. Features
+// without a match in index are logged.
+func extendProps(feat *geojson.Feature, index propsIndex, key string) error {
+	value, ok := getProperty(feat, key)
+	if !ok {
+		return fmt.Errorf("missing %s in destination feature: %v", key, feat)
+	} else if missingValue(value) {
+		log.Printf("no %s to merge properties by for feature: %v", key, feat.Properties)
+		return nil
+	}
+
+	matches, ok := index[value]
+	if !ok {
+		log.Printf("no properties to merge for %s %q", key, value)
+		return nil
+	}
+	for _, props := range matches {
+		for k, v := range props {
+			feat.Properties[k] = v
+		}
+	}
+	return nil
+}
+
+// getProperty compensates for "admin" vs "ADMIN" by also trying the upper and
+// lower case key
+func getProperty(feat *geojson.Feature, key string) (string, bool) {
+	for _, k := range []string{key, strings.ToUpper(key), strings.ToLower(key)} {
+		if v, ok := feat.Properties[k].(string); ok {
+			return v, true
+		}
+	}
+	return "", false
+}
+
+// missingValue reports whether v is empty or "-99", which Natural Earth uses
+// for codes that don't exist
+func missingValue(v string) bool {
+	return v == "" || v == "-99"
 }
 
 func writeAttribution(outPath string, attribFiles []string) error {
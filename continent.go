@@ -0,0 +1,53 @@
+package rgeo
+
+import (
+	"strings"
+	"sync"
+)
+
+// continentCodes lists the ISO 3166-1 alpha-3 codes of each continent, using
+// the continent names of Natural Earth. Countries spanning several continents
+// are listed where Natural Earth puts them, e.g. Russia and Turkey in Europe
+// and Asia respectively, and overseas territories by their own location.
+var continentCodes = map[string]string{
+	"Africa": "AGO BDI BEN BFA BWA CAF CIV CMR COD COG COM CPV DJI DZA EGY ERI " +
+		"ESH ETH GAB GHA GIN GMB GNB GNQ KEN LBR LBY LSO MAR MDG MLI MOZ MRT " +
+		"MWI MYT NAM NER NGA REU RWA SDN SEN SLE SOM SSD STP SWZ TCD TGO TUN " +
+		"TZA UGA ZAF ZMB ZWE",
+	"Antarctica": "ATA",
+	"Asia": "AFG ARE ARM AZE BGD BHR BRN BTN CCK CHN CXR CYP GEO HKG IDN IND " +
+		"IRN IRQ ISR JOR JPN KAZ KGZ KHM KOR KWT LAO LBN LKA MAC MMR MNG MYS " +
+		"NPL OMN PAK PHL PRK PSE QAT SAU SGP SYR THA TJK TKM TLS TUR TWN UZB " +
+		"VNM YEM",
+	"Europe": "ALA ALB AND AUT BEL BGR BIH BLR CHE CZE DEU DNK ESP EST FIN FRA " +
+		"FRO GBR GGY GIB GRC HRV HUN IMN IRL ISL ITA JEY LIE LTU LUX LVA MCO " +
+		"MDA MKD MLT MNE NLD NOR POL PRT ROU RUS SJM SMR SRB SVK SVN SWE UKR " +
+		"VAT",
+	"North America": "ABW AIA ATG BES BHS BLM BLZ BMU BRB CAN CRI CUB CUW CYM " +
+		"DMA DOM GLP GRD GRL GTM HND HTI JAM KNA LCA MAF MEX MSR MTQ NIC PAN " +
+		"PRI SLV SPM SXM TCA TTO UMI USA VCT VGB VIR",
+	"Oceania": "ASM AUS COK FJI FSM GUM KIR MHL MNP NCL NFK NIU NRU NZL PCN " +
+		"PLW PNG PYF SLB TKL TON TUV VUT WLF WSM",
+	"South America":           "ARG BOL BRA CHL COL ECU FLK GUF GUY PER PRY SUR URY VEN",
+	"Seven seas (open ocean)": "ATF BVT HMD IOT MDV MUS SGS SHN SYC",
+}
+
+// continentsByCode maps the codes in continentCodes to their continent.
+var continentsByCode = sync.OnceValue(func() map[string]string {
+	m := make(map[string]string)
+	for continent, codes := range continentCodes {
+		for _, code := range strings.Fields(codes) {
+			m[code] = continent
+		}
+	}
+	return m
+})
+
+// ContinentOf returns the continent of the country with the given ISO 3166-1
+// alpha-3 code, ignoring case, from a built-in table. The names are those used
+// by Natural Earth for Location.Continent, e.g. "North America", with small
+// island states in the open ocean as "Seven seas (open ocean)". An empty string
+// is returned for unknown codes.
+func ContinentOf(code3 string) string {
+	return continentsByCode()[strings.ToUpper(code3)]
+}
@@ -0,0 +1,115 @@
+package rgeo
+
+import (
+	"errors"
+
+	"github.com/golang/geo/s2"
+)
+
+// coverageMaxDepth is how many levels below the queried cell CoverageInCell
+// subdivides cells on a border.
+const coverageMaxDepth = 8
+
+// CoverageInCell returns the fraction of the area of the given cell that is
+// covered by each country, keyed by CountryCode3. Countries that don't
+// intersect the cell are omitted, as are shapes without a CountryCode3, such as
+// cities.
+//
+// s2 doesn't have boolean operations on polygons, so the fractions are
+// estimated by subdividing the cell where it is crossed by a border, up to
+// eight levels below the given cell. They are accurate to about 1% for cells
+// crossed by a single border.
+func (r *Rgeo) CoverageInCell(cell s2.CellID) (map[string]float64, error) {
+	if !cell.IsValid() {
+		return nil, errors.New("invalid cell")
+	} else if err := r.checkBuilt(); err != nil {
+		return nil, err
+	}
+
+	c := s2.CellFromCellID(cell)
+
+	// Candidates are the countries whose bounds intersect the cell, those not
+	// intersecting it are skipped by coveredArea.
+	polygons := make(map[string][]*s2.Polygon)
+	bound := c.RectBound()
+	for _, s := range r.shapes() {
+		p := s.Shape.(*s2.Polygon)
+		if code := s.loc.CountryCode3; code != "" && p.RectBound().Intersects(bound) {
+			polygons[code] = append(polygons[code], p)
+		}
+	}
+
+	coverage := make(map[string]float64, len(polygons))
+	area := c.ExactArea()
+	for code, p := range polygons {
+		if covered := coveredArea(p, c, coverageMaxDepth); covered > 0 {
+			coverage[code] = covered / area
+		}
+	}
+
+	return coverage, nil
+}
+
+// coveredArea estimates the area of the cell covered by the union of the
+// polygons, by subdividing cells that are only partly covered up to the given
+// depth. At the maximum depth a cell counts as covered if its center is.
+func coveredArea(polygons []*s2.Polygon, c s2.Cell, depth int) float64 {
+	intersects := false
+	for _, p := range polygons {
+		if p.ContainsCell(c) {
+			return c.ExactArea()
+		}
+		intersects = intersects || p.IntersectsCell(c)
+	}
+
+	switch {
+	case !intersects:
+		return 0
+	case depth == 0 || c.IsLeaf():
+		for _, p := range polygons {
+			if p.ContainsPoint(c.Center()) {
+				return c.ExactArea()
+			}
+		}
+		return 0
+	}
+
+	var area float64
+	children, _ := c.Children()
+	for _, child := range children {
+		area += coveredArea(polygons, child, depth-1)
+	}
+	return area
+}
+
+// CellCovering returns a covering of at most maxCells cells of the country with
+// the given ISO 3166-1 alpha-3 code, ignoring case, across all of its polygons.
+// Like LookupByCode3, only the features at the least specific level are used.
+// The covering contains the whole country, but also some area around it, the
+// more the fewer cells are allowed.
+//
+// ErrLocationNotFound is returned if no feature has the code.
+func (r *Rgeo) CellCovering(code3 string, maxCells int) (s2.CellUnion, error) {
+	if maxCells <= 0 {
+		return nil, errors.New("maxCells must be positive")
+	}
+
+	matches := r.shapesByCode3(code3)
+	if len(matches) == 0 {
+		return nil, ErrLocationNotFound
+	}
+
+	coverer := &s2.RegionCoverer{MaxLevel: 30, MaxCells: maxCells}
+	if len(matches) == 1 {
+		return coverer.Covering(matches[0].Shape.(*s2.Polygon)), nil
+	}
+
+	// Cover the combined coverings again to keep within maxCells
+	parts := make([]s2.CellUnion, len(matches))
+	for i, s := range matches {
+		parts[i] = coverer.Covering(s.Shape.(*s2.Polygon))
+	}
+	union := s2.CellUnionFromUnion(parts...)
+
+	return coverer.Covering(&union), nil
+}
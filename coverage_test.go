@@ -0,0 +1,132 @@
+package rgeo
+
+import (
+	"math"
+	"testing"
+
+	"github.com/golang/geo/s2"
+)
+
+func TestCoverageInCell(t *testing.T) {
+	// AAA and BBB share a border at longitude 2, and AAA also has a province
+	// which must not be counted twice.
+	r, err := New(testDataset(t, `{"type":"FeatureCollection","features":[
+		{"type":"Feature","properties":{"ISO_A3_EH":"AAA"},
+		 "geometry":{"type":"Polygon",
+		  "coordinates":[[[0,0],[2,0],[2,2],[0,2],[0,0]]]}},
+		{"type":"Feature","properties":{"ISO_A3_EH":"AAA","name":"West"},
+		 "geometry":{"type":"Polygon",
+		  "coordinates":[[[0,0],[2,0],[2,2],[0,2],[0,0]]]}},
+		{"type":"Feature","properties":{"ISO_A3_EH":"BBB"},
+		 "geometry":{"type":"Polygon",
+		  "coordinates":[[[2,0],[4,0],[4,2],[2,2],[2,0]]]}},
+		{"type":"Feature","properties":{"name_conve":"City"},
+		 "geometry":{"type":"Polygon",
+		  "coordinates":[[[1.9,0.9],[2.1,0.9],[2.1,1.1],[1.9,1.1],[1.9,0.9]]]}}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cellAt := func(lat, lon float64, level int) s2.CellID {
+		return s2.CellIDFromLatLng(s2.LatLngFromDegrees(lat, lon)).Parent(level)
+	}
+
+	// Inside AAA
+	coverage, err := r.CoverageInCell(cellAt(1, 1, 12))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(coverage) != 1 || math.Abs(coverage["AAA"]-1) > 1e-9 {
+		t.Errorf("expected AAA to cover the cell, got %v", coverage)
+	}
+
+	// On the border
+	coverage, err = r.CoverageInCell(cellAt(1, 2, 10))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(coverage) != 2 || coverage["AAA"] <= 0 || coverage["BBB"] <= 0 {
+		t.Errorf("expected AAA and BBB to cover the cell, got %v", coverage)
+	}
+	if sum := coverage["AAA"] + coverage["BBB"]; math.Abs(sum-1) > 0.01 {
+		t.Errorf("expected coverage to add up to 1, got %f", sum)
+	}
+
+	// In the ocean
+	coverage, err = r.CoverageInCell(cellAt(10, 10, 10))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(coverage) != 0 {
+		t.Errorf("expected no coverage, got %v", coverage)
+	}
+
+	if _, err := r.CoverageInCell(s2.CellID(0)); err == nil {
+		t.Error("expected error for invalid cell")
+	}
+}
+
+func TestCoverageInCell_Countries(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test (coverage) in short mode")
+	}
+
+	r, err := New(Countries110)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A cell on the coast of the Caspian Sea, on a face of the index that a
+	// ClosestEdgeQuery doesn't search
+	cell := s2.CellIDFromLatLng(s2.LatLngFromDegrees(45.5, 50)).Parent(6)
+	coverage, err := r.CoverageInCell(cell)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(coverage) != 1 || coverage["RUS"] <= 0 || coverage["RUS"] >= 1 {
+		t.Errorf("expected RUS to partly cover the cell, got %v", coverage)
+	}
+}
+
+func TestCellCovering(t *testing.T) {
+	r, err := New(testDataset(t, lookupTestData))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, maxCells := range []int{1, 4, 16} {
+		covering, err := r.CellCovering("aaa", maxCells)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(covering) > maxCells {
+			t.Errorf("%d: expected at most %d cells, got %d", maxCells, maxCells, len(covering))
+		}
+
+		// Both parts of AAA are covered
+		for _, ll := range []s2.LatLng{
+			s2.LatLngFromDegrees(1, 1),
+			s2.LatLngFromDegrees(0.5, 3.5),
+		} {
+			if !covering.ContainsPoint(s2.PointFromLatLng(ll)) {
+				t.Errorf("%d: expected covering to contain %s", maxCells, ll)
+			}
+		}
+	}
+
+	// With enough cells, BBB isn't covered
+	covering, err := r.CellCovering("AAA", 16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if covering.ContainsPoint(s2.PointFromLatLng(s2.LatLngFromDegrees(1, 11))) {
+		t.Error("expected covering not to contain BBB")
+	}
+
+	if _, err := r.CellCovering("CCC", 8); err != ErrLocationNotFound {
+		t.Errorf("expected error: %s\n got: %v\n", ErrLocationNotFound, err)
+	}
+	if _, err := r.CellCovering("AAA", 0); err == nil {
+		t.Error("expected error for maxCells 0")
+	}
+}
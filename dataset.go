@@ -0,0 +1,180 @@
+package rgeo
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/golang/geo/r1"
+	"github.com/golang/geo/s1"
+	"github.com/golang/geo/s2"
+	"github.com/twpayne/go-geom/encoding/geojson"
+)
+
+// DatasetNamed wraps d so that lookups via ReverseGeocodeWithSource report
+// the given name for its features. The included datasets are already named
+// after their functions, e.g. "Countries10".
+func DatasetNamed(name string, d Dataset) Dataset {
+	return func() []Feature {
+		return withDatasetName(append([]Feature(nil), d()...), name)
+	}
+}
+
+// DatasetFromGeoJSON converts the features of fc with LoadGeoJSON and returns
+// them as a Dataset, which can be passed to New. Bad geometries are reported
+// here, rather than when the Dataset is used.
+func DatasetFromGeoJSON(fc geojson.FeatureCollection) (Dataset, error) {
+	features, err := LoadGeoJSON(fc)
+	if err != nil {
+		return nil, err
+	}
+	return func() []Feature {
+		return features
+	}, nil
+}
+
+// requiredField is a Location field that verifyFeatures checks is set.
+type requiredField struct {
+	name  string
+	value func(Location) string
+}
+
+var (
+	requireCountry      = requiredField{"Country", func(l Location) string { return l.Country }}
+	requireCountryCode3 = requiredField{"CountryCode3", func(l Location) string { return l.CountryCode3 }}
+	requireProvince     = requiredField{"Province", func(l Location) string { return l.Province }}
+	requireCity         = requiredField{"City", func(l Location) string { return l.City }}
+)
+
+// verifyFeatures checks that the polygon of every feature is valid and that
+// the required fields of its Location are set. All problems are returned,
+// each prefixed with name and the index of the feature.
+func verifyFeatures(name string, features []Feature, required ...requiredField) error {
+	var errs []error
+	for i, f := range features {
+		if f.Polygon == nil {
+			errs = append(errs, fmt.Errorf("%s feature %d: no polygon", name, i))
+		} else if err := f.Polygon.Validate(); err != nil {
+			errs = append(errs, fmt.Errorf("%s feature %d (%s): %w", name, i, f.Location, err))
+		}
+
+		for _, r := range required {
+			if v := r.value(f.Location); v == "" || v == "-99" {
+				errs = append(errs, fmt.Errorf("%s feature %d (%s): missing %s",
+					name, i, f.Location, r.name))
+			}
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// withDatasetName sets the dataset name of all features in place.
+func withDatasetName(features []Feature, name string) []Feature {
+	for i := range features {
+		features[i].dataset = name
+	}
+	return features
+}
+
+// FilterDataset returns a Dataset with only the features of d for whose
+// Location keep returns true. This can be used to trim the included datasets
+// when passing them to New, e.g. to only keep African countries:
+//
+//	FilterDataset(Countries10, func(l Location) bool {
+//		return l.Continent == "Africa"
+//	})
+func FilterDataset(d Dataset, keep func(Location) bool) Dataset {
+	return func() []Feature {
+		var features []Feature
+		for _, f := range d() {
+			if keep(f.Location) {
+				features = append(features, f)
+			}
+		}
+		return features
+	}
+}
+
+// DatasetWithin returns a Dataset with only those features of d whose bounding
+// box intersects the window given in degrees, e.g. to load Cities10 for Europe
+// only and save memory. Features partially outside of the window are kept
+// whole. Like for BoundingBox, minLon may be greater than maxLon for a window
+// spanning the antimeridian.
+func DatasetWithin(d Dataset, minLon, minLat, maxLon, maxLat float64) Dataset {
+	window := s2.Rect{
+		Lat: r1.Interval{
+			Lo: (s1.Angle(minLat) * s1.Degree).Radians(),
+			Hi: (s1.Angle(maxLat) * s1.Degree).Radians(),
+		},
+		Lng: s1.IntervalFromEndpoints(
+			(s1.Angle(minLon) * s1.Degree).Radians(),
+			(s1.Angle(maxLon) * s1.Degree).Radians(),
+		),
+	}
+
+	return func() []Feature {
+		var features []Feature
+		for _, f := range d() {
+			if f.Polygon.RectBound().Intersects(window) {
+				features = append(features, f)
+			}
+		}
+		return features
+	}
+}
+
+// MergeDatasets returns a Dataset with all features of prefer, plus those
+// features of others whose CountryCode3 isn't in any of the datasets before
+// them. This is intended for combining country datasets of different
+// resolutions without duplicating countries in the index, e.g.
+// MergeDatasets(Countries10, Countries110) returns just Countries10 with any
+// countries it lacks taken from Countries110.
+//
+// Features without a CountryCode3 (or with Natural Earth's "-99") can't be
+// matched, so they are always kept.
+func MergeDatasets(prefer Dataset, others ...Dataset) Dataset {
+	return func() []Feature {
+		features := append([]Feature(nil), prefer()...)
+		seen := make(map[string]bool)
+		addCodes := func(fs []Feature) {
+			for _, f := range fs {
+				seen[f.Location.CountryCode3] = true
+			}
+		}
+		addCodes(features)
+
+		for _, d := range others {
+			var added []Feature
+			for _, f := range d() {
+				code := f.Location.CountryCode3
+				if code == "" || code == "-99" || !seen[code] {
+					added = append(added, f)
+				}
+			}
+			addCodes(added)
+			features = append(features, added...)
+		}
+
+		return features
+	}
+}
+
+// CachedDataset returns a Dataset that calls d only once and returns the same
+// features on every call after that. This avoids decoding the included
+// datasets again for every call to New, e.g. in tests or when creating an Rgeo
+// per tenant:
+//
+//	var countries = CachedDataset(Countries10)
+//
+// The returned slice is shared by all callers, so it must not be modified.
+func CachedDataset(d Dataset) Dataset {
+	var (
+		once     sync.Once
+		features []Feature
+	)
+	return func() []Feature {
+		once.Do(func() { features = d() })
+		return features
+	}
+}
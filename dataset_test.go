@@ -0,0 +1,192 @@
+package rgeo
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/go-test/deep"
+	"github.com/golang/geo/s2"
+	"github.com/twpayne/go-geom"
+	"github.com/twpayne/go-geom/encoding/geojson"
+)
+
+func TestFilterDataset(t *testing.T) {
+	d := FilterDataset(testDataset(t, distanceTestData), func(l Location) bool {
+		return l.City != "Near"
+	})
+
+	features := d()
+	if len(features) != 3 {
+		t.Fatalf("expected 3 features, got %d", len(features))
+	}
+
+	r, err := New(d)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	loc, err := r.ReverseGeocode(geom.Coord{0.55, 0})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := deep.Equal(Location{CountryCode3: "TST"}, loc); diff != nil {
+		t.Error(diff)
+	}
+}
+
+func TestDatasetWithin(t *testing.T) {
+	d := testDataset(t, `{"type":"FeatureCollection","features":[
+		{"type":"Feature","properties":{"ISO_A3_EH":"AAA"},
+		 "geometry":{"type":"Polygon",
+		  "coordinates":[[[0,0],[2,0],[2,2],[0,2],[0,0]]]}},
+		{"type":"Feature","properties":{"ISO_A3_EH":"BBB"},
+		 "geometry":{"type":"Polygon",
+		  "coordinates":[[[10,0],[12,0],[12,2],[10,2],[10,0]]]}},
+		{"type":"Feature","properties":{"ISO_A3_EH":"CCC"},
+		 "geometry":{"type":"Polygon",
+		  "coordinates":[[[175,0],[179,0],[179,2],[175,2],[175,0]]]}}]}`)
+
+	tests := []struct {
+		name                           string
+		minLon, minLat, maxLon, maxLat float64
+		expected                       []string
+	}{
+		{"Partially inside", 1, 1, 5, 5, []string{"AAA"}},
+		{"Both", -1, -1, 11, 1, []string{"AAA", "BBB"}},
+		{"Outside", 3, 0, 9, 2, nil},
+		{"Wrong latitude", 0, 10, 12, 20, nil},
+		{"Antimeridian", 178, 0, -170, 1, []string{"CCC"}},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			var codes []string
+			for _, f := range DatasetWithin(d, test.minLon, test.minLat, test.maxLon, test.maxLat)() {
+				codes = append(codes, f.Location.CountryCode3)
+			}
+			if diff := deep.Equal(test.expected, codes); diff != nil {
+				t.Error(diff)
+			}
+		})
+	}
+}
+
+func TestMergeDatasets(t *testing.T) {
+	high := testDataset(t, `{"type":"FeatureCollection","features":[
+		{"type":"Feature","properties":{"ISO_A3_EH":"AAA","ADMIN":"high"},
+		 "geometry":{"type":"Polygon",
+		  "coordinates":[[[0,0],[1,0],[1,1],[0,1],[0,0]]]}},
+		{"type":"Feature","properties":{"ISO_A3_EH":"AAA","ADMIN":"high"},
+		 "geometry":{"type":"Polygon",
+		  "coordinates":[[[2,0],[3,0],[3,1],[2,1],[2,0]]]}}]}`)
+	low := testDataset(t, `{"type":"FeatureCollection","features":[
+		{"type":"Feature","properties":{"ISO_A3_EH":"AAA","ADMIN":"low"},
+		 "geometry":{"type":"Polygon",
+		  "coordinates":[[[0,0],[3,0],[3,1],[0,1],[0,0]]]}},
+		{"type":"Feature","properties":{"ISO_A3_EH":"BBB","ADMIN":"low"},
+		 "geometry":{"type":"Polygon",
+		  "coordinates":[[[4,0],[5,0],[5,1],[4,1],[4,0]]]}},
+		{"type":"Feature","properties":{"ISO_A3_EH":"-99","ADMIN":"low"},
+		 "geometry":{"type":"Polygon",
+		  "coordinates":[[[6,0],[7,0],[7,1],[6,1],[6,0]]]}},
+		{"type":"Feature","properties":{"ADMIN":"low"},
+		 "geometry":{"type":"Polygon",
+		  "coordinates":[[[8,0],[9,0],[9,1],[8,1],[8,0]]]}}]}`)
+	lowest := testDataset(t, `{"type":"FeatureCollection","features":[
+		{"type":"Feature","properties":{"ISO_A3_EH":"BBB","ADMIN":"lowest"},
+		 "geometry":{"type":"Polygon",
+		  "coordinates":[[[4,0],[5,0],[5,1],[4,1],[4,0]]]}},
+		{"type":"Feature","properties":{"ISO_A3_EH":"CCC","ADMIN":"lowest"},
+		 "geometry":{"type":"Polygon",
+		  "coordinates":[[[6,0],[7,0],[7,1],[6,1],[6,0]]]}}]}`)
+
+	var result []Location
+	for _, f := range MergeDatasets(high, low, lowest)() {
+		result = append(result, f.Location)
+	}
+
+	expected := []Location{
+		{Country: "high", CountryCode3: "AAA"},
+		{Country: "high", CountryCode3: "AAA"},
+		{Country: "low", CountryCode3: "BBB"},
+		{Country: "low", CountryCode3: "-99"},
+		{Country: "low"},
+		{Country: "lowest", CountryCode3: "CCC"},
+	}
+	if diff := deep.Equal(expected, result); diff != nil {
+		t.Error(diff)
+	}
+}
+
+func TestCachedDataset(t *testing.T) {
+	calls := 0
+	d := CachedDataset(func() []Feature {
+		calls++
+		return testDataset(t, lookupTestData)()
+	})
+
+	for i := 0; i < 2; i++ {
+		r, err := New(d)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := r.ReverseGeocode([]float64{1, 1}); err != nil {
+			t.Error(err)
+		}
+	}
+
+	if calls != 1 {
+		t.Errorf("expected dataset to be decoded once, got %d calls", calls)
+	}
+	if a, b := d(), d(); &a[0] != &b[0] {
+		t.Error("expected the same features to be returned")
+	}
+}
+
+func TestDatasetFromGeoJSON(t *testing.T) {
+	var fc geojson.FeatureCollection
+	if err := json.Unmarshal([]byte(`{"type":"FeatureCollection","features":[
+		{"type":"Feature","geometry":{"type":"Point","coordinates":[0,0]}}]}`), &fc); err != nil {
+		t.Fatalf("decode GeoJSON: %s", err)
+	}
+
+	expected := "bad polygon in geometry of feature 0: needs Polygon or MultiPolygon"
+	if _, err := DatasetFromGeoJSON(fc); err == nil || err.Error() != expected {
+		t.Errorf("expected error: %s\n got: %v\n", expected, err)
+	}
+}
+
+func TestVerifyFeatures(t *testing.T) {
+	// The repeated vertex of Beta is dropped during conversion
+	features := testDataset(t, `{"type":"FeatureCollection","features":[
+		{"type":"Feature","properties":{"ADMIN":"Alpha"},
+		 "geometry":{"type":"Polygon",
+		  "coordinates":[[[0,0],[1,0],[1,1],[0,1],[0,0]]]}},
+		{"type":"Feature","properties":{"ADMIN":"Beta"},
+		 "geometry":{"type":"Polygon",
+		  "coordinates":[[[2,0],[3,0],[3,0],[3,1],[2,1],[2,0]]]}},
+		{"type":"Feature","properties":{"ADMIN":"-99"},
+		 "geometry":{"type":"Polygon",
+		  "coordinates":[[[4,0],[5,0],[5,1],[4,1],[4,0]]]}}]}`)()
+
+	features = append(features, Feature{
+		Location: Location{Country: "Delta"},
+		Polygon: s2.PolygonFromLoops([]*s2.Loop{s2.LoopFromPoints([]s2.Point{
+			s2.PointFromLatLng(s2.LatLngFromDegrees(0, 6)),
+			s2.PointFromLatLng(s2.LatLngFromDegrees(0, 7)),
+			s2.PointFromLatLng(s2.LatLngFromDegrees(0, 7)),
+			s2.PointFromLatLng(s2.LatLngFromDegrees(1, 7)),
+		})}),
+	})
+
+	if err := verifyFeatures("Test", features[:2], requireCountry); err != nil {
+		t.Errorf("expected no error, got: %s", err)
+	}
+
+	expected := "Test feature 2 (<Location> -99,): missing Country\n" +
+		"Test feature 3 (<Location> Delta,): loop 0: edge 1 is degenerate (duplicate vertex)"
+	if err := verifyFeatures("Test", features, requireCountry); err == nil || err.Error() != expected {
+		t.Errorf("expected error: %s\n got: %v\n", expected, err)
+	}
+}
@@ -0,0 +1,455 @@
+package rgeo
+
+import (
+	"errors"
+	"math"
+	"slices"
+	"sync"
+
+	"github.com/golang/geo/s1"
+	"github.com/golang/geo/s2"
+	"github.com/twpayne/go-geom"
+)
+
+// earthRadiusKM is the mean radius of the Earth in kilometers.
+const earthRadiusKM = 6371
+
+// distanceLimit converts a distance in kilometers on the sphere (see Radius)
+// to the angle limiting the edge queries on the index, so that all methods
+// taking a distance agree on what is in range. The distances they
+// return are great-circle distances, so the angle is exact, and distances
+// beyond half the circumference include the whole sphere.
+func (r *Rgeo) distanceLimit(d float64) s1.Angle {
+	return s1.Angle(math.Min(d/r.radius, math.Pi))
+}
+
+// Radius returns the radius of the sphere in kilometers that is used for all
+// distances, which is the Earth's unless set by SetSnappingDistanceCustom.
+func (r *Rgeo) Radius() float64 {
+	return r.radius
+}
+
+// SnappingDistanceKM returns the snapping distance of ReverseGeocodeSnapping in
+// kilometers, as set by SetSnappingDistanceEarth or SetSnappingDistanceCustom.
+func (r *Rgeo) SnappingDistanceKM() float64 {
+	return r.snappingDistance
+}
+
+// ChordAngleToKM converts an s2 ChordAngle, e.g. from a query on the
+// ShapeIndex, to the distance on the surface of the sphere in kilometers.
+func (r *Rgeo) ChordAngleToKM(a s1.ChordAngle) float64 {
+	return a.Angle().Radians() * r.radius
+}
+
+// CitiesWithinRadius returns the Locations of all cities whose polygon is
+// within radiusKM kilometers (see Radius) of the given coordinate, closest
+// first. Cities containing the coordinate have a distance of zero.
+//
+// Only shapes with a City are considered, so one of the datasets has to be
+// Cities10 or similar. If no city is in range ErrLocationNotFound is returned.
+func (r *Rgeo) CitiesWithinRadius(coord geom.Coord, radiusKM float64) ([]Location, error) {
+	if radiusKM < 0 {
+		return nil, errors.New("radius must not be negative")
+	} else if err := validateCoord(coord); err != nil {
+		return nil, err
+	} else if err := r.checkBuilt(); err != nil {
+		return nil, err
+	}
+
+	var locs []Location
+	for _, res := range closestShapes(r.index, pointFromCoord(coord), r.distanceLimit(radiusKM), true, isCity) {
+		locs = append(locs, res.shape.loc)
+	}
+	if len(locs) == 0 {
+		return nil, ErrLocationNotFound
+	}
+
+	return locs, nil
+}
+
+// isCity reports whether the shape is a city, i.e. has a City.
+func isCity(s *shape) bool {
+	return s.loc.City != ""
+}
+
+// hasCountry reports whether the shape has a Country.
+func hasCountry(s *shape) bool {
+	return s.loc.Country != ""
+}
+
+// nearestCitiesRadius is the smallest radius of the queries of NearestCities,
+// about 6km on the Earth.
+const nearestCitiesRadius = s1.Angle(0.001)
+
+// nearestCitiesGrowth is the factor by which NearestCities grows the radius
+// of its queries. Each query returns all edges in range, so a larger factor
+// takes fewer queries, but may return many more cities than needed.
+const nearestCitiesGrowth = 1.1
+
+// lazyIndex is a shape index built on first use, see cityIndex.
+type lazyIndex struct {
+	once  sync.Once
+	index *s2.ShapeIndex
+}
+
+// cityIndex returns an index of the shapes with a City, building it on the
+// first call, so that NearestCities doesn't have to query all other edges.
+func (r *Rgeo) cityIndex() *s2.ShapeIndex {
+	cities := r.cities
+	cities.once.Do(func() {
+		cities.index = s2.NewShapeIndex()
+		for _, s := range r.matchingShapes(func(l Location) bool { return l.City != "" }) {
+			cities.index.Add(s)
+		}
+		cities.index.Build()
+	})
+
+	return cities.index
+}
+
+// NearestCities returns the Locations of up to k cities closest to the given
+// coordinate, closest first, and their distances in kilometers (see Radius).
+// Cities containing the coordinate have a distance of zero. Each Location is
+// combined with the features containing the closest point of the city, like
+// ReverseGeocode does, so it has the country of the city if a country dataset
+// is loaded. Cities made up of several polygons are only returned once.
+//
+// The search isn't limited to the snapping distance, ErrLocationNotFound is
+// only returned if no city is loaded. The first call builds an index of the
+// cities, which is reused until AddDataset is called.
+func (r *Rgeo) NearestCities(coord geom.Coord, k int) ([]Location, []float64, error) {
+	if k <= 0 {
+		return nil, nil, errors.New("number of cities must be positive")
+	} else if err := validateCoord(coord); err != nil {
+		return nil, nil, err
+	} else if err := r.checkBuilt(); err != nil {
+		return nil, nil, err
+	}
+
+	var (
+		locs      []Location
+		distances []float64
+		point     = pointFromCoord(coord)
+		index     = r.cityIndex()
+	)
+
+	// A query for all edges in range is only fast for small ranges, so it
+	// starts at the closest city, and the radius is grown until k cities are in
+	// it, as they are then the closest
+	opts := s2.NewClosestEdgeQueryOptions().MaxResults(1)
+	nearest := s2.NewClosestEdgeQuery(index, opts).FindEdges(s2.NewMinDistanceToPointTarget(point))
+	if len(nearest) == 0 {
+		return nil, nil, ErrLocationNotFound
+	}
+
+	start := max(nearest[0].Distance().Angle(), nearestCitiesRadius)
+	for radius := start; ; radius = min(nearestCitiesGrowth*radius, math.Pi) {
+		found := closestShapes(index, point, radius, true, nil)
+		if len(found) < k && radius < math.Pi {
+			continue
+		}
+
+		locs, distances = locs[:0], distances[:0]
+		for _, res := range found {
+			closest := point
+			if res.edge >= 0 {
+				e := res.shape.Edge(res.edge)
+				closest = s2.Project(point, e.V0, e.V1)
+			}
+
+			// Cities are found closest first, so a repeated one is further away
+			if loc := r.cityLocation(res.shape, closest); !slices.Contains(locs, loc) {
+				locs = append(locs, loc)
+				distances = append(distances, r.ChordAngleToKM(res.distance))
+				if len(locs) == k {
+					break
+				}
+			}
+		}
+		if len(locs) == k || radius >= math.Pi {
+			break
+		}
+	}
+	if len(locs) == 0 {
+		return nil, nil, ErrLocationNotFound
+	}
+
+	return locs, distances, nil
+}
+
+// shapeDistance is the closest result of a shape, see closestShapes.
+type shapeDistance struct {
+	shape *shape
+	// edge is the closest edge of the shape, or -1 if the shape contains the
+	// point and interiors are included.
+	edge     int
+	distance s1.ChordAngle
+}
+
+// closestShapes returns the closest result of each shape of the index within
+// limit of p, closest first and by shape ID for equal distances, as found by a
+// ClosestEdgeQuery. If interiors is set, shapes containing p have a distance
+// of zero, otherwise the distance to a shape is that to its closest edge.
+// Shapes for which match returns false are skipped, a nil match accepts all
+// shapes.
+func closestShapes(index *s2.ShapeIndex, p s2.Point, limit s1.Angle, interiors bool, match func(*shape) bool) []shapeDistance {
+	opts := s2.NewClosestEdgeQueryOptions().
+		IncludeInteriors(interiors).
+		DistanceLimit(s1.ChordAngleFromAngle(limit).Successor())
+	query := s2.NewClosestEdgeQuery(index, opts)
+
+	// Results are sorted by distance, but each shape can have many edges
+	var (
+		closest []shapeDistance
+		seen    = make(map[int32]bool)
+	)
+	for _, res := range query.FindEdges(s2.NewMinDistanceToPointTarget(p)) {
+		id := res.ShapeID()
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+
+		if s := index.Shape(id).(*shape); match == nil || match(s) {
+			closest = append(closest, shapeDistance{s, int(res.EdgeID()), res.Distance()})
+		}
+	}
+
+	return closest
+}
+
+// cityLocation returns the Location of the city shape combined with the
+// shapes above the city level containing p.
+func (r *Rgeo) cityLocation(city s2.Shape, p s2.Point) Location {
+	shapes := []s2.Shape{city}
+	containing, _ := r.containingShapes(p)
+	for _, s := range containing {
+		if adminLevel(s.(shapeLocation).Location()) < 2 {
+			shapes = append(shapes, s)
+		}
+	}
+	return r.combineLocations(shapes)
+}
+
+// ForEachFeatureWithinRadius calls fn with the Location and distance in
+// kilometers (see Radius) of every shape within radiusKM kilometers of the
+// given coordinate, closest first, until fn returns false. Shapes containing
+// the coordinate have a distance of zero. Unlike CitiesWithinRadius, shapes at
+// all levels are included, and no slice of Locations is allocated.
+func (r *Rgeo) ForEachFeatureWithinRadius(coord geom.Coord, radiusKM float64, fn func(Location, float64) bool) error {
+	if radiusKM < 0 {
+		return errors.New("radius must not be negative")
+	} else if err := validateCoord(coord); err != nil {
+		return err
+	} else if err := r.checkBuilt(); err != nil {
+		return err
+	}
+
+	for _, res := range closestShapes(r.index, pointFromCoord(coord), r.distanceLimit(radiusKM), true, nil) {
+		if !fn(res.shape.loc, r.ChordAngleToKM(res.distance)) {
+			break
+		}
+	}
+
+	return nil
+}
+
+// NearestBorderSegment returns the endpoints of the polygon edge closest to
+// the given coordinate, along with the Location of the polygon it belongs to.
+// Unlike ReverseGeocodeSnapping this isn't limited to the snapping distance,
+// and it also finds the closest edge for coordinates inside a polygon.
+func (r *Rgeo) NearestBorderSegment(coord geom.Coord) (geom.Coord, geom.Coord, Location, error) {
+	s, edge, err := r.nearestEdge(coord)
+	if err != nil {
+		return nil, nil, Location{}, err
+	}
+
+	return coordFromPoint(edge.V0), coordFromPoint(edge.V1),
+		s.(shapeLocation).Location(), nil
+}
+
+// SnapToBorder returns the closest point to the given coordinate on the
+// border of any polygon, e.g. to move a buoy onto the coastline, along with
+// the Location of the polygon. The point is on the great circle segment of
+// the closest edge, as found by NearestBorderSegment, so it's neither limited
+// to the snapping distance nor to coordinates outside of the polygons.
+func (r *Rgeo) SnapToBorder(coord geom.Coord) (geom.Coord, Location, error) {
+	s, edge, err := r.nearestEdge(coord)
+	if err != nil {
+		return nil, Location{}, err
+	}
+
+	p := s2.Project(pointFromCoord(coord), edge.V0, edge.V1)
+	return coordFromPoint(p),
+		s.(shapeLocation).Location(), nil
+}
+
+// nearestEdge returns the polygon edge closest to coord and the shape it
+// belongs to.
+func (r *Rgeo) nearestEdge(coord geom.Coord) (s2.Shape, s2.Edge, error) {
+	if err := validateCoord(coord); err != nil {
+		return nil, s2.Edge{}, err
+	} else if err := r.checkBuilt(); err != nil {
+		return nil, s2.Edge{}, err
+	}
+
+	opts := s2.NewClosestEdgeQueryOptions().
+		MaxResults(1).
+		IncludeInteriors(false)
+	query := s2.NewClosestEdgeQuery(r.index, opts)
+	res := query.FindEdges(s2.NewMinDistanceToPointTarget(pointFromCoord(coord)))
+	if len(res) == 0 {
+		return nil, s2.Edge{}, ErrLocationNotFound
+	}
+
+	s := r.index.Shape(res[0].ShapeID())
+	return s, s.Edge(int(res[0].EdgeID())), nil
+}
+
+// OnBorder reports whether the given coordinate is within toleranceKM
+// kilometers (see Radius) of the border of a country, e.g. to flag ambiguous
+// coordinates for review. It returns the Locations of the polygons with a
+// border in range, closest first and only one for each country, so a point on
+// a coastline is reported with a single Location. Polygons without a Country,
+// such as those of Cities10, are ignored.
+//
+// Unlike ReverseGeocodeSnapping, this also considers points inside of a
+// polygon and doesn't use the snapping distance.
+func (r *Rgeo) OnBorder(coord geom.Coord, toleranceKM float64) (bool, []Location, error) {
+	if toleranceKM < 0 {
+		return false, nil, errors.New("tolerance must not be negative")
+	} else if err := validateCoord(coord); err != nil {
+		return false, nil, err
+	} else if err := r.checkBuilt(); err != nil {
+		return false, nil, err
+	}
+
+	var (
+		locs []Location
+		seen = make(map[string]bool)
+	)
+	for _, res := range closestShapes(r.index, pointFromCoord(coord), r.distanceLimit(toleranceKM), false, hasCountry) {
+		if loc := res.shape.loc; !seen[loc.Country] {
+			seen[loc.Country] = true
+			locs = append(locs, loc)
+		}
+	}
+
+	return len(locs) > 0, locs, nil
+}
+
+// ReverseGeocodeWithUncertainty returns the countries whose polygons intersect
+// the circle of radiusKM kilometers (see Radius) around the given coordinate,
+// e.g. the error radius of a GPS fix, closest first. Near a border this
+// returns the countries on both sides, rather than just the one containing the
+// coordinate. The Locations have only the country fields, and each is returned
+// once, e.g. for a country made up of several polygons or its provinces.
+//
+// Polygons without a Country, such as those of Cities10, are ignored. If no
+// country is in range ErrLocationNotFound is returned.
+func (r *Rgeo) ReverseGeocodeWithUncertainty(coord geom.Coord, radiusKM float64) ([]Location, error) {
+	if radiusKM < 0 {
+		return nil, errors.New("radius must not be negative")
+	} else if err := validateCoord(coord); err != nil {
+		return nil, err
+	} else if err := r.checkBuilt(); err != nil {
+		return nil, err
+	}
+
+	var (
+		locs []Location
+		seen = make(map[Location]bool)
+	)
+	for _, res := range closestShapes(r.index, pointFromCoord(coord), r.distanceLimit(radiusKM), true, hasCountry) {
+		if loc := countryFields(res.shape.loc); !seen[loc] {
+			seen[loc] = true
+			locs = append(locs, loc)
+		}
+	}
+	if len(locs) == 0 {
+		return nil, ErrLocationNotFound
+	}
+
+	return locs, nil
+}
+
+// SnapCandidate is a Location near a coordinate, see SnapCandidates.
+type SnapCandidate struct {
+	Location Location
+	// DistanceKM is the distance to the closest edge of the polygon in
+	// kilometers (see Radius), or zero if the polygon contains the coordinate.
+	DistanceKM float64
+}
+
+// SnapCandidates returns up to k Locations within the snapping distance of the
+// given coordinate, closest first, with one candidate per polygon. Polygons
+// containing the coordinate come first, with a distance of zero.
+//
+// ReverseGeocodeSnapping only uses the closest polygon, which for a point in a
+// narrow strait can be the wrong side. SnapCandidates lets the caller decide
+// instead, e.g. by preferring a smaller island if it's nearly as close.
+//
+// Unlike ReverseGeocodeSnapping the Locations of overlapping datasets aren't
+// combined. If no polygon is in range ErrLocationNotFound is returned.
+func (r *Rgeo) SnapCandidates(coord geom.Coord, k int) ([]SnapCandidate, error) {
+	if k <= 0 {
+		return nil, errors.New("number of candidates must be positive")
+	} else if err := validateCoord(coord); err != nil {
+		return nil, err
+	} else if err := r.checkBuilt(); err != nil {
+		return nil, err
+	}
+
+	var candidates []SnapCandidate
+	for _, res := range closestShapes(r.index, pointFromCoord(coord), r.distanceLimit(r.snappingDistance), true, nil) {
+		candidates = append(candidates, SnapCandidate{
+			Location:   res.shape.loc,
+			DistanceKM: r.ChordAngleToKM(res.distance),
+		})
+		if len(candidates) == k {
+			break
+		}
+	}
+
+	if len(candidates) == 0 {
+		return nil, ErrLocationNotFound
+	}
+
+	return candidates, nil
+}
+
+// NearestFeature returns the Location of the feature closest to the given
+// coordinate in any of the loaded datasets, along with its administrative
+// Level and the distance in kilometers (see Radius). Unlike
+// ReverseGeocodeSnapping this isn't limited to the snapping distance.
+//
+// If features contain the coordinate, the distance is zero, the Level is that
+// of the most specific of them, and the Location combines them like
+// ReverseGeocode. ErrLocationNotFound is only returned if no features are
+// loaded.
+func (r *Rgeo) NearestFeature(coord geom.Coord) (Location, Level, float64, error) {
+	res, err := r.containingShapesAt(coord)
+	if err != nil {
+		return Location{}, 0, 0, err
+	}
+
+	if len(res) > 0 {
+		level := LevelCountry
+		for _, s := range res {
+			if l := Level(adminLevel(s.(shapeLocation).Location())); l > level {
+				level = l
+			}
+		}
+		return r.combineLocations(res), level, 0, nil
+	}
+
+	opts := s2.NewClosestEdgeQueryOptions().MaxResults(1)
+	query := s2.NewClosestEdgeQuery(r.index, opts)
+	edges := query.FindEdges(s2.NewMinDistanceToPointTarget(pointFromCoord(coord)))
+	if len(edges) == 0 {
+		return Location{}, 0, 0, ErrLocationNotFound
+	}
+
+	loc := r.index.Shape(edges[0].ShapeID()).(*shape).loc
+	return loc, Level(adminLevel(loc)), r.ChordAngleToKM(edges[0].Distance()), nil
+}
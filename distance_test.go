@@ -0,0 +1,908 @@
+package rgeo
+
+import (
+	"errors"
+	"math"
+	"math/rand"
+	"sort"
+	"testing"
+
+	"github.com/go-test/deep"
+	"github.com/golang/geo/s1"
+	"github.com/golang/geo/s2"
+	"github.com/twpayne/go-geom"
+)
+
+// distanceTestData has a country with three cities roughly 0km, 55km and 333km
+// from the origin.
+const distanceTestData = `{"type":"FeatureCollection","features":[
+	{"type":"Feature","properties":{"ISO_A3_EH":"TST"},
+	 "geometry":{"type":"Polygon",
+	  "coordinates":[[[-1,-1],[4,-1],[4,1],[-1,1],[-1,-1]]]}},
+	{"type":"Feature","properties":{"name_conve":"In"},
+	 "geometry":{"type":"Polygon",
+	  "coordinates":[[[-0.1,-0.1],[0.1,-0.1],[0.1,0.1],[-0.1,0.1],[-0.1,-0.1]]]}},
+	{"type":"Feature","properties":{"name_conve":"Near"},
+	 "geometry":{"type":"Polygon",
+	  "coordinates":[[[0.5,-0.1],[0.6,-0.1],[0.6,0.1],[0.5,0.1],[0.5,-0.1]]]}},
+	{"type":"Feature","properties":{"name_conve":"Far"},
+	 "geometry":{"type":"Polygon",
+	  "coordinates":[[[3,-0.1],[3.1,-0.1],[3.1,0.1],[3,0.1],[3,-0.1]]]}}]}`
+
+func TestCitiesWithinRadius(t *testing.T) {
+	r, err := New(testDataset(t, distanceTestData))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		in       geom.Coord
+		radius   float64
+		err      error
+		expected []Location
+	}{
+		{
+			name:     "Containing only",
+			in:       geom.Coord{0, 0},
+			radius:   10,
+			expected: []Location{{City: "In"}},
+		},
+		{
+			name:     "Near",
+			in:       geom.Coord{0, 0},
+			radius:   100,
+			expected: []Location{{City: "In"}, {City: "Near"}},
+		},
+		{
+			name:   "All",
+			in:     geom.Coord{0, 0},
+			radius: 500,
+			expected: []Location{
+				{City: "In"}, {City: "Near"}, {City: "Far"},
+			},
+		},
+		{
+			name:     "Closest first",
+			in:       geom.Coord{3.5, 0},
+			radius:   500,
+			expected: []Location{{City: "Far"}, {City: "Near"}, {City: "In"}},
+		},
+		{
+			// Far is a quarter of the circumference, about 10008km, away
+			name:     "Large radius",
+			in:       geom.Coord{-87, 0},
+			radius:   10000,
+			expected: []Location{{City: "In"}, {City: "Near"}},
+		},
+		{
+			name:   "Just inside large radius",
+			in:     geom.Coord{-87, 0},
+			radius: 10010,
+			expected: []Location{
+				{City: "In"}, {City: "Near"}, {City: "Far"},
+			},
+		},
+		{
+			name:   "None",
+			in:     geom.Coord{10, 10},
+			radius: 10,
+			err:    ErrLocationNotFound,
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			result, err := r.CitiesWithinRadius(test.in, test.radius)
+			if err != test.err {
+				t.Errorf("expected error: %s\n got: %s\n", test.err, err)
+			}
+			if diff := deep.Equal(test.expected, result); diff != nil {
+				t.Error(diff)
+			}
+		})
+	}
+
+	if _, err := r.CitiesWithinRadius(geom.Coord{0, 0}, -1); err == nil {
+		t.Error("expected error for negative radius")
+	}
+}
+
+func TestCitiesWithinRadius_BruteForce(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test (cities within radius) in short mode")
+	}
+	t.Parallel()
+
+	r, err := New(Cities10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Points within about 50km of random cities
+	rnd := rand.New(rand.NewSource(1))
+	for i := 0; i < 5; i++ {
+		v := s2.LatLngFromPoint(r.index.Shape(int32(rnd.Intn(r.index.Len()))).Edge(0).V0)
+		p := s2.PointFromLatLng(s2.LatLngFromDegrees(
+			v.Lat.Degrees()+rnd.Float64()-0.5,
+			v.Lng.Degrees()+rnd.Float64()-0.5,
+		))
+		coord := coordFromPoint(p)
+
+		var expected []string
+		for id, d := range bruteForceDistances(r, p) {
+			if d.Radians()*r.Radius() <= 100 {
+				expected = append(expected, r.index.Shape(id).(*shape).loc.City)
+			}
+		}
+
+		locs, err := r.CitiesWithinRadius(coord, 100)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var cities []string
+		for _, l := range locs {
+			cities = append(cities, l.City)
+		}
+
+		sort.Strings(expected)
+		sort.Strings(cities)
+		if diff := deep.Equal(expected, cities); diff != nil {
+			t.Errorf("%v: %v", coord, diff)
+		}
+	}
+}
+
+func TestForEachFeatureWithinRadius(t *testing.T) {
+	r, err := New(testDataset(t, distanceTestData))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		locs      []Location
+		distances []float64
+	)
+	err = r.ForEachFeatureWithinRadius(geom.Coord{0.35, 0}, 500, func(loc Location, d float64) bool {
+		locs = append(locs, loc)
+		distances = append(distances, d)
+		return len(locs) < 2
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if diff := deep.Equal([]Location{{CountryCode3: "TST"}, {City: "Near"}}, locs); diff != nil {
+		t.Error(diff)
+	}
+	// Near is 0.15 degrees away
+	if len(distances) != 2 || distances[0] != 0 || math.Abs(distances[1]-16.7) > 0.1 {
+		t.Errorf("expected distances 0 and 16.7, got %v", distances)
+	}
+
+	called := false
+	err = r.ForEachFeatureWithinRadius(geom.Coord{10, 10}, 10, func(Location, float64) bool {
+		called = true
+		return true
+	})
+	if err != nil || called {
+		t.Errorf("expected no calls and no error, got %t and %v", called, err)
+	}
+
+	if err := r.ForEachFeatureWithinRadius(geom.Coord{0, 0}, -1, nil); err == nil {
+		t.Error("expected error for negative radius")
+	}
+}
+
+func TestForEachFeatureWithinRadius_Countries110(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test (radius) in short mode")
+	}
+
+	r, err := New(Countries110)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	paris := geom.Coord{2.35, 48.85}
+	for _, radius := range []float64{1000, 5000, 15000} {
+		var farthest float64
+		err := r.ForEachFeatureWithinRadius(paris, radius, func(_ Location, d float64) bool {
+			farthest = d
+			return true
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if farthest > radius || farthest < 0.9*radius {
+			t.Errorf("%gkm: expected the farthest feature close to the radius, got %gkm", radius, farthest)
+		}
+	}
+
+	// Half the circumference reaches every feature
+	n := 0
+	err = r.ForEachFeatureWithinRadius(paris, 20100, func(Location, float64) bool {
+		n++
+		return true
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := r.index.Len(); n != expected {
+		t.Errorf("expected all %d features, got %d", expected, n)
+	}
+}
+
+func TestRadius(t *testing.T) {
+	r, err := New(testDataset(t, distanceTestData))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if r.Radius() != earthRadiusKM {
+		t.Errorf("expected Earth radius %d, got %f", earthRadiusKM, r.Radius())
+	}
+	if r.SnappingDistanceKM() != 5 {
+		t.Errorf("expected default snapping distance 5km, got %f", r.SnappingDistanceKM())
+	}
+	if d := r.ChordAngleToKM(s1.ChordAngleFromAngle(s1.Angle(100 / r.Radius()))); math.Abs(d-100) > 1e-6 {
+		t.Errorf("expected 100km, got %f", d)
+	}
+	// Radii are converted exactly, up to half the circumference
+	if limit := r.ChordAngleToKM(s1.ChordAngleFromAngle(r.distanceLimit(100))); math.Abs(limit-100) > 1e-6 {
+		t.Errorf("expected limit 100km, got %f", limit)
+	}
+	if limit := r.distanceLimit(50000); limit != math.Pi {
+		t.Errorf("expected limit of pi, got %v", limit)
+	}
+
+	locs, err := r.CitiesWithinRadius(geom.Coord{0, 0}, 40)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := deep.Equal([]Location{{City: "In"}}, locs); diff != nil {
+		t.Error(diff)
+	}
+
+	// On Mars the Near city is only about 30km away, rather than 55km
+	const marsRadiusKM = 3389.5
+	r.SetSnappingDistanceCustom(7, marsRadiusKM)
+	if r.Radius() != marsRadiusKM {
+		t.Errorf("expected Mars radius %f, got %f", marsRadiusKM, r.Radius())
+	}
+	if r.SnappingDistanceKM() != 7 {
+		t.Errorf("expected snapping distance 7km, got %f", r.SnappingDistanceKM())
+	}
+
+	locs, err = r.CitiesWithinRadius(geom.Coord{0, 0}, 40)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := deep.Equal([]Location{{City: "In"}, {City: "Near"}}, locs); diff != nil {
+		t.Error(diff)
+	}
+}
+
+func TestNearestBorderSegment(t *testing.T) {
+	r, err := New(testDataset(t, distanceTestData))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		in       geom.Coord
+		a, b     geom.Coord
+		expected Location
+	}{
+		{
+			name:     "Offshore",
+			in:       geom.Coord{2, 1.5},
+			a:        geom.Coord{4, 1},
+			b:        geom.Coord{-1, 1},
+			expected: Location{CountryCode3: "TST"},
+		},
+		{
+			name:     "Inside",
+			in:       geom.Coord{2, 0.5},
+			a:        geom.Coord{4, 1},
+			b:        geom.Coord{-1, 1},
+			expected: Location{CountryCode3: "TST"},
+		},
+		{
+			name:     "City",
+			in:       geom.Coord{0.05, 0},
+			a:        geom.Coord{0.1, -0.1},
+			b:        geom.Coord{0.1, 0.1},
+			expected: Location{City: "In"},
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			a, b, loc, err := r.NearestBorderSegment(test.in)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !coordsClose(a, test.a) || !coordsClose(b, test.b) {
+				t.Errorf("expected segment %v-%v, got %v-%v",
+					test.a, test.b, a, b)
+			}
+			if diff := deep.Equal(test.expected, loc); diff != nil {
+				t.Error(diff)
+			}
+		})
+	}
+}
+
+func TestNearestBorderSegment_BruteForce(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test (nearest border) in short mode")
+	}
+
+	r, err := New(Countries110)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rnd := rand.New(rand.NewSource(1))
+	for i := 0; i < 200; i++ {
+		coord := geom.Coord{rnd.Float64()*360 - 180, rnd.Float64()*180 - 90}
+		p := pointFromCoord(coord)
+
+		// The closest edge of all shapes
+		expected := s1.InfAngle()
+		for _, s := range r.shapes() {
+			for j := 0; j < s.NumEdges(); j++ {
+				e := s.Edge(j)
+				expected = min(expected, s2.DistanceFromSegment(p, e.V0, e.V1))
+			}
+		}
+
+		a, b, _, err := r.NearestBorderSegment(coord)
+		if err != nil {
+			t.Fatal(err)
+		}
+		d := s2.DistanceFromSegment(p, pointFromCoord(a), pointFromCoord(b))
+		if math.Abs(float64(d-expected)) > 1e-9 {
+			t.Errorf("%v: expected edge at %g km, got %g km", coord,
+				r.ChordAngleToKM(s1.ChordAngleFromAngle(expected)), r.ChordAngleToKM(s1.ChordAngleFromAngle(d)))
+		}
+
+		// NearestFeature uses the same edge for points outside of all countries
+		if _, err := r.ReverseGeocode(coord); err == nil {
+			continue
+		}
+		_, _, km, err := r.NearestFeature(coord)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if expectedKM := expected.Radians() * r.Radius(); math.Abs(km-expectedKM) > 1e-6 {
+			t.Errorf("%v: expected nearest feature at %g km, got %g km", coord, expectedKM, km)
+		}
+	}
+}
+
+func TestSnapToBorder(t *testing.T) {
+	r, err := New(testDataset(t, distanceTestData))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		in       geom.Coord
+		snapped  geom.Coord
+		expected Location
+	}{
+		// The edge is a great circle, which bulges north of latitude 1
+		{"Offshore", geom.Coord{2, 1.5}, geom.Coord{1.999924, 1.000914}, Location{CountryCode3: "TST"}},
+		{"East", geom.Coord{5, 0}, geom.Coord{4, 0}, Location{CountryCode3: "TST"}},
+		{"Inside", geom.Coord{3.8, 0}, geom.Coord{4, 0}, Location{CountryCode3: "TST"}},
+		{"City", geom.Coord{0.05, 0}, geom.Coord{0.1, 0}, Location{City: "In"}},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			snapped, loc, err := r.SnapToBorder(test.in)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if math.Abs(snapped.X()-test.snapped.X()) > 1e-6 ||
+				math.Abs(snapped.Y()-test.snapped.Y()) > 1e-6 {
+				t.Errorf("expected %v, got %v", test.snapped, snapped)
+			}
+			if diff := deep.Equal(test.expected, loc); diff != nil {
+				t.Error(diff)
+			}
+		})
+	}
+
+	if _, _, err := r.SnapToBorder(geom.Coord{0, 100}); !errors.Is(err, ErrInvalidCoordinate) {
+		t.Errorf("expected error: %s\n got: %v\n", ErrInvalidCoordinate, err)
+	}
+}
+
+// coordsClose reports whether the coordinates are equal, apart from floating
+// point errors.
+func coordsClose(a, b geom.Coord) bool {
+	const eps = 1e-9
+	return math.Abs(a.X()-b.X()) < eps && math.Abs(a.Y()-b.Y()) < eps
+}
+
+func TestSnapCandidates(t *testing.T) {
+	// A small island of Alpha and the coast of Beta, with a strait of about
+	// 2.2km between them
+	r, err := New(testDataset(t, `{"type":"FeatureCollection","features":[
+		{"type":"Feature","properties":{"ADMIN":"Alpha"},
+		 "geometry":{"type":"Polygon",
+		  "coordinates":[[[0.9,0.4],[1,0.4],[1,0.6],[0.9,0.6],[0.9,0.4]]]}},
+		{"type":"Feature","properties":{"ADMIN":"Beta"},
+		 "geometry":{"type":"Polygon",
+		  "coordinates":[[[1.02,0],[1.02,0.25],[1.02,0.5],[1.02,0.75],[1.02,1],
+		   [3,1],[3,0],[1.02,0]]]}}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		in        geom.Coord
+		k         int
+		err       error
+		expected  []string
+		distances []float64
+	}{
+		{
+			name:      "Strait",
+			in:        geom.Coord{1.012, 0.5},
+			k:         2,
+			expected:  []string{"Beta", "Alpha"},
+			distances: []float64{0.89, 1.33},
+		},
+		{
+			name:      "Closest only",
+			in:        geom.Coord{1.008, 0.5},
+			k:         1,
+			expected:  []string{"Alpha"},
+			distances: []float64{0.89},
+		},
+		{
+			name:      "Contained",
+			in:        geom.Coord{0.99, 0.5},
+			k:         5,
+			expected:  []string{"Alpha", "Beta"},
+			distances: []float64{0, 3.34},
+		},
+		{
+			name: "Out of range",
+			in:   geom.Coord{1, 2},
+			k:    2,
+			err:  ErrLocationNotFound,
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			result, err := r.SnapCandidates(test.in, test.k)
+			if err != test.err {
+				t.Errorf("expected error: %s\n got: %s\n", test.err, err)
+			}
+
+			var names []string
+			for i, c := range result {
+				names = append(names, c.Location.Country)
+				if i < len(test.distances) && math.Abs(c.DistanceKM-test.distances[i]) > 0.01 {
+					t.Errorf("expected %s at %.2fkm, got %.2fkm",
+						c.Location.Country, test.distances[i], c.DistanceKM)
+				}
+			}
+			if diff := deep.Equal(test.expected, names); diff != nil {
+				t.Error(diff)
+			}
+		})
+	}
+}
+
+func TestSnapCandidates_BruteForce(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test (snap candidates) in short mode")
+	}
+
+	r, err := New(Countries110)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.SetSnappingDistanceEarth(200)
+
+	rnd := rand.New(rand.NewSource(1))
+	for i := 0; i < 500; i++ {
+		coord := geom.Coord{rnd.Float64()*360 - 180, rnd.Float64()*180 - 90}
+
+		var expected []float64
+		for _, d := range bruteForceDistances(r, pointFromCoord(coord)) {
+			if km := d.Radians() * r.Radius(); km <= r.SnappingDistanceKM() {
+				expected = append(expected, km)
+			}
+		}
+		sort.Float64s(expected)
+		expected = expected[:min(3, len(expected))]
+
+		candidates, err := r.SnapCandidates(coord, 3)
+		if len(expected) == 0 {
+			if err != ErrLocationNotFound {
+				t.Errorf("%v: expected error: %s\n got: %v\n", coord, ErrLocationNotFound, err)
+			}
+			continue
+		} else if err != nil {
+			t.Errorf("%v: expected distances %v, got error %v", coord, expected, err)
+			continue
+		}
+
+		var distances []float64
+		for _, c := range candidates {
+			distances = append(distances, c.DistanceKM)
+		}
+		if len(distances) != len(expected) {
+			t.Errorf("%v: expected distances %v, got %v", coord, expected, distances)
+			continue
+		}
+		for i := range expected {
+			if math.Abs(distances[i]-expected[i]) > 1e-6 {
+				t.Errorf("%v: expected distances %v, got %v", coord, expected, distances)
+				break
+			}
+		}
+	}
+}
+
+// bruteForceDistances returns the distance from p to each shape of r, which
+// is zero for shapes containing it.
+func bruteForceDistances(r *Rgeo, p s2.Point) map[int32]s1.Angle {
+	distances := make(map[int32]s1.Angle)
+	for i, s := range r.shapes() {
+		if s.Shape.(*s2.Polygon).ContainsPoint(p) {
+			distances[int32(i)] = 0
+			continue
+		}
+
+		d := s1.InfAngle()
+		for j := 0; j < s.NumEdges(); j++ {
+			e := s.Edge(j)
+			d = min(d, s2.DistanceFromSegment(p, e.V0, e.V1))
+		}
+		distances[int32(i)] = d
+	}
+
+	return distances
+}
+
+func TestNearestFeature(t *testing.T) {
+	// A country, and a city outside of it
+	r, err := New(testDataset(t, `{"type":"FeatureCollection","features":[
+		{"type":"Feature","properties":{"ADMIN":"Alpha"},
+		 "geometry":{"type":"Polygon",
+		  "coordinates":[[[0,0],[1,0],[1,1],[0,1],[0,0]]]}},
+		{"type":"Feature","properties":{"name_conve":"Beta City"},
+		 "geometry":{"type":"Polygon",
+		  "coordinates":[[[1.5,0],[1.6,0],[1.6,0.1],[1.5,0.1],[1.5,0]]]}}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		in       geom.Coord
+		expected Location
+		level    Level
+		distance float64
+	}{
+		{
+			name:     "In country",
+			in:       geom.Coord{0.5, 0.5},
+			expected: Location{Country: "Alpha"},
+			level:    LevelCountry,
+		},
+		{
+			name:     "In city",
+			in:       geom.Coord{1.55, 0.05},
+			expected: Location{City: "Beta City"},
+			level:    LevelCity,
+		},
+		{
+			name:     "Near city",
+			in:       geom.Coord{1.45, 0.05},
+			expected: Location{City: "Beta City"},
+			level:    LevelCity,
+			distance: 5.56,
+		},
+		{
+			name:     "Far from both",
+			in:       geom.Coord{0.5, -3},
+			expected: Location{Country: "Alpha"},
+			level:    LevelCountry,
+			distance: 333.6,
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			result, level, distance, err := r.NearestFeature(test.in)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := deep.Equal(test.expected, result); diff != nil {
+				t.Error(diff)
+			}
+			if level != test.level {
+				t.Errorf("expected level %s, got %s", test.level, level)
+			}
+			if math.Abs(distance-test.distance) > 0.1 {
+				t.Errorf("expected %.2fkm, got %.2fkm", test.distance, distance)
+			}
+		})
+	}
+}
+
+func TestOnBorder(t *testing.T) {
+	// Gamma is east of Alpha with a small gap, so the order is defined
+	r, err := New(testDataset(t, lookupTestData[:len(lookupTestData)-2]+`,
+		{"type":"Feature","properties":{"ADMIN":"Gamma","ISO_A3_EH":"CCC"},
+		 "geometry":{"type":"Polygon",
+		  "coordinates":[[[2.005,0],[3,0],[3,2],[2.005,2],[2.005,0]]]}}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	alpha := Location{Country: "Alpha", CountryCode3: "AAA"}
+	gamma := Location{Country: "Gamma", CountryCode3: "CCC"}
+
+	tests := []struct {
+		name      string
+		in        geom.Coord
+		tolerance float64
+		onBorder  bool
+		expected  []Location
+	}{
+		{"Between countries", geom.Coord{2.004, 1}, 1, true, []Location{gamma, alpha}},
+		{"Coast", geom.Coord{-0.001, 1}, 1, true, []Location{alpha}},
+		{"Out of tolerance", geom.Coord{-0.1, 1}, 1, false, nil},
+		{"Inside", geom.Coord{1, 1}, 1, false, nil},
+		// The coast of Alpha is 80 degrees, about 8895km, away
+		{"Just inside large tolerance", geom.Coord{-80, 1}, 8900, true, []Location{alpha}},
+		{"Just outside large tolerance", geom.Coord{-80, 1}, 8890, false, nil},
+		{"Only city border", geom.Coord{0.5, 0.75}, 1, false, nil},
+		{"Provinces of one country", geom.Coord{11, 1}, 1, true, []Location{{
+			Country:      "Beta",
+			CountryCode3: "BBB",
+			Province:     "North",
+			ProvinceCode: "BB-N",
+		}}},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			onBorder, locs, err := r.OnBorder(test.in, test.tolerance)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if onBorder != test.onBorder {
+				t.Errorf("expected %t, got %t", test.onBorder, onBorder)
+			}
+			if diff := deep.Equal(test.expected, locs); diff != nil {
+				t.Error(diff)
+			}
+		})
+	}
+
+	if _, _, err := r.OnBorder(geom.Coord{0, 0}, -1); err == nil {
+		t.Error("expected error for negative tolerance")
+	}
+}
+
+func TestOnBorder_BruteForce(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test (on border) in short mode")
+	}
+
+	r, err := New(Countries110)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rnd := rand.New(rand.NewSource(1))
+	for i := 0; i < 500; i++ {
+		coord := geom.Coord{rnd.Float64()*360 - 180, rnd.Float64()*180 - 90}
+		p := pointFromCoord(coord)
+
+		// The countries with an edge in range, ignoring containment
+		expected := make(map[string]bool)
+		for _, s := range r.shapes() {
+			for j := 0; j < s.NumEdges(); j++ {
+				e := s.Edge(j)
+				if d := s2.DistanceFromSegment(p, e.V0, e.V1); d.Radians()*r.Radius() <= 200 {
+					expected[s.loc.Country] = true
+				}
+			}
+		}
+
+		onBorder, locs, err := r.OnBorder(coord, 200)
+		if err != nil {
+			t.Fatal(err)
+		}
+		countries := make(map[string]bool)
+		for _, l := range locs {
+			countries[l.Country] = true
+		}
+		if onBorder != (len(expected) > 0) {
+			t.Errorf("%v: expected %t, got %t", coord, len(expected) > 0, onBorder)
+		}
+		if diff := deep.Equal(expected, countries); diff != nil {
+			t.Errorf("%v: %v", coord, diff)
+		}
+	}
+}
+
+func TestReverseGeocodeWithUncertainty(t *testing.T) {
+	// Gamma is east of Alpha with a small gap, as in TestOnBorder
+	r, err := New(testDataset(t, lookupTestData[:len(lookupTestData)-2]+`,
+		{"type":"Feature","properties":{"ADMIN":"Gamma","ISO_A3_EH":"CCC"},
+		 "geometry":{"type":"Polygon",
+		  "coordinates":[[[2.005,0],[3,0],[3,2],[2.005,2],[2.005,0]]]}}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	alpha := Location{Country: "Alpha", CountryCode3: "AAA"}
+	beta := Location{Country: "Beta", CountryCode3: "BBB"}
+	gamma := Location{Country: "Gamma", CountryCode3: "CCC"}
+
+	tests := []struct {
+		name     string
+		in       geom.Coord
+		radius   float64
+		err      error
+		expected []Location
+	}{
+		{"Inside", geom.Coord{1, 1}, 1, nil, []Location{alpha}},
+		{"Exact", geom.Coord{1, 1}, 0, nil, []Location{alpha}},
+		{"Near border", geom.Coord{1.99, 1}, 5, nil, []Location{alpha, gamma}},
+		{"In gap", geom.Coord{2.004, 1}, 1, nil, []Location{gamma, alpha}},
+		{"Country in two parts", geom.Coord{2.5, 0.5}, 100, nil, []Location{gamma, alpha}},
+		{"Provinces", geom.Coord{11, 1}, 1, nil, []Location{beta}},
+		{"Only city", geom.Coord{0.75, 0.75}, 1, nil, []Location{alpha}},
+		{"Out of range", geom.Coord{5, 1}, 10, ErrLocationNotFound, nil},
+		// Alpha is 80 degrees, about 8895km, away
+		{"Just inside large radius", geom.Coord{-80, 1}, 8900, nil, []Location{alpha}},
+		{"Just outside large radius", geom.Coord{-80, 1}, 8890, ErrLocationNotFound, nil},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			locs, err := r.ReverseGeocodeWithUncertainty(test.in, test.radius)
+			if err != test.err {
+				t.Errorf("expected error: %s\n got: %v\n", test.err, err)
+			}
+			if diff := deep.Equal(test.expected, locs); diff != nil {
+				t.Error(diff)
+			}
+		})
+	}
+
+	if _, err := r.ReverseGeocodeWithUncertainty(geom.Coord{0, 0}, -1); err == nil {
+		t.Error("expected error for negative radius")
+	}
+}
+
+func TestNearestCities(t *testing.T) {
+	// Near has a second polygon
+	r, err := New(testDataset(t, distanceTestData[:len(distanceTestData)-2]+`,
+		{"type":"Feature","properties":{"name_conve":"Near"},
+		 "geometry":{"type":"Polygon",
+		  "coordinates":[[[0.7,-0.1],[0.8,-0.1],[0.8,0.1],[0.7,0.1],[0.7,-0.1]]]}}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		in        geom.Coord
+		k         int
+		expected  []Location
+		distances []float64
+	}{
+		{
+			name:      "Containing",
+			in:        geom.Coord{0, 0},
+			k:         1,
+			expected:  []Location{{CountryCode3: "TST", City: "In"}},
+			distances: []float64{0},
+		},
+		{
+			name: "All",
+			in:   geom.Coord{0, 0},
+			k:    10,
+			expected: []Location{
+				{CountryCode3: "TST", City: "In"},
+				{CountryCode3: "TST", City: "Near"},
+				{CountryCode3: "TST", City: "Far"},
+			},
+			distances: []float64{0, 55.6, 333.6},
+		},
+		{
+			name:      "Outside",
+			in:        geom.Coord{10, 0},
+			k:         1,
+			expected:  []Location{{CountryCode3: "TST", City: "Far"}},
+			distances: []float64{767.3},
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			locs, distances, err := r.NearestCities(test.in, test.k)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := deep.Equal(test.expected, locs); diff != nil {
+				t.Error(diff)
+			}
+			if len(distances) != len(test.distances) {
+				t.Fatalf("expected %d distances, got %v", len(test.distances), distances)
+			}
+			for i, d := range distances {
+				if math.Abs(d-test.distances[i]) > 0.1 {
+					t.Errorf("expected distances %v, got %v", test.distances, distances)
+					break
+				}
+			}
+		})
+	}
+
+	if _, _, err := r.NearestCities(geom.Coord{0, 0}, 0); err == nil {
+		t.Error("expected error for k of zero")
+	}
+}
+
+func BenchmarkOnBorder(b *testing.B) {
+	r, err := New(Countries10, Provinces10, Cities10)
+	if err != nil {
+		b.Error(err)
+	}
+	r.Build()
+
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		_, _, _ = r.OnBorder([]float64{
+			(rand.Float64() * 360) - 180,
+			(rand.Float64() * 180) - 90,
+		}, 10)
+	}
+}
+
+func BenchmarkNearestCities(b *testing.B) {
+	r, err := New(Countries10, Cities10)
+	if err != nil {
+		b.Error(err)
+	}
+	r.Build()
+	_, _, _ = r.NearestCities(geom.Coord{0, 0}, 1)
+
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		_, _, _ = r.NearestCities([]float64{
+			(rand.Float64() * 360) - 180,
+			(rand.Float64() * 180) - 90,
+		}, 3)
+	}
+}
@@ -5,42 +5,88 @@ package rgeo
 // data is sourced from: https://github.com/nvkelso/natural-earth-vector/tree/master/geojson
 // and bundled via regen.sh in the repository's root
 
-// embedding files individually here to allow the linker to strip out unused ones
+// embedding files individually here to allow the linker to strip out unused ones,
+// which it does as long as nothing references the dataset function, e.g. a
+// binary with only Countries110 is about 36MB smaller than one with Cities10 too
 import (
 	"bytes"
 	_ "embed"
 	"errors"
 	"fmt"
-
-	"github.com/klauspost/compress/zstd"
 )
 
 //go:embed data/Cities10.zst
 var cities10 []byte
 
 func Cities10() []Feature {
-	return must(embeddedFeatureCollection(cities10))
+	features := must(embeddedFeatureCollection(cities10))
+	return withDatasetName(features, "Cities10")
 }
 
 //go:embed data/Countries10.zst
 var countries10 []byte
 
 func Countries10() []Feature {
-	return must(embeddedFeatureCollection(countries10))
+	features := must(embeddedFeatureCollection(countries10))
+	return withDatasetName(features, "Countries10")
 }
 
 //go:embed data/Countries110.zst
 var countries110 []byte
 
 func Countries110() []Feature {
-	return must(embeddedFeatureCollection(countries110))
+	features := must(embeddedFeatureCollection(countries110))
+	return withDatasetName(features, "Countries110")
 }
 
 //go:embed data/Provinces10.zst
 var provinces10 []byte
 
 func Provinces10() []Feature {
-	return must(embeddedFeatureCollection(provinces10))
+	features := must(embeddedFeatureCollection(provinces10))
+	return withDatasetName(features, "Provinces10")
+}
+
+// NewDefault returns a Rgeo with only Countries110, the smallest of the
+// included datasets. It is the quickest way to get started, but it only
+// resolves countries, and its borders are coarse enough that points within a
+// few kilometers of a border or coast can be attributed to the wrong country
+// or none at all. Use New with Countries10 for accurate borders, or with
+// Provinces10 and Cities10 for more detail, at the cost of more memory and a
+// slower start.
+func NewDefault() (*Rgeo, error) {
+	return New(Countries110)
+}
+
+// VerifyEmbedded decodes all included datasets and checks that the polygon of
+// every feature is valid according to s2.Polygon.Validate, and that the
+// Location fields each dataset is expected to have are set: Country and
+// CountryCode3 for the countries, Country and Province for the provinces and
+// City for the cities. It is meant to be run in a test after regenerating the
+// data files, and returns all problems found joined into one error.
+func VerifyEmbedded() error {
+	datasets := []struct {
+		name     string
+		data     []byte
+		required []requiredField
+	}{
+		{"Cities10", cities10, []requiredField{requireCity}},
+		{"Countries10", countries10, []requiredField{requireCountry, requireCountryCode3}},
+		{"Countries110", countries110, []requiredField{requireCountry, requireCountryCode3}},
+		{"Provinces10", provinces10, []requiredField{requireCountry, requireProvince}},
+	}
+
+	var errs []error
+	for _, d := range datasets {
+		features, err := embeddedFeatureCollection(d.data)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", d.name, err))
+			continue
+		}
+		errs = append(errs, verifyFeatures(d.name, features, d.required...))
+	}
+
+	return errors.Join(errs...)
 }
 
 func must(features []Feature, err error) []Feature {
@@ -56,13 +102,7 @@ func embeddedFeatureCollection(data []byte) ([]Feature, error) {
 		return nil, errors.New("empty dataset")
 	}
 
-	zr, err := zstd.NewReader(br)
-	if err != nil {
-		return nil, fmt.Errorf("zstd reader setup: %w", err)
-	}
-	defer zr.Close()
-
-	result, err := LoadEncoded(zr)
+	result, err := LoadAuto(br)
 	if err != nil {
 		return nil, fmt.Errorf("decode: %w", err)
 	}
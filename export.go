@@ -0,0 +1,488 @@
+package rgeo
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/golang/geo/s1"
+	"github.com/golang/geo/s2"
+	"github.com/twpayne/go-geom"
+	"github.com/twpayne/go-geom/encoding/geojson"
+)
+
+// ToGeoJSONSimplified converts the features to a GeoJSON FeatureCollection of
+// MultiPolygons, e.g. for web maps that can't load the full resolution
+// boundaries. A tolerance of zero keeps all vertices.
+//
+// The boundaries are split into arcs at the vertices where the loops of the
+// features meet or part, and each arc is simplified once with the
+// Douglas-Peucker algorithm, so no vertex is dropped that is further than
+// toleranceMeters from the simplified arc, on a sphere with the radius of the
+// Earth. Borders shared by several loops, e.g. of neighbouring features or of
+// an enclave and the hole for it, thus stay shared without gaps or overlaps.
+// Where a simplified arc crosses another arc or itself, or a hole ends up
+// outside of its shell, dropped vertices are put back until it doesn't. Loops
+// that are left with fewer than three vertices are dropped, e.g. small
+// islands, along with their holes.
+//
+// The properties are the Location fields set, keyed by their JSON names,
+// which LoadGeoJSON doesn't read back.
+func (fc FeatureCollection) ToGeoJSONSimplified(toleranceMeters float64) (*geojson.FeatureCollection, error) {
+	if toleranceMeters < 0 {
+		return nil, errors.New("tolerance must not be negative")
+	}
+	tolerance := s1.Angle(toleranceMeters / 1000 / earthRadiusKM)
+	loops := simplifiedLoops(fc, tolerance)
+
+	out := &geojson.FeatureCollection{Features: make([]*geojson.Feature, 0, len(fc))}
+	for i, f := range fc {
+		properties, err := locationProperties(f.Location)
+		if err != nil {
+			return nil, fmt.Errorf("feature %d: %w", i, err)
+		}
+
+		mp, err := multiPolygonFromLoops(f.Polygon, loops[i])
+		if err != nil {
+			return nil, fmt.Errorf("feature %d: %w", i, err)
+		}
+
+		out.Features = append(out.Features, &geojson.Feature{
+			Geometry:   mp,
+			Properties: properties,
+		})
+	}
+
+	return out, nil
+}
+
+// locationProperties returns the fields of l that are set, keyed by their
+// JSON names.
+func locationProperties(l Location) (map[string]interface{}, error) {
+	buf, err := json.Marshal(l)
+	if err != nil {
+		return nil, fmt.Errorf("encode location: %w", err)
+	}
+
+	var properties map[string]interface{}
+	if err := json.Unmarshal(buf, &properties); err != nil {
+		return nil, fmt.Errorf("decode location: %w", err)
+	}
+
+	return properties, nil
+}
+
+// multiPolygonFromLoops converts the simplified loops of p to a MultiPolygon
+// with one Polygon for each shell and its holes, leaving out the dropped
+// loops, which are nil. The rings are oriented as GeoJSON recommends, shells
+// counter-clockwise and holes clockwise.
+func multiPolygonFromLoops(p *s2.Polygon, loops [][]s2.Point) (*geom.MultiPolygon, error) {
+	mp := geom.NewMultiPolygon(geom.XY)
+	if p == nil {
+		return mp, nil
+	}
+
+	for k, l := range p.Loops() {
+		if l.IsHole() || loops[k] == nil {
+			continue
+		}
+
+		// The direct children of a shell are its holes, the loops are in
+		// pre-order so the descendants of each hole are skipped
+		rings := [][]geom.Coord{closedRing(loops[k])}
+		for j := k + 1; j <= p.LastDescendant(k); j = p.LastDescendant(j) + 1 {
+			if loops[j] != nil {
+				rings = append(rings, closedRing(loops[j]))
+			}
+		}
+
+		polygon, err := geom.NewPolygon(geom.XY).SetCoords(rings)
+		if err != nil {
+			return nil, err
+		} else if err := mp.Push(polygon); err != nil {
+			return nil, err
+		}
+	}
+
+	return mp, nil
+}
+
+// closedRing returns the coordinates of the points, repeating the first one at
+// the end.
+func closedRing(pts []s2.Point) []geom.Coord {
+	ring := make([]geom.Coord, 0, len(pts)+1)
+	for _, p := range pts {
+		ring = append(ring, coordFromPoint(p))
+	}
+	return append(ring, ring[0])
+}
+
+// arc is a part of the boundaries of one or more loops between two vertices
+// where they meet or part, see loopArcs. The points are in a canonical
+// direction, so that a border of two features is the same arc for both.
+type arc struct {
+	points []s2.Point
+	// keep marks the points kept by simplify and refine.
+	keep []bool
+	// simplified are the kept points, and kept their indices in points.
+	simplified []s2.Point
+	kept       []int
+}
+
+// loopArc is an arc of a loop, reversed if the loop runs the other way.
+type loopArc struct {
+	*arc
+	reversed bool
+}
+
+// simplify marks the points of the arc that are kept, see
+// ToGeoJSONSimplified.
+func (a *arc) simplify(tolerance s1.Angle) {
+	n := len(a.points) - 1
+	a.keep = make([]bool, n+1)
+	a.keep[0], a.keep[n] = true, true
+
+	switch {
+	case tolerance == 0:
+		for i := range a.keep {
+			a.keep[i] = true
+		}
+	case a.points[0] == a.points[n]:
+		// A loop without other loops on it starts and ends at the same
+		// vertex, so it is split at the vertex furthest from it first
+		far := 0
+		for i := 1; i < n; i++ {
+			if a.points[0].Distance(a.points[i]) > a.points[0].Distance(a.points[far]) {
+				far = i
+			}
+		}
+		a.keep[far] = true
+		douglasPeucker(a.points, a.keep, 0, far, tolerance)
+		douglasPeucker(a.points, a.keep, far, n, tolerance)
+	default:
+		douglasPeucker(a.points, a.keep, 0, n, tolerance)
+	}
+
+	a.update()
+}
+
+// refine marks the point furthest from the simplified edge ending at the i-th
+// kept point as kept, see update. It reports whether the edge had any points
+// left to keep.
+func (a *arc) refine(i int) bool {
+	lo, hi := a.kept[i-1], a.kept[i]
+	if hi-lo < 2 {
+		return false
+	}
+
+	far, farDistance := lo+1, s1.Angle(-1)
+	for j := lo + 1; j < hi; j++ {
+		if d := s2.DistanceFromSegment(a.points[j], a.points[lo], a.points[hi]); d > farDistance {
+			far, farDistance = j, d
+		}
+	}
+	a.keep[far] = true
+	return true
+}
+
+// update sets the simplified points from the ones that are kept.
+func (a *arc) update() {
+	a.simplified, a.kept = a.simplified[:0], a.kept[:0]
+	for i, p := range a.points {
+		if a.keep[i] {
+			a.simplified = append(a.simplified, p)
+			a.kept = append(a.kept, i)
+		}
+	}
+}
+
+// changed reports whether any points of the arc are dropped.
+func (a *arc) changed() bool {
+	return len(a.simplified) < len(a.points)
+}
+
+// arcEdge is the edge of a simplified arc ending at its i-th kept point.
+type arcEdge struct {
+	arc *arc
+	i   int
+}
+
+// neighbours are the distinct vertices next to a vertex in all loops, up to
+// three, as any more don't make a difference, see loopArcs.
+type neighbours struct {
+	n      int
+	points [3]s2.Point
+}
+
+func (nb *neighbours) add(p s2.Point) {
+	if nb.n < len(nb.points) && !slices.Contains(nb.points[:nb.n], p) {
+		nb.points[nb.n] = p
+		nb.n++
+	}
+}
+
+// loopArcs splits the loops of all features into arcs at their nodes, the
+// vertices with other than two neighbours, where the loops meet or part.
+// Between the nodes, the loops sharing a vertex share its neighbours too, so
+// an arc is identified by its first two points in the canonical direction.
+// Loops without nodes are a single arc from their smallest vertex, so that
+// e.g. an enclave and the hole for it have the same arc.
+//
+// The arcs of each loop of each feature are returned in order, nil for empty
+// and full loops, along with all distinct arcs.
+func loopArcs(fc FeatureCollection) ([][][]loopArc, []*arc) {
+	vertices := make([][][]s2.Point, len(fc))
+	nodes := make(map[s2.Point]neighbours)
+	for i, f := range fc {
+		if f.Polygon == nil {
+			continue
+		}
+		vertices[i] = make([][]s2.Point, f.Polygon.NumLoops())
+		for k, l := range f.Polygon.Loops() {
+			if l.IsEmpty() || l.IsFull() {
+				continue
+			}
+
+			// The included datasets have some repeated vertices
+			pts := make([]s2.Point, l.NumVertices())
+			for j := range pts {
+				pts[j] = l.OrientedVertex(j)
+			}
+			pts = dropRepeatedPoints(pts)
+			if len(pts) < 3 {
+				continue
+			}
+
+			for j, p := range pts {
+				nb := nodes[p]
+				nb.add(pts[(j+len(pts)-1)%len(pts)])
+				nb.add(pts[(j+1)%len(pts)])
+				nodes[p] = nb
+			}
+			vertices[i][k] = pts
+		}
+	}
+	isNode := func(p s2.Point) bool { return nodes[p].n != 2 }
+
+	var (
+		loops = make([][][]loopArc, len(fc))
+		arcs  []*arc
+		byKey = make(map[[2]s2.Point]*arc)
+	)
+	for i := range vertices {
+		loops[i] = make([][]loopArc, len(vertices[i]))
+		for k, pts := range vertices[i] {
+			if pts == nil {
+				continue
+			}
+
+			start := slices.IndexFunc(pts, isNode)
+			if start < 0 {
+				start = 0
+				for j, p := range pts {
+					if p.Cmp(pts[start].Vector) < 0 {
+						start = j
+					}
+				}
+			}
+			ring := append(slices.Clone(pts[start:]), pts[:start]...)
+			ring = append(ring, ring[0])
+
+			for a := 0; a < len(ring)-1; {
+				b := a + 1
+				for b < len(ring)-1 && !isNode(ring[b]) {
+					b++
+				}
+
+				points := slices.Clone(ring[a : b+1])
+				reversed := !canonical(points)
+				if reversed {
+					slices.Reverse(points)
+				}
+				key := [2]s2.Point{points[0], points[1]}
+				if byKey[key] == nil {
+					byKey[key] = &arc{points: points}
+					arcs = append(arcs, byKey[key])
+				}
+				loops[i][k] = append(loops[i][k], loopArc{byKey[key], reversed})
+				a = b
+			}
+		}
+	}
+
+	return loops, arcs
+}
+
+// canonical reports whether the points of an arc are in the canonical
+// direction, the one in which they are smaller than reversed, compared point
+// by point.
+func canonical(pts []s2.Point) bool {
+	for i, j := 0, len(pts)-1; i < j; i, j = i+1, j-1 {
+		if c := pts[i].Cmp(pts[j].Vector); c != 0 {
+			return c < 0
+		}
+	}
+	return true
+}
+
+// loopPoints returns the points of the simplified loop made up of the arcs.
+func loopPoints(arcs []loopArc) []s2.Point {
+	var pts []s2.Point
+	for _, a := range arcs {
+		// The last point is the first of the next arc
+		n := len(a.simplified) - 1
+		for i := 0; i < n; i++ {
+			if a.reversed {
+				pts = append(pts, a.simplified[n-i])
+			} else {
+				pts = append(pts, a.simplified[i])
+			}
+		}
+	}
+	return pts
+}
+
+// simplifiedLoops returns the points of the simplified loops of each feature,
+// oriented with the interior on the left, or nil for the loops that are
+// dropped, see ToGeoJSONSimplified. The edges that make the result invalid are
+// refined, until none do.
+func simplifiedLoops(fc FeatureCollection, tolerance s1.Angle) [][][]s2.Point {
+	loopArcs, arcs := loopArcs(fc)
+	for _, a := range arcs {
+		a.simplify(tolerance)
+	}
+
+	for {
+		loops := make([][][]s2.Point, len(loopArcs))
+		for i := range loopArcs {
+			loops[i] = make([][]s2.Point, len(loopArcs[i]))
+			for k, l := range loopArcs[i] {
+				if pts := loopPoints(l); len(pts) >= 3 {
+					loops[i][k] = pts
+				}
+			}
+		}
+
+		// A crossing can misplace a hole too, so they are fixed first
+		invalid := crossingEdges(arcs)
+		if len(invalid) == 0 {
+			invalid = misplacedHoles(fc, loopArcs, loops)
+		}
+
+		refined := make(map[*arc]bool)
+		for _, e := range invalid {
+			if e.arc.refine(e.i) {
+				refined[e.arc] = true
+			}
+		}
+		if len(refined) == 0 {
+			return loops
+		}
+		for a := range refined {
+			a.update()
+		}
+	}
+}
+
+// crossingEdges returns the edges of the simplified arcs that cross another
+// edge other than at a vertex, both edges of each crossing. Only the edges of
+// the changed arcs are checked, the boundaries are expected to be valid at
+// full resolution.
+func crossingEdges(arcs []*arc) []arcEdge {
+	if !slices.ContainsFunc(arcs, (*arc).changed) {
+		return nil
+	}
+
+	index := s2.NewShapeIndex()
+	shapes := make(map[s2.Shape]*arc, len(arcs))
+	for _, a := range arcs {
+		line := s2.Polyline(a.simplified)
+		shapes[&line] = a
+		index.Add(&line)
+	}
+
+	var crossing []arcEdge
+	query := s2.NewCrossingEdgeQuery(index)
+	for _, a := range arcs {
+		if !a.changed() {
+			continue
+		}
+		for i := 1; i < len(a.simplified); i++ {
+			edges := query.CrossingsEdgeMap(a.simplified[i-1], a.simplified[i], s2.CrossingTypeInterior)
+			for s, ids := range edges {
+				crossing = append(crossing, arcEdge{a, i})
+				for _, id := range ids {
+					crossing = append(crossing, arcEdge{shapes[s], id + 1})
+				}
+			}
+		}
+	}
+
+	return crossing
+}
+
+// misplacedHoles returns the edges of the simplified holes that are no longer
+// contained by their shells, and those of the shells.
+func misplacedHoles(fc FeatureCollection, loopArcs [][][]loopArc, loops [][][]s2.Point) []arcEdge {
+	var misplaced []arcEdge
+	for i, f := range fc {
+		p := f.Polygon
+		if p == nil {
+			continue
+		}
+
+		for k, l := range p.Loops() {
+			if l.IsHole() || loops[i][k] == nil {
+				continue
+			}
+			var shell *s2.Loop
+
+			for j := k + 1; j <= p.LastDescendant(k); j = p.LastDescendant(j) + 1 {
+				arcs := slices.Concat(loopArcs[i][k], loopArcs[i][j])
+				if loops[i][j] == nil || !slices.ContainsFunc(arcs, func(a loopArc) bool { return a.changed() }) {
+					continue
+				}
+
+				if shell == nil {
+					shell = s2.LoopFromPoints(loops[i][k])
+				}
+				// The points of holes run clockwise
+				hole := s2.LoopFromPoints(slices.Clone(loops[i][j]))
+				hole.Invert()
+				if shell.Contains(hole) {
+					continue
+				}
+				for _, a := range arcs {
+					for e := 1; e < len(a.simplified); e++ {
+						misplaced = append(misplaced, arcEdge{a.arc, e})
+					}
+				}
+			}
+		}
+	}
+
+	return misplaced
+}
+
+// douglasPeucker marks the vertices between a and b that are kept, see
+// ToGeoJSONSimplified.
+func douglasPeucker(points []s2.Point, keep []bool, a, b int, tolerance s1.Angle) {
+	if b-a < 2 {
+		return
+	}
+
+	far, farDistance := -1, tolerance
+	for i := a + 1; i < b; i++ {
+		if d := s2.DistanceFromSegment(points[i], points[a], points[b]); d > farDistance {
+			far, farDistance = i, d
+		}
+	}
+	if far < 0 {
+		return
+	}
+
+	keep[far] = true
+	douglasPeucker(points, keep, a, far, tolerance)
+	douglasPeucker(points, keep, far, b, tolerance)
+}
@@ -0,0 +1,179 @@
+package rgeo
+
+import (
+	"encoding/json"
+	"math"
+	"testing"
+
+	"github.com/go-test/deep"
+	"github.com/golang/geo/s2"
+	"github.com/twpayne/go-geom"
+	"github.com/twpayne/go-geom/encoding/geojson"
+)
+
+func TestToGeoJSONSimplified(t *testing.T) {
+	// Alpha has a vertex just off its southern edge, a hole and an island of
+	// about 10m
+	var in geojson.FeatureCollection
+	if err := json.Unmarshal([]byte(`{"type":"FeatureCollection","features":[
+		{"type":"Feature","properties":{"ADMIN":"Alpha","ISO_A3_EH":"AAA"},
+		 "geometry":{"type":"MultiPolygon","coordinates":[
+		  [[[0,0],[1,0.00001],[2,0],[2,2],[0,2],[0,0]],
+		   [[0.5,0.5],[0.5,1.5],[1.5,1.5],[1.5,0.5],[0.5,0.5]]],
+		  [[[5,5],[5.0001,5],[5.0001,5.0001],[5,5.0001],[5,5]]]]}}]}`), &in); err != nil {
+		t.Fatalf("decode GeoJSON: %s", err)
+	}
+	fc, err := LoadGeoJSON(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		tolerance float64
+		rings     [][]int // number of coordinates of each ring of each polygon
+	}{
+		{"Full resolution", 0, [][]int{{6, 5}, {5}}},
+		{"Simplified", 100, [][]int{{5, 5}}},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			out, err := fc.ToGeoJSONSimplified(test.tolerance)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(out.Features) != 1 {
+				t.Fatalf("expected 1 feature, got %d", len(out.Features))
+			}
+
+			f := out.Features[0]
+			expected := map[string]interface{}{"country": "Alpha", "country_code_3": "AAA"}
+			if diff := deep.Equal(expected, f.Properties); diff != nil {
+				t.Error(diff)
+			}
+
+			mp := f.Geometry.(*geom.MultiPolygon)
+			var rings [][]int
+			for i := 0; i < mp.NumPolygons(); i++ {
+				var counts []int
+				for j, r := range mp.Polygon(i).Coords() {
+					counts = append(counts, len(r))
+
+					// Shells are counter-clockwise, holes clockwise
+					if ccw := signedArea(r) > 0; ccw != (j == 0) {
+						t.Errorf("polygon %d ring %d has the wrong orientation", i, j)
+					}
+				}
+				rings = append(rings, counts)
+			}
+			if diff := deep.Equal(test.rings, rings); diff != nil {
+				t.Error(diff)
+			}
+
+			// The result can be loaded again
+			back, err := LoadGeoJSON(*out)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if math.Abs(back[0].Polygon.Area()-fc[0].Polygon.Area()) > 1e-8 {
+				t.Errorf("expected area %g, got %g", fc[0].Polygon.Area(), back[0].Polygon.Area())
+			}
+		})
+	}
+
+	if _, err := fc.ToGeoJSONSimplified(-1); err == nil {
+		t.Error("expected error for negative tolerance")
+	}
+}
+
+func TestToGeoJSONSimplified_SharedBorders(t *testing.T) {
+	// Alpha and Beta share a border with bends of about 20m and 33km. Alpha has
+	// a peninsula of about 17km in the north, with an enclave made of Gamma
+	// and Delta at its base.
+	var in geojson.FeatureCollection
+	if err := json.Unmarshal([]byte(`{"type":"FeatureCollection","features":[
+		{"type":"Feature","properties":{"ADMIN":"Alpha"},
+		 "geometry":{"type":"Polygon","coordinates":[
+		  [[0,0],[1,0],[1.0002,1],[1.3,2],[1,4],[0.9,4],[0.9,4.15],[0.1,4.15],[0.1,4],[0,4],[0,0]],
+		  [[0.2,3.8],[0.2,4.1],[0.5,4.1],[0.8,4.1],[0.8,3.8],[0.5,3.8],[0.2,3.8]]]}},
+		{"type":"Feature","properties":{"ADMIN":"Beta"},
+		 "geometry":{"type":"Polygon","coordinates":[
+		  [[1,0],[2,0],[2,4],[1,4],[1.3,2],[1.0002,1],[1,0]]]}},
+		{"type":"Feature","properties":{"ADMIN":"Gamma"},
+		 "geometry":{"type":"Polygon","coordinates":[
+		  [[0.2,3.8],[0.5,3.8],[0.5,4.1],[0.2,4.1],[0.2,3.8]]]}},
+		{"type":"Feature","properties":{"ADMIN":"Delta"},
+		 "geometry":{"type":"Polygon","coordinates":[
+		  [[0.5,3.8],[0.8,3.8],[0.8,4.1],[0.5,4.1],[0.5,3.8]]]}}]}`), &in); err != nil {
+		t.Fatalf("decode GeoJSON: %s", err)
+	}
+	fc, err := LoadGeoJSON(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := fc.ToGeoJSONSimplified(20000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rings := func(i int) [][]geom.Coord {
+		return out.Features[i].Geometry.(*geom.MultiPolygon).Polygon(0).Coords()
+	}
+	points := func(match func(geom.Coord) bool, rings ...[]geom.Coord) map[[2]float64]bool {
+		points := make(map[[2]float64]bool)
+		for _, r := range rings {
+			for _, c := range r {
+				if match(c) {
+					points[[2]float64{math.Round(c.X()*1e6) / 1e6, math.Round(c.Y()*1e6) / 1e6}] = true
+				}
+			}
+		}
+		return points
+	}
+
+	// Only the small bend is dropped, from both sides of the border
+	onBorder := func(c geom.Coord) bool { return c.X() > 0.95 && c.X() < 1.4 && c.Y() > 0.01 && c.Y() < 3.99 }
+	expected := map[[2]float64]bool{{1.3, 2}: true}
+	if diff := deep.Equal(expected, points(onBorder, rings(0)[0])); diff != nil {
+		t.Error(diff)
+	}
+	if diff := deep.Equal(expected, points(onBorder, rings(1)[0])); diff != nil {
+		t.Error(diff)
+	}
+
+	// Dropping the peninsula would cut through the enclave, so enough of it is
+	// kept, and the hole matches the enclave
+	all := func(geom.Coord) bool { return true }
+	if diff := deep.Equal(points(all, rings(2)[0], rings(3)[0]), points(all, rings(0)[1])); diff != nil {
+		t.Error(diff)
+	}
+	back, err := LoadGeoJSON(*out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, f := range back {
+		if err := f.Polygon.Validate(); err != nil {
+			t.Errorf("feature %d: %s", i, err)
+		}
+	}
+	for _, c := range []struct {
+		lat, lng float64
+		inside   bool
+	}{{4.12, 0.5, true}, {3.9, 0.3, false}, {3.9, 0.7, false}, {3.7, 0.5, true}} {
+		if back[0].Polygon.ContainsPoint(s2.PointFromLatLng(s2.LatLngFromDegrees(c.lat, c.lng))) != c.inside {
+			t.Errorf("expected Alpha to contain (%g, %g): %t", c.lat, c.lng, c.inside)
+		}
+	}
+}
+
+// signedArea returns the planar area of a closed ring, which is positive if it
+// is counter-clockwise.
+func signedArea(ring []geom.Coord) float64 {
+	var a float64
+	for i := 1; i < len(ring); i++ {
+		a += ring[i-1].X()*ring[i].Y() - ring[i].X()*ring[i-1].Y()
+	}
+	return a / 2
+}
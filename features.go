@@ -1,14 +1,23 @@
 package rgeo
 
 import (
+	"bufio"
 	"bytes"
+	"compress/gzip"
 	"encoding/binary"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/golang/geo/s2"
+	"github.com/klauspost/compress/zstd"
+	"github.com/twpayne/go-geom"
 	"github.com/twpayne/go-geom/encoding/geojson"
 )
 
@@ -23,9 +32,82 @@ func (fc *FeatureCollection) Encode(w io.Writer) error {
 	return nil
 }
 
+// framedMagic starts the framed format written by EncodeV2.
+var framedMagic = []byte("rgeo")
+
+// framedVersion is the version of the framed format written by EncodeV2.
+const framedVersion = 2
+
+// EncodeV2 is like Encode, but writes a header with a magic number, format
+// version and the number of features before the features. This allows
+// DecodeV2 to detect truncated data, rather than silently returning fewer
+// features.
+func (fc *FeatureCollection) EncodeV2(w io.Writer) error {
+	if _, err := w.Write(framedMagic); err != nil {
+		return fmt.Errorf("write magic: %w", err)
+	} else if _, err := w.Write([]byte{framedVersion}); err != nil {
+		return fmt.Errorf("write version: %w", err)
+	} else if err := binary.Write(w, binary.LittleEndian, uint32(len(*fc))); err != nil {
+		return fmt.Errorf("write count: %w", err)
+	}
+
+	return fc.Encode(w)
+}
+
+// DecodeV2 decodes features written by EncodeV2 into fc. It returns an error
+// if there are fewer or more features than declared in the header.
+func (fc *FeatureCollection) DecodeV2(r io.Reader) error {
+	header := make([]byte, len(framedMagic)+1)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return fmt.Errorf("read header: %w", unexpectedEOF(err))
+	}
+	if !bytes.Equal(header[:len(framedMagic)], framedMagic) {
+		return errors.New("bad magic number")
+	}
+	if v := header[len(framedMagic)]; v != framedVersion {
+		return fmt.Errorf("unsupported version %d", v)
+	}
+
+	var n uint32
+	if err := binary.Read(r, binary.LittleEndian, &n); err != nil {
+		return fmt.Errorf("read count: %w", unexpectedEOF(err))
+	}
+
+	// The count isn't trusted for preallocating, as it may be corrupt
+	features := make(FeatureCollection, 0, min(n, 1024))
+	cr := &countingReader{r: r, n: int64(len(header)) + 4}
+	for i := uint32(0); i < n; i++ {
+		offset := cr.n
+		var f Feature
+		if err := f.Decode(cr); err != nil {
+			if errors.Is(err, io.EOF) {
+				err = io.ErrUnexpectedEOF
+			}
+			return fmt.Errorf("decode feature %d of %d at byte %d: %w", i, n, offset, err)
+		}
+		features = append(features, f)
+	}
+
+	if _, err := io.ReadFull(r, make([]byte, 1)); err != io.EOF {
+		return fmt.Errorf("trailing data after %d features", n)
+	}
+
+	*fc = features
+	return nil
+}
+
 type Feature struct {
 	Location Location
 	Polygon  *s2.Polygon
+
+	// ValidFrom and ValidTo limit when the feature is used by ReverseGeocodeAt,
+	// e.g. for historical borders. It is valid from ValidFrom until just before
+	// ValidTo, and a zero time means no limit. They are not encoded.
+	ValidFrom, ValidTo time.Time
+
+	// dataset is the name of the dataset the feature belongs to, see
+	// DatasetNamed. It is not encoded.
+	dataset string
 }
 
 func (f *Feature) Encode(w io.Writer) error {
@@ -52,14 +134,24 @@ func (f *Feature) Encode(w io.Writer) error {
 	return nil
 }
 
+// MaxEncodedLength is the largest encoded Location or Polygon in bytes that
+// Feature.Decode accepts. Longer length prefixes are reported as errors before
+// allocating anything, so that corrupt data can't exhaust memory. The default
+// is far above the largest feature of the included datasets, and can be raised
+// for custom datasets with larger polygons.
+var MaxEncodedLength uint32 = 64 << 20
+
 func (f *Feature) Decode(r io.Reader) error {
 	var l uint32
 
 	if err := binary.Read(r, binary.LittleEndian, &l); err != nil {
 		return fmt.Errorf("read location length: %w", err)
 	}
-	locBuf := make([]byte, l)
-	if _, err := io.ReadFull(r, locBuf); err != nil {
+	if l > MaxEncodedLength {
+		return fmt.Errorf("location length %d exceeds maximum of %d", l, MaxEncodedLength)
+	}
+	locBuf, err := readLength(r, l)
+	if err != nil {
 		return fmt.Errorf("read location: %w", unexpectedEOF(err))
 	}
 	if err := json.Unmarshal(locBuf, &f.Location); err != nil {
@@ -69,46 +161,484 @@ func (f *Feature) Decode(r io.Reader) error {
 	if err := binary.Read(r, binary.LittleEndian, &l); err != nil {
 		return fmt.Errorf("read polygon length: %w", unexpectedEOF(err))
 	}
-	polyBuf := make([]byte, l)
-	if _, err := io.ReadFull(r, polyBuf); err != nil {
+	if l > MaxEncodedLength {
+		return fmt.Errorf("polygon length %d exceeds maximum of %d", l, MaxEncodedLength)
+	}
+	polyBuf, err := readLength(r, l)
+	if err != nil {
 		return fmt.Errorf("read polygon: %w", unexpectedEOF(err))
 	}
 	f.Polygon = &s2.Polygon{}
-	if err := f.Polygon.Decode(bytes.NewReader(polyBuf)); err != nil {
+	if err := decodePolygon(f.Polygon, polyBuf); err != nil {
 		return fmt.Errorf("bad polygon in geometry: %w", unexpectedEOF(err))
 	}
 
 	return nil
 }
 
+// readLength reads exactly l bytes from r. Unlike io.ReadFull into a buffer of
+// length l, memory is only allocated as the data arrives, so a corrupt length
+// can't cause a large allocation by itself.
+func readLength(r io.Reader, l uint32) ([]byte, error) {
+	buf := bytes.NewBuffer(make([]byte, 0, min(l, 64<<10)))
+	if _, err := io.CopyN(buf, r, int64(l)); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// decodePolygon decodes buf into p. s2 allocates the loops and vertices as
+// declared in the encoding before reading them, so the declared counts are
+// checked first, and s2 panics on some malformed encodings, which are
+// returned as errors instead.
+func decodePolygon(p *s2.Polygon, buf []byte) (err error) {
+	if err := checkPolygonCounts(buf); err != nil {
+		return err
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("malformed polygon: %v", r)
+		}
+	}()
+
+	return p.Decode(bytes.NewReader(buf))
+}
+
+const (
+	// s2 polygon encoding versions
+	polygonLossless   = 1
+	polygonCompressed = 4
+
+	// Sizes in the lossless encoding of a loop, without its vertices, and of
+	// the bounding rectangle.
+	losslessLoopSize = 1 + 4 + 1 + 4 + losslessRectSize
+	losslessRectSize = 1 + 4*8
+)
+
+// checkPolygonCounts returns an error if the loop or vertex counts declared
+// in the s2 encoding of a polygon in buf need more data than buf has. The
+// lossless encoding written by rgeo is checked completely. The points of the
+// compressed encoding have variable length, so only the number of loops and
+// the vertices of the first loop are checked, assuming at least a byte each.
+func checkPolygonCounts(buf []byte) error {
+	if len(buf) == 0 {
+		return nil
+	}
+
+	switch buf[0] {
+	case polygonLossless:
+		if len(buf) < 7 {
+			return nil
+		}
+		nloops := uint64(binary.LittleEndian.Uint32(buf[3:]))
+		rest := uint64(len(buf) - 7)
+		if nloops*losslessLoopSize+losslessRectSize > rest {
+			return fmt.Errorf("%d loops don't fit in %d bytes", nloops, len(buf))
+		}
+		for i := uint64(0); i < nloops; i++ {
+			// The loop version is checked by s2
+			nvertices := uint64(binary.LittleEndian.Uint32(buf[len(buf)-int(rest)+1:]))
+			size := losslessLoopSize + 24*nvertices
+			if size+(nloops-i-1)*losslessLoopSize+losslessRectSize > rest {
+				return fmt.Errorf("loop %d with %d vertices doesn't fit in %d bytes",
+					i, nvertices, len(buf))
+			}
+			rest -= size
+		}
+	case polygonCompressed:
+		r := bytes.NewReader(buf[2:])
+		nloops, err := binary.ReadUvarint(r)
+		if err != nil {
+			return nil
+		}
+		if nloops > uint64(r.Len()) {
+			return fmt.Errorf("%d loops don't fit in %d bytes", nloops, len(buf))
+		}
+		if nvertices, err := binary.ReadUvarint(r); err == nil && nvertices > uint64(r.Len()) {
+			return fmt.Errorf("loop 0 with %d vertices doesn't fit in %d bytes",
+				nvertices, len(buf))
+		}
+	}
+
+	return nil
+}
+
+// LoadEncoded decodes features written by Encode until the end of r. Since the
+// number of features isn't known, trailing garbage can only be reported as a
+// broken feature, so errors include the byte offset at which that feature
+// starts. Use EncodeV2 to detect truncated data reliably.
 func LoadEncoded(r io.Reader) ([]Feature, error) {
+	cr := &countingReader{r: r}
 	var result []Feature
 	for i := 0; ; i++ {
+		offset := cr.n
 		var f Feature
-		if err := f.Decode(r); err != nil {
+		if err := f.Decode(cr); err != nil {
 			if errors.Is(err, io.EOF) {
 				break
 			}
-			return nil, fmt.Errorf("decode feature %d: %w", i, err)
+			return nil, fmt.Errorf("decode feature %d at byte %d: %w", i, offset, err)
 		}
 		result = append(result, f)
 	}
 	return result, nil
 }
 
+// countingReader counts the bytes read from r.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// Decompressor wraps a compressed reader, for use with LoadEncodedWith. If the
+// returned reader also implements io.Closer, it is closed after loading.
+type Decompressor func(io.Reader) (io.Reader, error)
+
+// ZstdDecompressor is a Decompressor for zstd, the compression used for the
+// included datasets.
+func ZstdDecompressor(r io.Reader) (io.Reader, error) {
+	zr, err := zstd.NewReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("zstd reader setup: %w", err)
+	}
+	return zstdReader{zr}, nil
+}
+
+// zstdReader adapts the Close method of zstd.Decoder to io.Closer.
+type zstdReader struct {
+	*zstd.Decoder
+}
+
+func (z zstdReader) Close() error {
+	z.Decoder.Close()
+	return nil
+}
+
+// GzipDecompressor is a Decompressor for gzip.
+func GzipDecompressor(r io.Reader) (io.Reader, error) {
+	gr, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("gzip reader setup: %w", err)
+	}
+	return gr, nil
+}
+
+// Uncompressed is a Decompressor that returns its input unchanged.
+func Uncompressed(r io.Reader) (io.Reader, error) {
+	return r, nil
+}
+
+// LoadEncodedWith decompresses r with decompress, or ZstdDecompressor if it is
+// nil, and decodes the features in either of the formats written by Encode and
+// EncodeV2. This allows using a different compression than LoadAuto detects.
+func LoadEncodedWith(r io.Reader, decompress Decompressor) ([]Feature, error) {
+	if decompress == nil {
+		decompress = ZstdDecompressor
+	}
+
+	dr, err := decompress(r)
+	if err != nil {
+		return nil, err
+	}
+	if c, ok := dr.(io.Closer); ok {
+		defer func() { _ = c.Close() }()
+	}
+
+	return loadUncompressed(bufio.NewReader(dr))
+}
+
+var (
+	// zstdMagic is the magic number at the start of each zstd frame.
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+
+	// gzipMagic is the magic number at the start of each gzip member.
+	gzipMagic = []byte{0x1f, 0x8b}
+)
+
+// LoadAuto is like LoadEncoded, but also accepts zstd or gzip compressed input
+// and the framed format written by EncodeV2, which are detected by their magic
+// numbers.
+func LoadAuto(r io.Reader) ([]Feature, error) {
+	br := bufio.NewReader(r)
+	magic, err := br.Peek(len(zstdMagic))
+	if err != nil && err != io.EOF {
+		return nil, fmt.Errorf("read magic: %w", err)
+	}
+
+	switch {
+	case bytes.HasPrefix(magic, zstdMagic):
+		return LoadEncodedWith(br, ZstdDecompressor)
+	case bytes.HasPrefix(magic, gzipMagic):
+		return LoadEncodedWith(br, GzipDecompressor)
+	default:
+		return loadUncompressed(br)
+	}
+}
+
+// loadUncompressed decodes features in either of the encoded formats.
+func loadUncompressed(br *bufio.Reader) ([]Feature, error) {
+	if magic, _ := br.Peek(len(framedMagic)); bytes.Equal(magic, framedMagic) {
+		var fc FeatureCollection
+		if err := fc.DecodeV2(br); err != nil {
+			return nil, err
+		}
+		return fc, nil
+	}
+
+	return LoadEncoded(br)
+}
+
+// LoadGeoJSON converts the features of a GeoJSON FeatureCollection, keeping
+// their order. The polygons are converted in parallel on all CPUs. Features
+// with an empty geometry, i.e. none at all or a Polygon or MultiPolygon without
+// any rings, are skipped, since they can't contain any point.
 func LoadGeoJSON(fc geojson.FeatureCollection) (FeatureCollection, error) {
-	features := make(FeatureCollection, 0, len(fc.Features))
+	return LoadGeoJSONWithOptions(fc, GeoJSONOptions{})
+}
+
+// GeoJSONOptions configure how LoadGeoJSONWithOptions reads the properties of
+// the features.
+type GeoJSONOptions struct {
+	// TrimCitySuffix removes a trailing "2" from city names, which Natural
+	// Earth appends to the name_conve of some cities. It is used for the
+	// included Cities10, but would mangle other names ending in "2".
+	TrimCitySuffix bool
+
+	// Source is set as the Location.Source of all features, e.g. the name of
+	// the file they were read from.
+	Source string
+
+	// Mapping gives the properties the Location fields are read from. If it
+	// is nil, DefaultPropertyMapping is used.
+	Mapping *PropertyMapping
+}
+
+// extract returns the Location of a feature with the given properties.
+func (o GeoJSONOptions) extract(properties map[string]interface{}) Location {
+	return getLocationStrings(properties, o)
+}
+
+// PropertyMapping gives the GeoJSON property keys each Location field is read
+// from. A field is read from the first of its keys that a feature has, and is
+// left empty if it has none of them. Disputed is set if any of its keys is a
+// non-empty string.
+type PropertyMapping struct {
+	Country            []string
+	CountryLong        []string
+	CountryCode2       []string
+	CountryCode3       []string
+	CountryCodeNumeric []string
+	Sovereignty        []string
+	Disputed           []string
+	Continent          []string
+	Region             []string
+	SubRegion          []string
+	Province           []string
+	ProvinceCode       []string
+	City               []string
+	Population         []string
+	CityPopulation     []string
+}
+
+// DefaultPropertyMapping returns the mapping of the Natural Earth properties,
+// which LoadGeoJSON uses.
+func DefaultPropertyMapping() PropertyMapping {
+	return PropertyMapping{
+		Country:            []string{"ADMIN", "admin"},
+		CountryLong:        []string{"FORMAL_EN"},
+		CountryCode2:       []string{"ISO_A2_EH"},
+		CountryCode3:       []string{"ISO_A3_EH"},
+		CountryCodeNumeric: []string{"ISO_N3_EH", "ISO_N3", "iso_n3"},
+		Sovereignty:        []string{"SOVEREIGNT", "SOVEREIGN1"},
+		Disputed:           []string{"NOTE_BRK", "note_brk"},
+		Continent:          []string{"CONTINENT"},
+		Region:             []string{"REGION_UN"},
+		SubRegion:          []string{"SUBREGION"},
+		Province:           []string{"name"},
+		ProvinceCode:       []string{"iso_3166_2"},
+		City:               []string{"name_conve"},
+		Population:         []string{"POP_EST"},
+		CityPopulation:     []string{"max_pop_al", "pop_max"},
+	}
+}
+
+// defaultPropertyMapping is used when GeoJSONOptions has no Mapping, so that
+// it isn't built again for each feature.
+var defaultPropertyMapping = DefaultPropertyMapping()
+
+// fields returns the names of the Location fields with their keys, in the
+// order of the Location struct.
+func (m PropertyMapping) fields() []struct {
+	name string
+	keys []string
+} {
+	return []struct {
+		name string
+		keys []string
+	}{
+		{"Country", m.Country},
+		{"CountryLong", m.CountryLong},
+		{"CountryCode2", m.CountryCode2},
+		{"CountryCode3", m.CountryCode3},
+		{"CountryCodeNumeric", m.CountryCodeNumeric},
+		{"Sovereignty", m.Sovereignty},
+		{"Disputed", m.Disputed},
+		{"Continent", m.Continent},
+		{"Region", m.Region},
+		{"SubRegion", m.SubRegion},
+		{"Province", m.Province},
+		{"ProvinceCode", m.ProvinceCode},
+		{"City", m.City},
+		{"Population", m.Population},
+		{"CityPopulation", m.CityPopulation},
+	}
+}
+
+// Validate returns a warning for each field whose keys appear in none of the
+// features of fc, e.g. because of a typo, in which case the field would be
+// empty in all Locations. Fields without keys aren't mapped and are ignored.
+func (m PropertyMapping) Validate(fc geojson.FeatureCollection) []string {
+	seen := make(map[string]bool)
 	for _, f := range fc.Features {
-		poly, err := polygonFromGeometry(f.Geometry)
-		if err != nil {
-			return nil, fmt.Errorf("bad polygon in geometry: %w", err)
+		for k := range f.Properties {
+			seen[k] = true
+		}
+	}
+
+	var warnings []string
+	for _, field := range m.fields() {
+		found := len(field.keys) == 0
+		for _, k := range field.keys {
+			found = found || seen[k]
+		}
+		if !found {
+			warnings = append(warnings, fmt.Sprintf("%s: no feature has property %s",
+				field.name, strings.Join(field.keys, " or ")))
+		}
+	}
+	return warnings
+}
+
+// LoadGeoJSONWithOptions is like LoadGeoJSON, but with the given options.
+func LoadGeoJSONWithOptions(fc geojson.FeatureCollection, opts GeoJSONOptions) (FeatureCollection, error) {
+	features, _, err := loadGeoJSON(fc, opts.extract, runtime.GOMAXPROCS(0))
+	return features, err
+}
+
+// LoadGeoJSONFunc is like LoadGeoJSON, but the Location of each feature is
+// returned by extract for its properties, for data that doesn't use the
+// property names of Natural Earth or needs to combine several properties into
+// one field. extract is called concurrently, so it must be safe for concurrent
+// use. If it is nil the properties are read like LoadGeoJSON does.
+func LoadGeoJSONFunc(fc geojson.FeatureCollection, extract func(map[string]interface{}) Location) (FeatureCollection, error) {
+	if extract == nil {
+		extract = GeoJSONOptions{}.extract
+	}
+	features, _, err := loadGeoJSON(fc, extract, runtime.GOMAXPROCS(0))
+	return features, err
+}
+
+// LoadGeoJSONWithSkipped is like LoadGeoJSONWithOptions, but also returns the
+// number of features that were skipped because their geometry is empty, e.g.
+// to warn about them.
+func LoadGeoJSONWithSkipped(fc geojson.FeatureCollection, opts GeoJSONOptions) (FeatureCollection, int, error) {
+	return loadGeoJSON(fc, opts.extract, runtime.GOMAXPROCS(0))
+}
+
+// loadGeoJSON implements LoadGeoJSONWithSkipped and LoadGeoJSONFunc with the
+// given function for the Locations and number of workers. If several features
+// fail to convert, the error of the one with the lowest index is returned.
+func loadGeoJSON(fc geojson.FeatureCollection, extract func(map[string]interface{}) Location, workers int) (FeatureCollection, int, error) {
+	if workers < 1 {
+		workers = 1
+	}
+
+	var (
+		features = make(FeatureCollection, len(fc.Features))
+		errs     = make([]error, len(fc.Features))
+		next     atomic.Int64
+		wg       sync.WaitGroup
+	)
+
+	// Index of the first failing feature found so far. Features are claimed
+	// in order, so the ones before it have all been claimed and a worker can
+	// stop once it claims one after it.
+	var lowest atomic.Int64
+	lowest.Store(int64(len(fc.Features)))
+
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				i := next.Add(1) - 1
+				if i >= lowest.Load() {
+					return
+				}
+
+				f := fc.Features[i]
+				if emptyGeometry(f.Geometry) {
+					continue
+				}
+				poly, err := PolygonFromGeometry(f.Geometry)
+				if err != nil {
+					errs[i] = err
+					for {
+						l := lowest.Load()
+						if i >= l || lowest.CompareAndSwap(l, i) {
+							break
+						}
+					}
+					return
+				}
+				features[i] = Feature{
+					Location: extract(f.Properties),
+					Polygon:  poly,
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if i := int(lowest.Load()); i < len(fc.Features) {
+		return nil, 0, fmt.Errorf("bad polygon in geometry of feature %d: %w", i, errs[i])
+	}
+
+	// Drop the skipped features, which have no Polygon
+	kept := features[:0]
+	for _, f := range features {
+		if f.Polygon != nil {
+			kept = append(kept, f)
+		}
+	}
+	return kept, len(features) - len(kept), nil
+}
+
+// emptyGeometry reports whether g is nil or a Polygon or MultiPolygon without
+// any rings.
+func emptyGeometry(g geom.T) bool {
+	switch t := g.(type) {
+	case nil:
+		return true
+	case *geom.Polygon:
+		return t.NumLinearRings() == 0
+	case *geom.MultiPolygon:
+		for i := 0; i < t.NumPolygons(); i++ {
+			if t.Polygon(i).NumLinearRings() > 0 {
+				return false
+			}
 		}
-		features = append(features, Feature{
-			Location: getLocationStrings(f.Properties),
-			Polygon:  poly,
-		})
+		return true
+	default:
+		return false
 	}
-	return features, nil
 }
 
 func unexpectedEOF(err error) error {
@@ -0,0 +1,548 @@
+package rgeo
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"runtime"
+	"testing"
+
+	"github.com/go-test/deep"
+	"github.com/golang/geo/s2"
+	"github.com/klauspost/compress/zstd"
+	"github.com/twpayne/go-geom"
+	"github.com/twpayne/go-geom/encoding/geojson"
+)
+
+// testFeatures returns a small FeatureCollection for encoding tests.
+func testFeatures(t testing.TB) FeatureCollection {
+	return FeatureCollection(testDataset(t, distanceTestData)())
+}
+
+// compareFeatures checks that the features have the same locations and
+// polygons.
+func compareFeatures(t *testing.T, expected, actual []Feature) {
+	t.Helper()
+	if len(expected) != len(actual) {
+		t.Fatalf("expected %d features, got %d", len(expected), len(actual))
+	}
+	for i := range expected {
+		if diff := deep.Equal(expected[i].Location, actual[i].Location); diff != nil {
+			t.Errorf("feature %d: %v", i, diff)
+		}
+		if !polygonsEqual(expected[i].Polygon, actual[i].Polygon) {
+			t.Errorf("feature %d: polygons differ", i)
+		}
+	}
+}
+
+// polygonsEqual reports whether both polygons have the same loops.
+func polygonsEqual(a, b *s2.Polygon) bool {
+	if a.NumLoops() != b.NumLoops() {
+		return false
+	}
+	for i := 0; i < a.NumLoops(); i++ {
+		if !a.Loop(i).Equal(b.Loop(i)) {
+			return false
+		}
+	}
+	return true
+}
+
+func TestLoadAuto(t *testing.T) {
+	fc := testFeatures(t)
+
+	raw := bytes.NewBuffer(nil)
+	if err := fc.Encode(raw); err != nil {
+		t.Fatal(err)
+	}
+
+	compressed := bytes.NewBuffer(nil)
+	zw, err := zstd.NewWriter(compressed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := zw.Write(raw.Bytes()); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	gzipped := bytes.NewBuffer(nil)
+	gw := gzip.NewWriter(gzipped)
+	if _, err := gw.Write(raw.Bytes()); err != nil {
+		t.Fatal(err)
+	}
+	if err := gw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, in := range map[string][]byte{
+		"raw":  raw.Bytes(),
+		"zstd": compressed.Bytes(),
+		"gzip": gzipped.Bytes(),
+	} {
+		in := in
+		t.Run(name, func(t *testing.T) {
+			result, err := LoadAuto(bytes.NewReader(in))
+			if err != nil {
+				t.Fatal(err)
+			}
+			compareFeatures(t, fc, result)
+		})
+	}
+
+	t.Run("empty", func(t *testing.T) {
+		result, err := LoadAuto(bytes.NewReader(nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(result) != 0 {
+			t.Errorf("expected no features, got %d", len(result))
+		}
+	})
+}
+
+func TestLoadEncodedWith(t *testing.T) {
+	fc := testFeatures(t)
+
+	raw := bytes.NewBuffer(nil)
+	if err := fc.EncodeV2(raw); err != nil {
+		t.Fatal(err)
+	}
+
+	compressed := bytes.NewBuffer(nil)
+	zw, err := zstd.NewWriter(compressed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := zw.Write(raw.Bytes()); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	deflated := bytes.NewBuffer(nil)
+	fw, err := flate.NewWriter(deflated, flate.BestCompression)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fw.Write(raw.Bytes()); err != nil {
+		t.Fatal(err)
+	}
+	if err := fw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	var closed bool
+	inflate := func(r io.Reader) (io.Reader, error) {
+		return closeFunc{flate.NewReader(r), func() { closed = true }}, nil
+	}
+
+	tests := []struct {
+		name       string
+		in         []byte
+		decompress Decompressor
+	}{
+		{name: "Default", in: compressed.Bytes()},
+		{name: "Uncompressed", in: raw.Bytes(), decompress: Uncompressed},
+		{name: "Custom", in: deflated.Bytes(), decompress: inflate},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			result, err := LoadEncodedWith(bytes.NewReader(test.in), test.decompress)
+			if err != nil {
+				t.Fatal(err)
+			}
+			compareFeatures(t, fc, result)
+		})
+	}
+
+	if !closed {
+		t.Error("expected the custom reader to be closed")
+	}
+
+	if _, err := LoadEncodedWith(bytes.NewReader(raw.Bytes()), GzipDecompressor); err == nil {
+		t.Error("expected error for data that isn't gzipped")
+	}
+}
+
+// closeFunc is an io.ReadCloser calling close when closed.
+type closeFunc struct {
+	io.Reader
+	close func()
+}
+
+func (c closeFunc) Close() error {
+	c.close()
+	return nil
+}
+
+func TestLoadEncoded(t *testing.T) {
+	fc := testFeatures(t)
+
+	buf := bytes.NewBuffer(nil)
+	if err := fc.Encode(buf); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+
+	result, err := LoadEncoded(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	compareFeatures(t, fc, result)
+
+	expected := fmt.Sprintf("decode feature 4 at byte %d: read location length: unexpected EOF", len(data))
+	_, err = LoadEncoded(bytes.NewReader(append(append([]byte(nil), data...), 1, 2)))
+	if err == nil || err.Error() != expected {
+		t.Errorf("expected error: %s\n got: %v\n", expected, err)
+	}
+}
+
+func TestEncodeV2(t *testing.T) {
+	fc := testFeatures(t)
+
+	buf := bytes.NewBuffer(nil)
+	if err := fc.EncodeV2(buf); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+
+	var result FeatureCollection
+	if err := result.DecodeV2(bytes.NewReader(data)); err != nil {
+		t.Fatal(err)
+	}
+	compareFeatures(t, fc, result)
+
+	auto, err := LoadAuto(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	compareFeatures(t, fc, auto)
+
+	last := len(data) - len(encodeFeature(t, fc[3]))
+	tests := []struct {
+		name string
+		in   []byte
+		err  string
+	}{
+		{
+			name: "Truncated",
+			in:   data[:len(data)-10],
+			err:  fmt.Sprintf("decode feature 3 of 4 at byte %d: read polygon: unexpected EOF", last),
+		},
+		{
+			name: "Missing feature",
+			in:   data[:last],
+			err:  fmt.Sprintf("decode feature 3 of 4 at byte %d: unexpected EOF", last),
+		},
+		{
+			name: "Trailing data",
+			in:   append(append([]byte(nil), data...), 0),
+			err:  "trailing data after 4 features",
+		},
+		{
+			name: "Bad magic",
+			in:   append([]byte("RGEO"), data[4:]...),
+			err:  "bad magic number",
+		},
+		{
+			name: "Bad version",
+			in:   append(append([]byte("rgeo"), 3), data[5:]...),
+			err:  "unsupported version 3",
+		},
+		{
+			name: "Empty",
+			in:   nil,
+			err:  "read header: unexpected EOF",
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			var result FeatureCollection
+			err := result.DecodeV2(bytes.NewReader(test.in))
+			if err == nil || err.Error() != test.err {
+				t.Errorf("expected error: %s\n got: %s\n", test.err, err)
+			}
+		})
+	}
+}
+
+// encodeFeature returns the encoding of a single feature.
+func encodeFeature(t *testing.T, f Feature) []byte {
+	buf := bytes.NewBuffer(nil)
+	if err := f.Encode(buf); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// circleFeatures returns n GeoJSON features, each a polygon with the given
+// number of vertices.
+func circleFeatures(n, vertices int) geojson.FeatureCollection {
+	var fc geojson.FeatureCollection
+	for i := 0; i < n; i++ {
+		cx, cy := float64(i%300)-150, float64(i%120)-60
+		flat := make([]float64, 0, 2*(vertices+1))
+		for j := 0; j <= vertices; j++ {
+			a := 2 * math.Pi * float64(j%vertices) / float64(vertices)
+			flat = append(flat, cx+0.4*math.Cos(a), cy+0.4*math.Sin(a))
+		}
+		fc.Features = append(fc.Features, &geojson.Feature{
+			Geometry:   geom.NewPolygonFlat(geom.XY, flat, []int{len(flat)}),
+			Properties: map[string]interface{}{"ISO_A3_EH": fmt.Sprint(i)},
+		})
+	}
+	return fc
+}
+
+func TestLoadGeoJSONWithOptions(t *testing.T) {
+	var fc geojson.FeatureCollection
+	if err := json.Unmarshal([]byte(`{"type":"FeatureCollection","features":[
+		{"type":"Feature","properties":{"name_conve":"Area 52"},
+		 "geometry":{"type":"Polygon",
+		  "coordinates":[[[0,0],[1,0],[1,1],[0,1],[0,0]]]}}]}`), &fc); err != nil {
+		t.Fatalf("decode GeoJSON: %s", err)
+	}
+
+	for _, test := range []struct {
+		opts     GeoJSONOptions
+		expected Location
+	}{
+		{GeoJSONOptions{}, Location{City: "Area 52"}},
+		{GeoJSONOptions{TrimCitySuffix: true}, Location{City: "Area 5"}},
+		{GeoJSONOptions{Source: "in.geojson"}, Location{City: "Area 52", Source: "in.geojson"}},
+		{GeoJSONOptions{Mapping: &PropertyMapping{Region: []string{"name_conve"}}}, Location{Region: "Area 52"}},
+	} {
+		features, err := LoadGeoJSONWithOptions(fc, test.opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if diff := deep.Equal(test.expected, features[0].Location); diff != nil {
+			t.Errorf("%+v: %v", test.opts, diff)
+		}
+	}
+}
+
+func TestPropertyMappingValidate(t *testing.T) {
+	var fc geojson.FeatureCollection
+	if err := json.Unmarshal([]byte(`{"type":"FeatureCollection","features":[
+		{"type":"Feature","properties":{"NAME":"Testland"},
+		 "geometry":{"type":"Polygon",
+		  "coordinates":[[[0,0],[1,0],[1,1],[0,1],[0,0]]]}},
+		{"type":"Feature","properties":{"iso3":"TST","pop":5},
+		 "geometry":{"type":"Polygon",
+		  "coordinates":[[[1,0],[2,0],[2,1],[1,1],[1,0]]]}}]}`), &fc); err != nil {
+		t.Fatalf("decode GeoJSON: %s", err)
+	}
+
+	m := PropertyMapping{
+		Country:      []string{"ADMIN", "NAME"},
+		CountryCode3: []string{"iso_3"},
+		Population:   []string{"pop"},
+		City:         []string{"city", "town"},
+	}
+	expected := []string{
+		"CountryCode3: no feature has property iso_3",
+		"City: no feature has property city or town",
+	}
+	if diff := deep.Equal(expected, m.Validate(fc)); diff != nil {
+		t.Error(diff)
+	}
+
+	m.CountryCode3 = []string{"iso3"}
+	m.City = nil
+	if warnings := m.Validate(fc); len(warnings) != 0 {
+		t.Errorf("expected no warnings, got %v", warnings)
+	}
+}
+
+func TestLoadGeoJSONFunc(t *testing.T) {
+	var fc geojson.FeatureCollection
+	if err := json.Unmarshal([]byte(`{"type":"FeatureCollection","features":[
+		{"type":"Feature","properties":{"state":"Bavaria","district":"Upper Franconia",
+		  "name_conve":"Ignored"},
+		 "geometry":{"type":"Polygon",
+		  "coordinates":[[[0,0],[1,0],[1,1],[0,1],[0,0]]]}}]}`), &fc); err != nil {
+		t.Fatalf("decode GeoJSON: %s", err)
+	}
+
+	features, err := LoadGeoJSONFunc(fc, func(p map[string]interface{}) Location {
+		return Location{Province: fmt.Sprintf("%s, %s", p["district"], p["state"])}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := Location{Province: "Upper Franconia, Bavaria"}
+	if diff := deep.Equal(expected, features[0].Location); diff != nil {
+		t.Error(diff)
+	}
+
+	// Without a function the default properties are used
+	if features, err = LoadGeoJSONFunc(fc, nil); err != nil {
+		t.Fatal(err)
+	} else if diff := deep.Equal(Location{City: "Ignored"}, features[0].Location); diff != nil {
+		t.Error(diff)
+	}
+}
+
+func TestLoadGeoJSONWithSkipped(t *testing.T) {
+	var fc geojson.FeatureCollection
+	if err := json.Unmarshal([]byte(`{"type":"FeatureCollection","features":[
+		{"type":"Feature","properties":{"name_conve":"No rings"},
+		 "geometry":{"type":"Polygon","coordinates":[]}},
+		{"type":"Feature","properties":{"name_conve":"Kept"},
+		 "geometry":{"type":"Polygon",
+		  "coordinates":[[[0,0],[1,0],[1,1],[0,1],[0,0]]]}},
+		{"type":"Feature","properties":{"name_conve":"No polygons"},
+		 "geometry":{"type":"MultiPolygon","coordinates":[]}},
+		{"type":"Feature","properties":{"name_conve":"Empty polygons"},
+		 "geometry":{"type":"MultiPolygon","coordinates":[[],[]]}},
+		{"type":"Feature","properties":{"name_conve":"No geometry"},
+		 "geometry":null}]}`), &fc); err != nil {
+		t.Fatalf("decode GeoJSON: %s", err)
+	}
+
+	features, skipped, err := LoadGeoJSONWithSkipped(fc, GeoJSONOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if skipped != 4 {
+		t.Errorf("expected 4 skipped features, got %d", skipped)
+	}
+	if len(features) != 1 || features[0].Location.City != "Kept" {
+		t.Errorf("expected only the feature Kept, got %v", features)
+	}
+
+	if features, err := LoadGeoJSON(fc); err != nil {
+		t.Fatal(err)
+	} else if len(features) != 1 {
+		t.Errorf("expected 1 feature, got %d", len(features))
+	}
+}
+
+func TestLoadGeoJSONParallel(t *testing.T) {
+	fc := circleFeatures(100, 16)
+
+	serial, _, err := loadGeoJSON(fc, GeoJSONOptions{}.extract, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parallel, _, err := loadGeoJSON(fc, GeoJSONOptions{}.extract, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	compareFeatures(t, serial, parallel)
+
+	// The error of the first bad feature is returned, however the features
+	// are spread over the workers
+	fc.Features[40].Geometry = geom.NewPoint(geom.XY)
+	for i := 41; i < len(fc.Features); i++ {
+		fc.Features[i].Geometry = geom.NewPolygonFlat(geom.XY, []float64{0, 0, 1, 1, 0, 0}, []int{6})
+	}
+	expected := "bad polygon in geometry of feature 40: needs Polygon or MultiPolygon"
+	for _, workers := range []int{1, 4, 64} {
+		for n := 0; n < 20; n++ {
+			if _, _, err := loadGeoJSON(fc, GeoJSONOptions{}.extract, workers); err == nil || err.Error() != expected {
+				t.Fatalf("expected error: %s\n got: %v\n", expected, err)
+			}
+		}
+	}
+}
+
+func BenchmarkLoadGeoJSON(b *testing.B) {
+	fc := circleFeatures(2000, 200)
+
+	for name, workers := range map[string]int{
+		"serial":   1,
+		"parallel": runtime.GOMAXPROCS(0),
+	} {
+		workers := workers
+		b.Run(name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if _, _, err := loadGeoJSON(fc, GeoJSONOptions{}.extract, workers); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestFeatureDecodeLimits(t *testing.T) {
+	le := func(v uint32) []byte {
+		return binary.LittleEndian.AppendUint32(nil, v)
+	}
+	location := append(le(2), "{}"...)
+
+	tests := []struct {
+		name string
+		in   []byte
+		err  string
+	}{
+		{
+			name: "Location too long",
+			in:   le(MaxEncodedLength + 1),
+			err:  fmt.Sprintf("location length %d exceeds maximum of %d", MaxEncodedLength+1, MaxEncodedLength),
+		},
+		{
+			name: "Polygon too long",
+			in:   append(location, le(1<<31)...),
+			err:  fmt.Sprintf("polygon length %d exceeds maximum of %d", 1<<31, MaxEncodedLength),
+		},
+		{
+			name: "Truncated",
+			in:   append(location, le(1<<20)...),
+			err:  "read polygon: unexpected EOF",
+		},
+		{
+			name: "Too many loops",
+			in:   append(append(location, le(7)...), 1, 1, 0, 0, 0, 0, 1),
+			err:  "bad polygon in geometry: 16777216 loops don't fit in 7 bytes",
+		},
+		{
+			name: "Too many vertices",
+			in: append(append(append(location, le(83)...), 1, 1, 0),
+				append(append(le(1), 1), append(le(50000000), make([]byte, 71)...)...)...),
+			err: "bad polygon in geometry: loop 0 with 50000000 vertices doesn't fit in 83 bytes",
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			var f Feature
+			err := f.Decode(bytes.NewReader(test.in))
+			if err == nil || err.Error() != test.err {
+				t.Errorf("expected error: %s\n got: %v\n", test.err, err)
+			}
+		})
+	}
+}
+
+func FuzzFeatureDecode(f *testing.F) {
+	for _, feat := range testFeatures(f) {
+		buf := bytes.NewBuffer(nil)
+		if err := feat.Encode(buf); err != nil {
+			f.Fatal(err)
+		}
+		f.Add(buf.Bytes())
+	}
+	f.Add([]byte{0xff, 0xff, 0xff, 0xff})
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		var feat Feature
+		_ = feat.Decode(bytes.NewReader(data))
+	})
+}
@@ -1,10 +1,10 @@
 module github.com/sams96/rgeo
 
-go 1.21.0
+go 1.23.0
 
 require (
 	github.com/go-test/deep v1.1.0
-	github.com/golang/geo v0.0.0-20230421003525-6adc56603217
+	github.com/golang/geo v0.0.0-20260818125358-b200a1149890
 	github.com/klauspost/compress v1.17.9
 	github.com/twpayne/go-geom v1.5.4
 )
@@ -0,0 +1,98 @@
+package rgeo
+
+import (
+	"strings"
+
+	"github.com/golang/geo/s2"
+	"github.com/twpayne/go-geom"
+)
+
+// ReverseGeocodeHierarchical is like ReverseGeocode, but only uses provinces
+// that belong to the returned country. A province belongs to a country if the
+// prefix of its ISO 3166-2 ProvinceCode matches the country's ISO 3166-1
+// alpha-2 CountryCode2. This avoids combining a country with a province of its
+// neighbour where the datasets overlap at a border.
+//
+// Only the first containing country is used. If no country contains loc, the
+// first containing province is used as the reference instead.
+func (r *Rgeo) ReverseGeocodeHierarchical(loc geom.Coord) (Location, error) {
+	res, err := r.containingShapesAt(loc)
+	if err != nil {
+		return Location{}, err
+	} else if len(res) == 0 {
+		return Location{}, ErrLocationNotFound
+	}
+
+	var (
+		country   s2.Shape
+		provinces []s2.Shape
+		others    []s2.Shape
+	)
+	for _, s := range res {
+		switch adminLevel(s.(shapeLocation).Location()) {
+		case 0:
+			if country == nil {
+				country = s
+			}
+		case 1:
+			provinces = append(provinces, s)
+		default:
+			others = append(others, s)
+		}
+	}
+
+	var code2 string
+	if country != nil {
+		code2 = country.(shapeLocation).Location().CountryCode2
+	} else if len(provinces) > 0 {
+		code2 = countryCodeOfProvince(provinces[0].(shapeLocation).Location().ProvinceCode)
+	}
+
+	var shapes []s2.Shape
+	if country != nil {
+		shapes = append(shapes, country)
+	}
+	for _, p := range provinces {
+		pc := countryCodeOfProvince(p.(shapeLocation).Location().ProvinceCode)
+		if pc != "" && strings.EqualFold(pc, code2) {
+			shapes = append(shapes, p)
+		}
+	}
+	shapes = append(shapes, others...)
+
+	return r.combineLocations(shapes), nil
+}
+
+// Hierarchy returns the names of the areas containing loc, from the largest to
+// the smallest: "World", the continent, the region and the country. The
+// continent is taken from the Location if the dataset has it, and otherwise
+// from ContinentOf. The region is the SubRegion, e.g. "Western Europe", or the
+// Region if there is no SubRegion. Levels that are unknown are left out.
+//
+// ErrLocationNotFound is returned if loc is not in any country.
+func (r *Rgeo) Hierarchy(loc geom.Coord) ([]string, error) {
+	l, err := r.ReverseGeocode(loc)
+	if err != nil {
+		return nil, err
+	} else if l.Country == "" {
+		return nil, ErrLocationNotFound
+	}
+
+	continent := l.Continent
+	if continent == "" {
+		continent = ContinentOf(l.CountryCode3)
+	}
+	region := l.SubRegion
+	if region == "" {
+		region = l.Region
+	}
+
+	names := []string{"World"}
+	for _, n := range []string{continent, region, l.Country} {
+		if n != "" {
+			names = append(names, n)
+		}
+	}
+
+	return names, nil
+}
@@ -0,0 +1,163 @@
+package rgeo
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/go-test/deep"
+	"github.com/twpayne/go-geom"
+)
+
+func TestReverseGeocodeHierarchical(t *testing.T) {
+	// The province of Beta overlaps Alpha, and comes first in the index.
+	r, err := New(testDataset(t, `{"type":"FeatureCollection","features":[
+		{"type":"Feature","properties":{"admin":"Beta","name":"North","iso_3166_2":"BB-N"},
+		 "geometry":{"type":"Polygon",
+		  "coordinates":[[[1,0],[3,0],[3,2],[1,2],[1,0]]]}},
+		{"type":"Feature","properties":{"admin":"Alpha","name":"West","iso_3166_2":"AA-W"},
+		 "geometry":{"type":"Polygon",
+		  "coordinates":[[[0,0],[1.5,0],[1.5,2],[0,2],[0,0]]]}},
+		{"type":"Feature","properties":{"ADMIN":"Alpha","ISO_A2_EH":"AA","ISO_A3_EH":"AAA"},
+		 "geometry":{"type":"Polygon",
+		  "coordinates":[[[0,0],[2,0],[2,2],[0,2],[0,0]]]}}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		in       geom.Coord
+		err      error
+		expected Location
+	}{
+		{
+			name: "Matching province",
+			in:   geom.Coord{1.2, 1},
+			expected: Location{
+				Country:      "Alpha",
+				CountryCode2: "AA",
+				CountryCode3: "AAA",
+				Province:     "West",
+				ProvinceCode: "AA-W",
+			},
+		},
+		{
+			name: "Only foreign province",
+			in:   geom.Coord{1.8, 1},
+			expected: Location{
+				Country:      "Alpha",
+				CountryCode2: "AA",
+				CountryCode3: "AAA",
+			},
+		},
+		{
+			name: "No country",
+			in:   geom.Coord{2.5, 1},
+			expected: Location{
+				Country:      "Beta",
+				CountryCode2: "BB",
+				Province:     "North",
+				ProvinceCode: "BB-N",
+			},
+		},
+		{
+			name: "Ocean",
+			in:   geom.Coord{5, 1},
+			err:  ErrLocationNotFound,
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			result, err := r.ReverseGeocodeHierarchical(test.in)
+			if err != test.err {
+				t.Errorf("expected error: %s\n got: %s\n", test.err, err)
+			}
+			if diff := deep.Equal(test.expected, result); diff != nil {
+				t.Error(diff)
+			}
+		})
+	}
+}
+
+func TestHierarchy(t *testing.T) {
+	r, err := New(testDataset(t, `{"type":"FeatureCollection","features":[
+		{"type":"Feature","properties":{"ADMIN":"Alpha","ISO_A3_EH":"AAA",
+		  "CONTINENT":"Europe","REGION_UN":"Europe","SUBREGION":"Western Europe"},
+		 "geometry":{"type":"Polygon",
+		  "coordinates":[[[0,0],[2,0],[2,2],[0,2],[0,0]]]}},
+		{"type":"Feature","properties":{"ADMIN":"Austria","ISO_A3_EH":"AUT"},
+		 "geometry":{"type":"Polygon",
+		  "coordinates":[[[3,0],[4,0],[4,1],[3,1],[3,0]]]}},
+		{"type":"Feature","properties":{"name_conve":"Gamma City"},
+		 "geometry":{"type":"Polygon",
+		  "coordinates":[[[10,0],[11,0],[11,1],[10,1],[10,0]]]}}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		in       geom.Coord
+		err      error
+		expected []string
+	}{
+		{
+			name:     "From dataset",
+			in:       geom.Coord{1, 1},
+			expected: []string{"World", "Europe", "Western Europe", "Alpha"},
+		},
+		{
+			name:     "From table",
+			in:       geom.Coord{3.5, 0.5},
+			expected: []string{"World", "Europe", "Austria"},
+		},
+		{
+			name: "No country",
+			in:   geom.Coord{10.5, 0.5},
+			err:  ErrLocationNotFound,
+		},
+		{
+			name: "Nothing",
+			in:   geom.Coord{20, 20},
+			err:  ErrLocationNotFound,
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			result, err := r.Hierarchy(test.in)
+			if err != test.err {
+				t.Errorf("expected error: %s\n got: %v\n", test.err, err)
+			}
+			if diff := deep.Equal(test.expected, result); diff != nil {
+				t.Error(diff)
+			}
+		})
+	}
+}
+
+func TestContinentOf(t *testing.T) {
+	seen := make(map[string]string)
+	for continent, codes := range continentCodes {
+		for _, code := range strings.Fields(codes) {
+			if other, ok := seen[code]; ok {
+				t.Errorf("%s is in %s and %s", code, other, continent)
+			}
+			seen[code] = continent
+		}
+	}
+
+	for code, expected := range map[string]string{
+		"deu": "Europe",
+		"BRA": "South America",
+		"NZL": "Oceania",
+		"XXX": "",
+	} {
+		if result := ContinentOf(code); result != expected {
+			t.Errorf("%s: expected %q, got %q", code, expected, result)
+		}
+	}
+}
@@ -0,0 +1,41 @@
+package rgeo
+
+import (
+	"time"
+
+	"github.com/golang/geo/s2"
+	"github.com/twpayne/go-geom"
+)
+
+// ReverseGeocodeAt is like ReverseGeocode, but only uses the features that
+// were valid at time t according to their ValidFrom and ValidTo, e.g. to look
+// up historical borders. Features without either are valid at all times, and
+// all other lookups use every feature regardless of its validity.
+//
+// The validity has to be set on the features passed to New. It isn't read from
+// GeoJSON, and Encode, EncodeV2 and EncodeMsgpack drop it, so features loaded
+// from those encodings are valid at all times.
+func (r *Rgeo) ReverseGeocodeAt(coord geom.Coord, t time.Time) (Location, error) {
+	res, err := r.containingShapesAt(coord)
+	if err != nil {
+		return Location{}, err
+	}
+
+	var valid []s2.Shape
+	for _, s := range res {
+		if s.(*shape).validAt(t) {
+			valid = append(valid, s)
+		}
+	}
+	if len(valid) == 0 {
+		return Location{}, ErrLocationNotFound
+	}
+
+	return r.combineLocations(valid), nil
+}
+
+// validAt reports whether t is in the validity of the shape.
+func (s *shape) validAt(t time.Time) bool {
+	return (s.validFrom.IsZero() || !t.Before(s.validFrom)) &&
+		(s.validTo.IsZero() || t.Before(s.validTo))
+}
@@ -0,0 +1,127 @@
+package rgeo
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/go-test/deep"
+	"github.com/twpayne/go-geom"
+)
+
+func TestReverseGeocodeAt(t *testing.T) {
+	// Union was split into East and West in 1991, the city always existed
+	features := testDataset(t, `{"type":"FeatureCollection","features":[
+		{"type":"Feature","properties":{"ISO_A3_EH":"UNI"},
+		 "geometry":{"type":"Polygon",
+		  "coordinates":[[[0,0],[2,0],[2,1],[0,1],[0,0]]]}},
+		{"type":"Feature","properties":{"ISO_A3_EH":"WST"},
+		 "geometry":{"type":"Polygon",
+		  "coordinates":[[[0,0],[1,0],[1,1],[0,1],[0,0]]]}},
+		{"type":"Feature","properties":{"ISO_A3_EH":"EST"},
+		 "geometry":{"type":"Polygon",
+		  "coordinates":[[[1,0],[2,0],[2,1],[1,1],[1,0]]]}},
+		{"type":"Feature","properties":{"name_conve":"City"},
+		 "geometry":{"type":"Polygon",
+		  "coordinates":[[[0.2,0.2],[0.4,0.2],[0.4,0.4],[0.2,0.4],[0.2,0.2]]]}}]}`)()
+
+	split := time.Date(1991, 1, 1, 0, 0, 0, 0, time.UTC)
+	features[0].ValidTo = split
+	features[1].ValidFrom = split
+	features[2].ValidFrom = split
+	features[2].ValidTo = split.AddDate(10, 0, 0)
+
+	r, err := New(func() []Feature { return features })
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		in       geom.Coord
+		at       time.Time
+		err      error
+		expected Location
+	}{
+		{"Before", geom.Coord{1.5, 0.5}, split.Add(-time.Second), nil, Location{CountryCode3: "UNI"}},
+		{"From", geom.Coord{1.5, 0.5}, split, nil, Location{CountryCode3: "EST"}},
+		{"Always valid", geom.Coord{0.3, 0.3}, split, nil, Location{CountryCode3: "WST", City: "City"}},
+		{"Until", geom.Coord{1.5, 0.5}, split.AddDate(10, 0, 0), ErrLocationNotFound, Location{}},
+		{"Ocean", geom.Coord{3, 0.5}, split, ErrLocationNotFound, Location{}},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			result, err := r.ReverseGeocodeAt(test.in, test.at)
+			if err != test.err {
+				t.Errorf("expected error: %s\n got: %v\n", test.err, err)
+			}
+			if diff := deep.Equal(test.expected, result); diff != nil {
+				t.Error(diff)
+			}
+		})
+	}
+
+	// Other lookups use all features
+	loc, err := r.ReverseGeocode(geom.Coord{1.5, 0.5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := deep.Equal(Location{CountryCode3: "UNI"}, loc); diff != nil {
+		t.Error(diff)
+	}
+}
+
+func TestReverseGeocodeAt_Encoded(t *testing.T) {
+	features := testDataset(t, `{"type":"FeatureCollection","features":[
+		{"type":"Feature","properties":{"ISO_A3_EH":"OLD"},
+		 "geometry":{"type":"Polygon",
+		  "coordinates":[[[0,0],[1,0],[1,1],[0,1],[0,0]]]}}]}`)()
+	features[0].ValidTo = time.Date(1991, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	encodings := []struct {
+		name   string
+		encode func(*FeatureCollection, *bytes.Buffer) error
+		decode func(*FeatureCollection, *bytes.Buffer) error
+	}{
+		{
+			"V2",
+			func(fc *FeatureCollection, b *bytes.Buffer) error { return fc.EncodeV2(b) },
+			func(fc *FeatureCollection, b *bytes.Buffer) error { return fc.DecodeV2(b) },
+		},
+		{
+			"Msgpack",
+			func(fc *FeatureCollection, b *bytes.Buffer) error { return fc.EncodeMsgpack(b) },
+			func(fc *FeatureCollection, b *bytes.Buffer) error { return fc.DecodeMsgpack(b) },
+		},
+	}
+
+	// The validity isn't encoded, so the feature is valid at all times
+	for _, enc := range encodings {
+		enc := enc
+		t.Run(enc.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			fc := FeatureCollection(features)
+			if err := enc.encode(&fc, &buf); err != nil {
+				t.Fatal(err)
+			}
+			var decoded FeatureCollection
+			if err := enc.decode(&decoded, &buf); err != nil {
+				t.Fatal(err)
+			}
+
+			r, err := New(func() []Feature { return decoded })
+			if err != nil {
+				t.Fatal(err)
+			}
+			loc, err := r.ReverseGeocodeAt(geom.Coord{0.5, 0.5}, time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := deep.Equal(Location{CountryCode3: "OLD"}, loc); diff != nil {
+				t.Error(diff)
+			}
+		})
+	}
+}
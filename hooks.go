@@ -0,0 +1,46 @@
+package rgeo
+
+import (
+	"errors"
+
+	"github.com/twpayne/go-geom"
+)
+
+// Hooks are optional callbacks for observing lookups, see Rgeo.Hooks. Unset
+// callbacks are skipped. They are called synchronously, from all goroutines
+// doing lookups, so they have to be safe for concurrent use and should be
+// fast.
+//
+// Methods built on ReverseGeocode, such as ReverseGeocodeWKT, call the Hooks
+// for every ReverseGeocode they make. ReverseGeocodeSnapping calls them once
+// per lookup, including for results from the cache.
+type Hooks struct {
+	// OnQuery is called at the start of every lookup.
+	OnQuery func()
+
+	// OnHit is called with the Location of every successful lookup.
+	OnHit func(Location)
+
+	// OnMiss is called with the coordinate of every lookup that returns
+	// ErrLocationNotFound. Other errors, e.g. for invalid coordinates, are
+	// neither hits nor misses.
+	OnMiss func(geom.Coord)
+}
+
+// query calls OnQuery if it is set.
+func (h *Hooks) query() {
+	if h.OnQuery != nil {
+		h.OnQuery()
+	}
+}
+
+// result calls OnHit or OnMiss, if they are set, for the result of a lookup of
+// coord.
+func (h *Hooks) result(coord geom.Coord, loc Location, err error) {
+	switch {
+	case err == nil && h.OnHit != nil:
+		h.OnHit(loc)
+	case errors.Is(err, ErrLocationNotFound) && h.OnMiss != nil:
+		h.OnMiss(coord)
+	}
+}
@@ -0,0 +1,47 @@
+package rgeo
+
+import (
+	"testing"
+
+	"github.com/go-test/deep"
+	"github.com/twpayne/go-geom"
+)
+
+func TestHooks(t *testing.T) {
+	r, err := New(testDataset(t, distanceTestData))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Without hooks nothing must be called
+	if _, err := r.ReverseGeocode(geom.Coord{0, 0}); err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		queries int
+		hits    []string
+		misses  []geom.Coord
+	)
+	r.Hooks = Hooks{
+		OnQuery: func() { queries++ },
+		OnHit:   func(l Location) { hits = append(hits, l.City) },
+		OnMiss:  func(c geom.Coord) { misses = append(misses, c) },
+	}
+
+	_, _ = r.ReverseGeocode(geom.Coord{0, 0})
+	_, _ = r.ReverseGeocode(geom.Coord{10, 0})
+	_, _ = r.ReverseGeocode(geom.Coord{0, 100})
+	_, _ = r.ReverseGeocodeSnapping(geom.Coord{4.01, 0})
+	_, _ = r.ReverseGeocodeSnapping(geom.Coord{10, 0})
+
+	if queries != 5 {
+		t.Errorf("expected 5 queries, got %d", queries)
+	}
+	if diff := deep.Equal([]string{"In", ""}, hits); diff != nil {
+		t.Error(diff)
+	}
+	if diff := deep.Equal([]geom.Coord{{10, 0}, {10, 0}}, misses); diff != nil {
+		t.Error(diff)
+	}
+}
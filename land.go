@@ -0,0 +1,93 @@
+package rgeo
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"sync"
+
+	"github.com/golang/geo/s2"
+	"github.com/twpayne/go-geom"
+)
+
+// ErrNoEEZ is returned by IsInternationalWaters if no loaded feature is a
+// maritime Exclusive Economic Zone, see isEEZ.
+var ErrNoEEZ = errors.New("no EEZ dataset loaded")
+
+// lazyPolygon is a polygon computed on first use, see LandPolygon.
+type lazyPolygon struct {
+	once    sync.Once
+	polygon *s2.Polygon
+	err     error
+}
+
+// LandPolygon returns the union of the polygons of all loaded countries as one
+// s2 Polygon, e.g. for fast tests whether points are on land at all. If no
+// dataset has countries, the least specific features with a Country are used
+// instead, such as the provinces of Provinces10. The polygon is computed on the
+// first call and reused until AddDataset is called.
+//
+// The union is built like that of LookupByCode3, so the polygons must not
+// overlap, and shared borders must have the same vertices on both sides, as
+// in the included datasets. An error is returned if the union isn't a valid
+// polygon, e.g. if two datasets with countries are loaded.
+func (r *Rgeo) LandPolygon() (*s2.Polygon, error) {
+	land := r.land
+	land.once.Do(func() {
+		var rings [][]s2.Point
+		for _, s := range r.matchingShapes(func(l Location) bool { return l.Country != "" }) {
+			p := s.Shape.(*s2.Polygon)
+			for i, ring := range orientedRings(p) {
+				// Shared borders cancel out regardless of how the loop
+				// crosses itself, as long as its edges are oriented by
+				// what it contains
+				if isInconsistent(p.Loop(i)) {
+					slices.Reverse(ring)
+				}
+				rings = append(rings, ring)
+			}
+		}
+
+		land.polygon = unionPolygon(rings)
+		if err := land.polygon.Validate(); err != nil {
+			land.polygon, land.err = nil, fmt.Errorf("land polygon of %d loops: %w", len(rings), err)
+		}
+	})
+
+	return land.polygon, land.err
+}
+
+// isInconsistent reports whether the vertices of l are in the opposite order
+// of what it contains, e.g. for Sudan and Alaska in Countries110, whose loops
+// cross themselves. The origin is close to the north pole, so it's only
+// contained by loops larger than a hemisphere.
+func isInconsistent(l *s2.Loop) bool {
+	return (l.Area() > 2*math.Pi) != l.ContainsOrigin()
+}
+
+// IsInternationalWaters returns whether the coordinate is neither on land nor in
+// the Exclusive Economic Zone of any country, i.e. no loaded feature contains
+// it. Features with a Sovereignty but no Country are taken to be EEZs, all
+// others to be land.
+//
+// Without a dataset of EEZs every point off the coast would be international
+// waters, so false and ErrNoEEZ are returned if none is loaded. Points on land
+// are only recognised if a dataset with land, such as Countries10, is loaded
+// too, since EEZs usually don't include the land.
+func (r *Rgeo) IsInternationalWaters(coord geom.Coord) (bool, error) {
+	shapes, err := r.containingShapesAt(coord)
+	if err != nil {
+		return false, err
+	} else if !r.hasEEZ {
+		return false, ErrNoEEZ
+	}
+
+	return len(shapes) == 0, nil
+}
+
+// isEEZ reports whether l is that of a maritime Exclusive Economic Zone, which
+// has a Sovereignty but, unlike the countries of Natural Earth, no Country.
+func isEEZ(l Location) bool {
+	return l.Sovereignty != "" && l.Country == ""
+}
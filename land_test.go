@@ -0,0 +1,190 @@
+package rgeo
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/golang/geo/s2"
+	"github.com/twpayne/go-geom"
+)
+
+func TestLandPolygon(t *testing.T) {
+	// Beta is an enclave in a hole of Alpha, Gamma shares a border with Alpha
+	r, err := New(testDataset(t, `{"type":"FeatureCollection","features":[
+		{"type":"Feature","properties":{"ADMIN":"Alpha"},
+		 "geometry":{"type":"Polygon","coordinates":[
+		  [[0,0],[4,0],[4,4],[0,4],[0,0]],
+		  [[1,1],[2,1],[2,2],[1,2],[1,1]]]}},
+		{"type":"Feature","properties":{"ADMIN":"Beta"},
+		 "geometry":{"type":"Polygon",
+		  "coordinates":[[[1,1],[2,1],[2,2],[1,2],[1,1]]]}},
+		{"type":"Feature","properties":{"ADMIN":"Gamma"},
+		 "geometry":{"type":"Polygon",
+		  "coordinates":[[[4,0],[6,0],[6,2],[4,2],[4,0]]]}},
+		{"type":"Feature","properties":{"name_conve":"Offshore City"},
+		 "geometry":{"type":"Polygon",
+		  "coordinates":[[[10,0],[11,0],[11,1],[10,1],[10,0]]]}}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		lon, lat float64
+		expected bool
+	}{
+		{"Alpha", 3, 3, true},
+		{"Enclave", 1.5, 1.5, true},
+		{"Neighbour", 5, 1, true},
+		{"Ocean", 5, 3, false},
+		{"City only", 10.5, 0.5, false},
+	}
+
+	land, err := r.LandPolygon()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range tests {
+		p := s2.PointFromLatLng(s2.LatLngFromDegrees(test.lat, test.lon))
+		if result := land.ContainsPoint(p); result != test.expected {
+			t.Errorf("%s: expected %t, got %t", test.name, test.expected, result)
+		}
+	}
+
+	if reused, _ := r.LandPolygon(); reused != land {
+		t.Error("expected the polygon to be reused")
+	}
+
+	r.AddDataset(testDataset(t, `{"type":"FeatureCollection","features":[
+		{"type":"Feature","properties":{"ADMIN":"Delta"},
+		 "geometry":{"type":"Polygon",
+		  "coordinates":[[[4,2],[6,2],[6,4],[4,4],[4,2]]]}}]}`))
+	if land, err = r.LandPolygon(); err != nil {
+		t.Fatal(err)
+	}
+	if !land.ContainsPoint(s2.PointFromLatLng(s2.LatLngFromDegrees(3, 5))) {
+		t.Error("expected the polygon to include the added dataset")
+	}
+
+	// Overlapping countries have no valid union
+	r.AddDataset(testDataset(t, `{"type":"FeatureCollection","features":[
+		{"type":"Feature","properties":{"ADMIN":"Epsilon"},
+		 "geometry":{"type":"Polygon",
+		  "coordinates":[[[3,3],[5,3],[5,5],[3,5],[3,3]]]}}]}`))
+	if land, err = r.LandPolygon(); err == nil || land != nil {
+		t.Errorf("expected error for overlapping countries, got %v", land)
+	}
+}
+
+func TestLandPolygon_Datasets(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test (land polygon) in short mode")
+	}
+
+	tests := []struct {
+		name     string
+		in       geom.Coord
+		expected bool
+	}{
+		{"Paris", geom.Coord{2.35, 48.85}, true},
+		{"Germany", geom.Coord{10, 51}, true},
+		{"USA", geom.Coord{-100, 40}, true},
+		{"Central Africa", geom.Coord{20, 0}, true},
+		{"Sudan", geom.Coord{32.5, 15.6}, true},
+		{"Alaska", geom.Coord{-150, 64}, true},
+		{"Gulf of Guinea", geom.Coord{0, 0}, false},
+		{"Atlantic", geom.Coord{-30, 30}, false},
+		{"Pacific", geom.Coord{-150, 0}, false},
+	}
+
+	for _, dataset := range []struct {
+		name string
+		data Dataset
+	}{
+		{"Countries110", Countries110},
+		{"Countries10", Countries10},
+	} {
+		dataset := dataset
+		t.Run(dataset.name, func(t *testing.T) {
+			r, err := New(dataset.data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			land, err := r.LandPolygon()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for _, test := range tests {
+				if result := land.ContainsPoint(pointFromCoord(test.in)); result != test.expected {
+					t.Errorf("%s: expected %t, got %t", test.name, test.expected, result)
+				}
+			}
+
+			// The polygon contains the points that are in a country
+			rnd := rand.New(rand.NewSource(1))
+			for i := 0; i < 1000; i++ {
+				coord := geom.Coord{rnd.Float64()*360 - 180, rnd.Float64()*180 - 90}
+				_, err := r.ReverseGeocode(coord)
+				if expected := err == nil; land.ContainsPoint(pointFromCoord(coord)) != expected {
+					t.Errorf("%v: expected %t", coord, expected)
+				}
+			}
+		})
+	}
+}
+
+func TestIsInternationalWaters(t *testing.T) {
+	// The country has a Sovereignty too, but isn't an EEZ
+	land := `{"type":"FeatureCollection","features":[
+		{"type":"Feature","properties":{"ADMIN":"Test","SOVEREIGNT":"Testland"},
+		 "geometry":{"type":"Polygon",
+		  "coordinates":[[[0,0],[4,0],[4,4],[0,4],[0,0]]]}}]}`
+	eez := `{"type":"FeatureCollection","features":[
+		{"type":"Feature","properties":{"SOVEREIGN1":"Testland"},
+		 "geometry":{"type":"Polygon","coordinates":[
+		  [[-4,-4],[8,-4],[8,8],[-4,8],[-4,-4]],
+		  [[0,0],[4,0],[4,4],[0,4],[0,0]]]}}]}`
+	r, err := New(testDataset(t, land), testDataset(t, eez))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		in       geom.Coord
+		expected bool
+	}{
+		{"Land", geom.Coord{2, 2}, false},
+		{"EEZ", geom.Coord{6, 6}, false},
+		{"High seas", geom.Coord{20, 0}, true},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			result, err := r.IsInternationalWaters(test.in)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result != test.expected {
+				t.Errorf("expected %t, got %t", test.expected, result)
+			}
+		})
+	}
+
+	r, err = New(testDataset(t, land))
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := r.IsInternationalWaters(geom.Coord{20, 0})
+	if err != ErrNoEEZ || result {
+		t.Errorf("expected error: %s\n got: %v, %t\n", ErrNoEEZ, err, result)
+	}
+
+	// EEZs can be added later
+	r.AddDataset(testDataset(t, eez))
+	if result, err = r.IsInternationalWaters(geom.Coord{20, 0}); err != nil || !result {
+		t.Errorf("expected international waters, got: %v, %t", err, result)
+	}
+}
@@ -0,0 +1,133 @@
+package rgeo
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/golang/geo/s2"
+	"github.com/twpayne/go-geom"
+)
+
+// TileLevel is the level of the s2 cells used as tiles by Lazy, which are
+// about 1000 kilometers across.
+const TileLevel = 3
+
+// TileProvider returns the features intersecting the given s2 cell at
+// TileLevel, e.g. by decoding a file written for each tile. Features spanning
+// several tiles have to be returned for each of them.
+type TileProvider func(tile s2.CellID) ([]Feature, error)
+
+// Lazy is a reverse geocoder that only loads the tiles of the Earth that have
+// been queried, each into an Rgeo of its own. This keeps the memory low for
+// services where most queries are in a few countries, since the features and
+// index of all other tiles are never loaded. It is safe for concurrent use.
+type Lazy struct {
+	provider TileProvider
+
+	mu    sync.Mutex
+	tiles map[s2.CellID]*lazyTile
+}
+
+// lazyTile is a tile of Lazy, done is closed once it is loaded.
+type lazyTile struct {
+	done chan struct{}
+	r    *Rgeo
+	err  error
+}
+
+// NewLazy returns a Lazy that loads the tiles from provider on demand.
+func NewLazy(provider TileProvider) (*Lazy, error) {
+	if provider == nil {
+		return nil, errors.New("no tile provider")
+	}
+	return &Lazy{provider: provider, tiles: make(map[s2.CellID]*lazyTile)}, nil
+}
+
+// ReverseGeocode is like Rgeo.ReverseGeocode, loading the tile of the
+// coordinate first if needed.
+func (l *Lazy) ReverseGeocode(coord geom.Coord) (Location, error) {
+	r, err := l.Tile(coord)
+	if err != nil {
+		return Location{}, err
+	}
+	return r.ReverseGeocode(coord)
+}
+
+// Tile returns the Rgeo with the features of the tile containing the given
+// coordinate, loading and building it if it's the first query in the tile.
+// Lookups of points in the tile are exact, but those looking further, like
+// ReverseGeocodeSnapping or NearestCities, only find features within the tile.
+// The Rgeo is shared, so it must not be modified.
+//
+// If the provider returns an error it is wrapped and returned, and the tile is
+// loaded again on the next call.
+func (l *Lazy) Tile(coord geom.Coord) (*Rgeo, error) {
+	if err := validateCoord(coord); err != nil {
+		return nil, err
+	}
+	id := s2.CellIDFromLatLng(s2.LatLngFromDegrees(coord.Y(), coord.X())).Parent(TileLevel)
+
+	l.mu.Lock()
+	t, ok := l.tiles[id]
+	if !ok {
+		t = &lazyTile{done: make(chan struct{})}
+		l.tiles[id] = t
+	}
+	l.mu.Unlock()
+
+	// Another query is already loading the tile
+	if ok {
+		<-t.done
+		return t.r, t.err
+	}
+
+	features, err := l.provider(id)
+	if err != nil {
+		t.err = fmt.Errorf("load tile %s: %w", id.ToToken(), err)
+		l.mu.Lock()
+		delete(l.tiles, id)
+		l.mu.Unlock()
+	} else {
+		t.r, _ = New(func() []Feature { return features })
+		t.r.Build()
+	}
+	close(t.done)
+
+	return t.r, t.err
+}
+
+// LoadedTiles returns the number of tiles that have been loaded or are being
+// loaded.
+func (l *Lazy) LoadedTiles() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return len(l.tiles)
+}
+
+// DatasetTiles returns a TileProvider with the features of d, which is loaded
+// and partitioned into tiles on the first call. This doesn't save the memory of
+// the features themselves, only that of the index of unused tiles, but calling
+// it for every cell at TileLevel gives the tiles to store separately, e.g. with
+// FeatureCollection.Encode.
+func DatasetTiles(d Dataset) TileProvider {
+	tiles := sync.OnceValue(func() map[s2.CellID][]Feature {
+		coverer := s2.RegionCoverer{
+			MinLevel: TileLevel,
+			MaxLevel: TileLevel,
+			MaxCells: 6 << (2 * TileLevel),
+		}
+
+		m := make(map[s2.CellID][]Feature)
+		for _, f := range d() {
+			for _, id := range coverer.Covering(f.Polygon) {
+				m[id] = append(m[id], f)
+			}
+		}
+		return m
+	})
+
+	return func(tile s2.CellID) ([]Feature, error) {
+		return tiles()[tile], nil
+	}
+}
@@ -0,0 +1,75 @@
+package rgeo
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/go-test/deep"
+	"github.com/golang/geo/s2"
+	"github.com/twpayne/go-geom"
+)
+
+func TestLazy(t *testing.T) {
+	// Alpha spans several tiles, Beta is on another cube face
+	tiles := DatasetTiles(testDataset(t, `{"type":"FeatureCollection","features":[
+		{"type":"Feature","properties":{"ISO_A3_EH":"AAA"},
+		 "geometry":{"type":"Polygon",
+		  "coordinates":[[[-10,-5],[10,-5],[10,5],[-10,5],[-10,-5]]]}},
+		{"type":"Feature","properties":{"ISO_A3_EH":"BBB"},
+		 "geometry":{"type":"Polygon",
+		  "coordinates":[[[100,0],[110,0],[110,5],[100,5],[100,0]]]}}]}`))
+
+	var (
+		calls   int
+		failing = true
+	)
+	l, err := NewLazy(func(tile s2.CellID) ([]Feature, error) {
+		calls++
+		if tile.Face() == 1 && failing {
+			failing = false
+			return nil, errors.New("unavailable")
+		}
+		return tiles(tile)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		in       geom.Coord
+		err      error
+		expected Location
+		calls    int
+	}{
+		{"First tile", geom.Coord{-5, 2}, nil, Location{CountryCode3: "AAA"}, 1},
+		{"Same tile", geom.Coord{-6, 3}, nil, Location{CountryCode3: "AAA"}, 1},
+		{"Other tile", geom.Coord{5, -2}, nil, Location{CountryCode3: "AAA"}, 2},
+		{"Empty tile", geom.Coord{-60, 0}, ErrLocationNotFound, Location{}, 3},
+		{"Other face", geom.Coord{105, 2}, nil, Location{CountryCode3: "BBB"}, 5},
+	}
+
+	for _, test := range tests {
+		// Not run as subtests, since they depend on the tiles loaded before
+		if test.name == "Other face" {
+			if _, err := l.ReverseGeocode(test.in); err == nil {
+				t.Fatal("expected error from tile provider")
+			}
+		}
+
+		result, err := l.ReverseGeocode(test.in)
+		if err != test.err {
+			t.Errorf("%s: expected error: %s\n got: %v\n", test.name, test.err, err)
+		}
+		if diff := deep.Equal(test.expected, result); diff != nil {
+			t.Errorf("%s: %v", test.name, diff)
+		}
+		if calls != test.calls {
+			t.Errorf("%s: expected %d calls of the provider, got %d", test.name, test.calls, calls)
+		}
+	}
+
+	if n := l.LoadedTiles(); n != 4 {
+		t.Errorf("expected 4 loaded tiles, got %d", n)
+	}
+}
@@ -0,0 +1,141 @@
+package rgeo
+
+import (
+	"errors"
+	"fmt"
+	"math"
+
+	"github.com/golang/geo/s1"
+	"github.com/golang/geo/s2"
+	"github.com/twpayne/go-geom"
+)
+
+// lineSampleKM is the sampling interval of ReverseGeocodeLineString in
+// kilometers.
+const lineSampleKM = 1
+
+// lineBisectSteps is the number of bisections used to find where the country
+// changes between two samples, which makes the positions precise to a
+// 65536th of the sampling interval.
+const lineBisectSteps = 16
+
+// SegmentLocation is a part of a line string in a single country, as returned
+// by ReverseGeocodeLineString.
+type SegmentLocation struct {
+	// Location has only the country fields, i.e. no Province, City or
+	// CityPopulation, and is empty for parts that aren't in any country.
+	Location Location
+
+	// Start and End are the positions of the part as fractions of the length
+	// of the line string, from 0 to 1.
+	Start, End float64
+}
+
+// ReverseGeocodeLineString returns the countries a line string, e.g. a route,
+// passes through, in order, as ReverseGeocodeLineStringEvery with a sampling
+// interval of 1km.
+func (r *Rgeo) ReverseGeocodeLineString(ls *geom.LineString) ([]SegmentLocation, error) {
+	return r.ReverseGeocodeLineStringEvery(ls, lineSampleKM)
+}
+
+// ReverseGeocodeLineStringEvery returns the countries the line string passes
+// through, in order, each as a SegmentLocation with the part of the line in
+// it. The segments between the coordinates are great circles, which are
+// sampled every intervalKM kilometers (see Radius), so countries that the line
+// only crosses for less than that can be missed. Where the country changes
+// between two samples is then found by bisection.
+//
+// The returned parts are contiguous and cover the whole line, parts outside of
+// any country, e.g. at sea, have an empty Location.
+func (r *Rgeo) ReverseGeocodeLineStringEvery(ls *geom.LineString, intervalKM float64) ([]SegmentLocation, error) {
+	if intervalKM <= 0 {
+		return nil, errors.New("sampling interval must be positive")
+	} else if ls == nil || ls.NumCoords() < 2 {
+		return nil, errors.New("line string needs at least 2 coordinates")
+	}
+
+	points := make([]s2.Point, ls.NumCoords())
+	for i := range points {
+		c := ls.Coord(i)
+		if err := validateCoord(c); err != nil {
+			return nil, fmt.Errorf("coordinate %d: %w", i, err)
+		}
+		points[i] = pointFromCoord(c)
+	}
+
+	if err := r.checkBuilt(); err != nil {
+		return nil, err
+	}
+
+	var total s1.Angle
+	for i := 1; i < len(points); i++ {
+		total += points[i-1].Distance(points[i])
+	}
+	fraction := func(a s1.Angle) float64 {
+		if total == 0 {
+			return 0
+		}
+		return float64(a / total)
+	}
+
+	// Consecutive samples are close to each other, so the same query is
+	// reused for all of them
+	query := s2.NewContainsPointQuery(r.index, s2.VertexModelOpen)
+	countryAt := func(p s2.Point) Location {
+		return countryFields(r.combineLocations(query.ContainingShapes(p)))
+	}
+
+	prev := countryAt(points[0])
+	spans := []SegmentLocation{{Location: prev}}
+
+	var before s1.Angle // length of the segments already sampled
+	for i := 1; i < len(points); i++ {
+		a, b := points[i-1], points[i]
+		length := a.Distance(b)
+		n := int(math.Ceil(length.Radians() * r.radius / intervalKM))
+
+		prevT := 0.0
+		for j := 1; j <= n; j++ {
+			t := float64(j) / float64(n)
+			loc := countryAt(s2.Interpolate(t, a, b))
+			if loc != prev {
+				lo, hi := prevT, t
+				for k := 0; k < lineBisectSteps; k++ {
+					mid := (lo + hi) / 2
+					if countryAt(s2.Interpolate(mid, a, b)) == prev {
+						lo = mid
+					} else {
+						hi = mid
+					}
+				}
+
+				at := fraction(before + s1.Angle(hi*float64(length)))
+				spans[len(spans)-1].End = at
+				spans = append(spans, SegmentLocation{Location: loc, Start: at})
+			}
+			prev, prevT = loc, t
+		}
+
+		before += length
+	}
+	spans[len(spans)-1].End = 1
+
+	return spans, nil
+}
+
+// countryFields returns l with only the fields describing the country.
+func countryFields(l Location) Location {
+	return Location{
+		Country:            l.Country,
+		CountryLong:        l.CountryLong,
+		CountryCode2:       l.CountryCode2,
+		CountryCode3:       l.CountryCode3,
+		CountryCodeNumeric: l.CountryCodeNumeric,
+		Sovereignty:        l.Sovereignty,
+		Disputed:           l.Disputed,
+		Continent:          l.Continent,
+		Region:             l.Region,
+		SubRegion:          l.SubRegion,
+		Population:         l.Population,
+	}
+}
@@ -0,0 +1,108 @@
+package rgeo
+
+import (
+	"math"
+	"testing"
+
+	"github.com/go-test/deep"
+	"github.com/twpayne/go-geom"
+)
+
+func TestReverseGeocodeLineString(t *testing.T) {
+	// Alpha and Beta share a border at longitude 2, Gamma is across the sea,
+	// and the city in Alpha must not split its part
+	r, err := New(testDataset(t, `{"type":"FeatureCollection","features":[
+		{"type":"Feature","properties":{"ADMIN":"Alpha","ISO_A3_EH":"AAA"},
+		 "geometry":{"type":"Polygon",
+		  "coordinates":[[[0,0],[2,0],[2,2],[0,2],[0,0]]]}},
+		{"type":"Feature","properties":{"ADMIN":"Beta","ISO_A3_EH":"BBB"},
+		 "geometry":{"type":"Polygon",
+		  "coordinates":[[[2,0],[4,0],[4,2],[2,2],[2,0]]]}},
+		{"type":"Feature","properties":{"ADMIN":"Gamma","ISO_A3_EH":"CCC"},
+		 "geometry":{"type":"Polygon",
+		  "coordinates":[[[6,0],[8,0],[8,2],[6,2],[6,0]]]}},
+		{"type":"Feature","properties":{"name_conve":"Alpha City"},
+		 "geometry":{"type":"Polygon",
+		  "coordinates":[[[0.5,0.5],[1.5,0.5],[1.5,1.5],[0.5,1.5],[0.5,0.5]]]}}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	alpha := Location{Country: "Alpha", CountryCode3: "AAA"}
+	beta := Location{Country: "Beta", CountryCode3: "BBB"}
+	gamma := Location{Country: "Gamma", CountryCode3: "CCC"}
+
+	tests := []struct {
+		name     string
+		coords   []float64
+		interval float64
+		expected []SegmentLocation
+	}{
+		{
+			name:     "Across",
+			coords:   []float64{1, 1, 7, 1},
+			interval: 1,
+			expected: []SegmentLocation{
+				{alpha, 0, 1.0 / 6},
+				{beta, 1.0 / 6, 3.0 / 6},
+				{Location{}, 3.0 / 6, 5.0 / 6},
+				{gamma, 5.0 / 6, 1},
+			},
+		},
+		{
+			name:     "Several segments",
+			coords:   []float64{1, 1, 3, 1, 7, 1},
+			interval: 50,
+			expected: []SegmentLocation{
+				{alpha, 0, 1.0 / 6},
+				{beta, 1.0 / 6, 3.0 / 6},
+				{Location{}, 3.0 / 6, 5.0 / 6},
+				{gamma, 5.0 / 6, 1},
+			},
+		},
+		{
+			name:     "Within",
+			coords:   []float64{0.2, 1, 1.8, 1},
+			interval: 10,
+			expected: []SegmentLocation{{alpha, 0, 1}},
+		},
+		{
+			name:     "Sea only",
+			coords:   []float64{4.5, 1, 5.5, 1, 5.5, 1},
+			interval: 10,
+			expected: []SegmentLocation{{Location{}, 0, 1}},
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			ls := geom.NewLineStringFlat(geom.XY, test.coords)
+			result, err := r.ReverseGeocodeLineStringEvery(ls, test.interval)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(result) != len(test.expected) {
+				t.Fatalf("expected %v, got %v", test.expected, result)
+			}
+			for i, s := range result {
+				if diff := deep.Equal(test.expected[i].Location, s.Location); diff != nil {
+					t.Errorf("part %d: %v", i, diff)
+				}
+				if math.Abs(s.Start-test.expected[i].Start) > 1e-3 ||
+					math.Abs(s.End-test.expected[i].End) > 1e-3 {
+					t.Errorf("part %d: expected %g to %g, got %g to %g", i,
+						test.expected[i].Start, test.expected[i].End, s.Start, s.End)
+				}
+			}
+		})
+	}
+
+	if _, err := r.ReverseGeocodeLineString(geom.NewLineStringFlat(geom.XY, []float64{1, 1})); err == nil {
+		t.Error("expected error for a single coordinate")
+	}
+	if _, err := r.ReverseGeocodeLineStringEvery(
+		geom.NewLineStringFlat(geom.XY, []float64{1, 1, 2, 1}), 0); err == nil {
+		t.Error("expected error for an interval of zero")
+	}
+}
@@ -0,0 +1,168 @@
+package rgeo
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// textSeparator separates the fields in the text form of a Location.
+const textSeparator = "|"
+
+// MarshalText implements encoding.TextMarshaler. The text form is the compact
+// "CountryCode3|Province|City", which makes Locations usable as map keys and
+// CSV cells. Only those three fields survive a round trip through
+// UnmarshalText.
+//
+// String remains the human readable representation and can't be parsed back.
+func (l Location) MarshalText() ([]byte, error) {
+	fields := []string{l.CountryCode3, l.Province, l.City}
+	for _, f := range fields {
+		if strings.Contains(f, textSeparator) {
+			return nil, fmt.Errorf("field contains separator %q: %q",
+				textSeparator, f)
+		}
+	}
+
+	return []byte(strings.Join(fields, textSeparator)), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler, it parses the form
+// written by MarshalText.
+func (l *Location) UnmarshalText(text []byte) error {
+	fields := strings.Split(string(text), textSeparator)
+	if len(fields) != 3 {
+		return fmt.Errorf("expected 3 fields in location text, got %d: %q",
+			len(fields), text)
+	}
+
+	*l = Location{
+		CountryCode3: fields[0],
+		Province:     fields[1],
+		City:         fields[2],
+	}
+
+	return nil
+}
+
+// location has the same fields as Location but none of its methods, so it can
+// be marshalled as a plain JSON object.
+type location Location
+
+// MarshalJSON implements json.Marshaler. It is needed so that encoding/json
+// keeps writing Location as an object rather than using MarshalText.
+func (l Location) MarshalJSON() ([]byte, error) {
+	return json.Marshal(location(l))
+}
+
+// UnmarshalJSON implements json.Unmarshaler, see MarshalJSON.
+func (l *Location) UnmarshalJSON(data []byte) error {
+	return json.Unmarshal(data, (*location)(l))
+}
+
+// DisplayName returns the City, Province and Country of l that are set,
+// separated by commas, e.g. "Sapporo, Hokkaidō, Japan". Like String, the
+// CountryLong is used if there is no Country.
+func (l Location) DisplayName() string {
+	country := l.Country
+	if country == "" {
+		country = l.CountryLong
+	}
+
+	var parts []string
+	for _, p := range []string{l.City, l.Province, country} {
+		if p != "" {
+			parts = append(parts, p)
+		}
+	}
+
+	return strings.Join(parts, ", ")
+}
+
+// DisplayLocation is a Location that is marshalled to JSON with its
+// DisplayName as an additional "display_name" field, for responses that show
+// the location to users. It is returned by WithDisplayName.
+type DisplayLocation struct {
+	Location
+}
+
+// WithDisplayName returns l as a DisplayLocation. Location itself doesn't
+// include the display name in its JSON to keep it compact.
+func (l Location) WithDisplayName() DisplayLocation {
+	return DisplayLocation{l}
+}
+
+// MarshalJSON implements json.Marshaler.
+func (d DisplayLocation) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		location
+		DisplayName string `json:"display_name,omitempty"`
+	}{location(d.Location), d.DisplayName()})
+}
+
+// Equal reports whether all fields of l and other are equal. It is the same as
+// l == other, and is meant to be used together with Diff.
+func (l Location) Equal(other Location) bool {
+	return l == other
+}
+
+// Diff returns the fields of l that differ in other, keyed by field name, e.g.
+// "Province", with values of the form `"old" -> "new"`, or `old -> new` for
+// Disputed and the populations. It returns nil if the Locations are equal. This
+// is useful for comparing results from different datasets, e.g. Countries10
+// and Countries110.
+func (l Location) Diff(other Location) map[string]string {
+	var diff map[string]string
+	set := func(name, change string) {
+		if diff == nil {
+			diff = make(map[string]string)
+		}
+		diff[name] = change
+	}
+	add := func(name, a, b string) {
+		if a != b {
+			set(name, fmt.Sprintf("%q -> %q", a, b))
+		}
+	}
+
+	add("Country", l.Country, other.Country)
+	add("CountryLong", l.CountryLong, other.CountryLong)
+	add("CountryCode2", l.CountryCode2, other.CountryCode2)
+	add("CountryCode3", l.CountryCode3, other.CountryCode3)
+	add("CountryCodeNumeric", l.CountryCodeNumeric, other.CountryCodeNumeric)
+	add("Sovereignty", l.Sovereignty, other.Sovereignty)
+	if l.Disputed != other.Disputed {
+		set("Disputed", fmt.Sprintf("%t -> %t", l.Disputed, other.Disputed))
+	}
+	add("Continent", l.Continent, other.Continent)
+	add("Region", l.Region, other.Region)
+	add("SubRegion", l.SubRegion, other.SubRegion)
+	add("Province", l.Province, other.Province)
+	add("ProvinceCode", l.ProvinceCode, other.ProvinceCode)
+	add("City", l.City, other.City)
+	add("Source", l.Source, other.Source)
+	if l.Population != other.Population {
+		set("Population", fmt.Sprintf("%d -> %d", l.Population, other.Population))
+	}
+	if l.CityPopulation != other.CityPopulation {
+		set("CityPopulation", fmt.Sprintf("%d -> %d", l.CityPopulation, other.CityPopulation))
+	}
+
+	return diff
+}
+
+// Redact returns l without the fields more specific than keepLevel, e.g. for
+// responses that must not reveal more than the country. LevelCountry removes
+// the Province, ProvinceCode and City, LevelProvince only the City, and both
+// remove the CityPopulation. All other levels keep every field.
+func (l Location) Redact(keepLevel Level) Location {
+	switch keepLevel {
+	case LevelCountry:
+		l.Province, l.ProvinceCode = "", ""
+		fallthrough
+	case LevelProvince:
+		l.City, l.CityPopulation = "", 0
+	}
+
+	return l
+}
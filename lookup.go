@@ -0,0 +1,427 @@
+package rgeo
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/golang/geo/s1"
+	"github.com/golang/geo/s2"
+)
+
+// LookupByName returns the Feature whose Country, CountryLong, Province or City
+// matches the given name, ignoring case. If several features match, only those
+// at the least specific administrative level are used and merged into one
+// Feature, e.g. a country made up of several polygons or, if only Provinces10
+// is loaded, all provinces of the country. The merged Location has only the
+// fields that all matching features agree on, and the polygons are joined
+// along the borders they share, see unionPolygon.
+//
+// ErrLocationNotFound is returned if no feature matches, and an error if the
+// polygons of the matching features overlap, so they can't be merged.
+func (r *Rgeo) LookupByName(name string) (Feature, error) {
+	return r.lookup(func(l Location) bool {
+		return strings.EqualFold(l.Country, name) ||
+			strings.EqualFold(l.CountryLong, name) ||
+			strings.EqualFold(l.Province, name) ||
+			strings.EqualFold(l.City, name)
+	})
+}
+
+// LookupByCode3 is like LookupByName, but matches the ISO 3166-1 alpha-3
+// CountryCode3.
+func (r *Rgeo) LookupByCode3(code string) (Feature, error) {
+	return r.lookup(func(l Location) bool {
+		return strings.EqualFold(l.CountryCode3, code)
+	})
+}
+
+// LookupProvinceCode returns the Location of the province with the given ISO
+// 3166-2 code, e.g. "US-CA", ignoring case. This includes the country the
+// province belongs to, if the dataset has it, as Provinces10 does.
+//
+// ErrLocationNotFound is returned if no province has the code, e.g. because no
+// dataset with provinces was loaded.
+func (r *Rgeo) LookupProvinceCode(code string) (Location, error) {
+	for _, s := range r.shapes() {
+		if s.loc.ProvinceCode != "" && strings.EqualFold(s.loc.ProvinceCode, code) {
+			return s.loc, nil
+		}
+	}
+
+	return Location{}, ErrLocationNotFound
+}
+
+// lookup returns the merged Feature of all shapes at the least specific level
+// whose Location matches.
+func (r *Rgeo) lookup(match func(Location) bool) (Feature, error) {
+	matches := r.matchingShapes(match)
+	if len(matches) == 0 {
+		return Feature{}, ErrLocationNotFound
+	}
+
+	return mergeShapes(matches)
+}
+
+// matchingShapes returns all shapes at the least specific level whose Location
+// matches.
+func (r *Rgeo) matchingShapes(match func(Location) bool) []*shape {
+	var (
+		matches []*shape
+		level   int
+	)
+
+	for _, s := range r.shapes() {
+		if !match(s.loc) {
+			continue
+		}
+
+		switch l := adminLevel(s.loc); {
+		case len(matches) == 0 || l < level:
+			matches, level = []*shape{s}, l
+		case l == level:
+			matches = append(matches, s)
+		}
+	}
+
+	return matches
+}
+
+// shapesByCode3 returns the shapes with the given CountryCode3 at the least
+// specific level, like LookupByCode3.
+func (r *Rgeo) shapesByCode3(code3 string) []*shape {
+	return r.matchingShapes(func(l Location) bool {
+		return strings.EqualFold(l.CountryCode3, code3)
+	})
+}
+
+// BoundingBox returns the bounds in degrees of the country with the given ISO
+// 3166-1 alpha-3 code, ignoring case, across all of its polygons. Like
+// LookupByCode3, only the features at the least specific level are used.
+//
+// For countries spanning the antimeridian, such as Russia or Fiji, the box
+// wraps around and minLon is greater than maxLon, i.e. it covers the
+// longitudes from minLon east to 180 and from -180 east to maxLon.
+//
+// ErrLocationNotFound is returned if no feature has the code.
+func (r *Rgeo) BoundingBox(code3 string) (minLon, minLat, maxLon, maxLat float64, err error) {
+	matches := r.shapesByCode3(code3)
+	if len(matches) == 0 {
+		return 0, 0, 0, 0, ErrLocationNotFound
+	}
+
+	rect := s2.EmptyRect()
+	for _, s := range matches {
+		rect = rect.Union(s.Shape.(*s2.Polygon).RectBound())
+	}
+
+	lo, hi := rect.Lo(), rect.Hi()
+	return lo.Lng.Degrees(), lo.Lat.Degrees(), hi.Lng.Degrees(), hi.Lat.Degrees(), nil
+}
+
+// Complexity returns the number of loops and vertices of the country with the
+// given ISO 3166-1 alpha-3 code, ignoring case, summed over all of its
+// polygons at the least specific level like BoundingBox, e.g. to compare the
+// detail of Countries10 and Countries110 for a country.
+//
+// ErrLocationNotFound is returned if no feature has the code.
+func (r *Rgeo) Complexity(code3 string) (loops int, vertices int, err error) {
+	matches := r.shapesByCode3(code3)
+	if len(matches) == 0 {
+		return 0, 0, ErrLocationNotFound
+	}
+
+	for _, s := range matches {
+		p := s.Shape.(*s2.Polygon)
+		loops += p.NumLoops()
+		for _, l := range p.Loops() {
+			vertices += l.NumVertices()
+		}
+	}
+
+	return loops, vertices, nil
+}
+
+// neighborsDistance is the distance within which Neighbors considers the
+// polygons of two countries to touch, about 6mm on the Earth.
+const neighborsDistance = s1.Angle(1e-9)
+
+// Neighbors returns the countries sharing a land border with the country with
+// the given ISO 3166-1 alpha-3 code, ignoring case, sorted by CountryCode3.
+// Countries touch if their polygons are within a few millimeters of each
+// other, including countries that are enclosed by the other. The Locations
+// have only the country fields, and maritime features, i.e. EEZs, are ignored
+// on both sides.
+//
+// ErrLocationNotFound is returned if no feature has the code.
+func (r *Rgeo) Neighbors(code3 string) ([]Location, error) {
+	if err := r.checkBuilt(); err != nil {
+		return nil, err
+	}
+
+	matches := r.shapesByCode3(code3)
+	if len(matches) == 0 {
+		return nil, ErrLocationNotFound
+	}
+
+	limit := s1.ChordAngleFromAngle(neighborsDistance).Successor()
+	query := s2.NewClosestEdgeQuery(r.index, s2.NewClosestEdgeQueryOptions().DistanceLimit(limit))
+
+	var (
+		neighbors []Location
+		seen      = make(map[string]bool)
+	)
+	for _, s := range matches {
+		if isEEZ(s.loc) {
+			continue
+		}
+
+		for e := 0; e < s.NumEdges(); e++ {
+			for _, res := range query.FindEdges(s2.NewMinDistanceToEdgeTarget(s.Edge(e))) {
+				loc := r.index.Shape(res.ShapeID()).(shapeLocation).Location()
+				if loc.CountryCode3 == "" || isEEZ(loc) ||
+					strings.EqualFold(loc.CountryCode3, code3) || seen[loc.CountryCode3] {
+					continue
+				}
+
+				seen[loc.CountryCode3] = true
+				neighbors = append(neighbors, countryFields(loc))
+			}
+		}
+	}
+
+	sort.Slice(neighbors, func(i, j int) bool {
+		return neighbors[i].CountryCode3 < neighbors[j].CountryCode3
+	})
+
+	return neighbors, nil
+}
+
+// Locations returns the Locations of all loaded features, in the order they
+// were added. Features with the same City, Province and CountryCode3, such as
+// the parts of a country made up of several polygons, are only returned once.
+func (r *Rgeo) Locations() []Location {
+	type key struct{ city, province, code3 string }
+
+	var (
+		locs []Location
+		seen = make(map[key]bool)
+	)
+	for _, s := range r.shapes() {
+		k := key{s.loc.City, s.loc.Province, s.loc.CountryCode3}
+		if seen[k] {
+			continue
+		}
+		seen[k] = true
+		locs = append(locs, s.loc)
+	}
+
+	return locs
+}
+
+// DistinctCountries returns one Location with only the country fields for
+// each CountryCode3 of the loaded features, sorted by Country, e.g. for a list
+// to choose from. The fields are merged from all features with the code, such
+// as the polygons of a country and its provinces, and maritime features, i.e.
+// EEZs, are ignored.
+func (r *Rgeo) DistinctCountries() []Location {
+	var (
+		countries []Location
+		index     = make(map[string]int)
+	)
+	for _, s := range r.shapes() {
+		if s.loc.CountryCode3 == "" || isEEZ(s.loc) {
+			continue
+		}
+
+		loc := countryFields(s.loc)
+		if i, ok := index[loc.CountryCode3]; ok {
+			countries[i] = countryFields(MergeFirstNonEmpty(countries[i], loc))
+			continue
+		}
+		index[loc.CountryCode3] = len(countries)
+		countries = append(countries, loc)
+	}
+
+	sort.SliceStable(countries, func(i, j int) bool {
+		if countries[i].Country != countries[j].Country {
+			return countries[i].Country < countries[j].Country
+		}
+		return countries[i].CountryCode3 < countries[j].CountryCode3
+	})
+
+	return countries
+}
+
+// shapes returns all shapes in the index, in the order they were added.
+func (r *Rgeo) shapes() []*shape {
+	shapes := make([]*shape, 0, r.index.Len())
+	for i := 0; i < r.index.Len(); i++ {
+		shapes = append(shapes, r.index.Shape(int32(i)).(*shape))
+	}
+	return shapes
+}
+
+// mergeShapes returns a Feature with the union of the polygons of all given
+// shapes, and the Location fields that all of them have in common.
+func mergeShapes(shapes []*shape) (Feature, error) {
+	if len(shapes) == 1 {
+		return Feature{
+			Location:  shapes[0].loc,
+			Polygon:   shapes[0].Shape.(*s2.Polygon),
+			ValidFrom: shapes[0].validFrom,
+			ValidTo:   shapes[0].validTo,
+			dataset:   shapes[0].dataset,
+		}, nil
+	}
+
+	loc := shapes[0].loc
+	polygons := make([]*s2.Polygon, len(shapes))
+	for i, s := range shapes {
+		loc = commonLocation(loc, s.loc)
+		polygons[i] = s.Shape.(*s2.Polygon)
+
+		// Validate doesn't check whether the loops of the union cross, so
+		// overlaps are checked here. Polygons that only share a border don't
+		// intersect.
+		for _, p := range polygons[:i] {
+			if p.RectBound().Intersects(polygons[i].RectBound()) && p.Intersects(polygons[i]) {
+				return Feature{}, fmt.Errorf("merge %d polygons of %s: polygons overlap", len(shapes), loc)
+			}
+		}
+	}
+
+	var rings [][]s2.Point
+	for _, p := range polygons {
+		rings = append(rings, orientedRings(p)...)
+	}
+	union := unionPolygon(rings)
+	if err := union.Validate(); err != nil {
+		return Feature{}, fmt.Errorf("merge %d polygons of %s: %w", len(shapes), loc, err)
+	}
+
+	return Feature{Location: loc, Polygon: union}, nil
+}
+
+// unionPolygon returns the union of polygons that don't overlap, but may share
+// borders, such as the provinces of a country, given as the rings of their
+// loops with the interior on the left, see orientedRings. Edges that one
+// polygon has in the opposite direction of another are on a shared border, so
+// they are dropped, and the others are joined into loops. This requires the
+// vertices of shared borders to be the same in both polygons, as they are in
+// the included datasets. The polygons must not overlap.
+func unionPolygon(rings [][]s2.Point) *s2.Polygon {
+	type edge struct{ from, to s2.Point }
+
+	var (
+		edges []edge
+		count = make(map[edge]int)
+	)
+	for _, ring := range rings {
+		for i, from := range ring {
+			e := edge{from, ring[(i+1)%len(ring)]}
+			if reverse := (edge{e.to, e.from}); count[reverse] > 0 {
+				count[reverse]--
+				continue
+			}
+			count[e]++
+			edges = append(edges, e)
+		}
+	}
+
+	next := make(map[s2.Point][]s2.Point)
+	for _, e := range edges {
+		if count[e] > 0 {
+			count[e]--
+			next[e.from] = append(next[e.from], e.to)
+		}
+	}
+
+	// Each vertex has as many remaining edges in as out, so a path following
+	// them can only end where it started. A loop is cut off whenever the path
+	// reaches one of its vertices again, which keeps vertices from repeating
+	// within a loop where borders touch at a single point.
+	var loops []*s2.Loop
+	for _, e := range edges {
+		for len(next[e.from]) > 0 {
+			path := []s2.Point{e.from}
+			index := map[s2.Point]int{e.from: 0}
+			for v := e.from; ; {
+				out := next[v]
+				w := out[len(out)-1]
+				next[v] = out[:len(out)-1]
+
+				i, ok := index[w]
+				if !ok {
+					index[w] = len(path)
+					path = append(path, w)
+					v = w
+					continue
+				}
+
+				if len(path)-i >= 3 {
+					loops = append(loops, s2.LoopFromPoints(append([]s2.Point(nil), path[i:]...)))
+				}
+				for _, p := range path[i+1:] {
+					delete(index, p)
+				}
+				path = path[:i+1]
+				if i == 0 {
+					break
+				}
+				v = w
+			}
+		}
+	}
+
+	return s2.PolygonFromOrientedLoops(loops)
+}
+
+// orientedRings returns the vertices of the loops of p with the interior of p
+// on the left, so those of holes are reversed, like the edges of p.
+func orientedRings(p *s2.Polygon) [][]s2.Point {
+	rings := make([][]s2.Point, p.NumLoops())
+	for i, l := range p.Loops() {
+		rings[i] = make([]s2.Point, l.NumVertices())
+		for j := range rings[i] {
+			rings[i][j] = l.OrientedVertex(j)
+		}
+	}
+	return rings
+}
+
+// commonLocation returns a Location with only the fields that are equal in a
+// and b.
+func commonLocation(a, b Location) Location {
+	common := func(x, y string) string {
+		if x == y {
+			return x
+		}
+		return ""
+	}
+
+	l := Location{
+		Country:            common(a.Country, b.Country),
+		CountryLong:        common(a.CountryLong, b.CountryLong),
+		CountryCode2:       common(a.CountryCode2, b.CountryCode2),
+		CountryCode3:       common(a.CountryCode3, b.CountryCode3),
+		CountryCodeNumeric: common(a.CountryCodeNumeric, b.CountryCodeNumeric),
+		Sovereignty:        common(a.Sovereignty, b.Sovereignty),
+		Disputed:           a.Disputed && b.Disputed,
+		Continent:          common(a.Continent, b.Continent),
+		Region:             common(a.Region, b.Region),
+		SubRegion:          common(a.SubRegion, b.SubRegion),
+		Province:           common(a.Province, b.Province),
+		ProvinceCode:       common(a.ProvinceCode, b.ProvinceCode),
+		City:               common(a.City, b.City),
+		Source:             common(a.Source, b.Source),
+	}
+	if a.Population == b.Population {
+		l.Population = a.Population
+	}
+	if a.CityPopulation == b.CityPopulation {
+		l.CityPopulation = a.CityPopulation
+	}
+
+	return l
+}
@@ -0,0 +1,368 @@
+package rgeo
+
+import (
+	"math"
+	"testing"
+
+	"github.com/go-test/deep"
+	"github.com/golang/geo/s2"
+)
+
+// lookupTestData has a country in two parts with a city, and a country with
+// only provinces.
+const lookupTestData = `{"type":"FeatureCollection","features":[
+	{"type":"Feature","properties":{"ADMIN":"Alpha","ISO_A3_EH":"AAA"},
+	 "geometry":{"type":"Polygon",
+	  "coordinates":[[[0,0],[2,0],[2,2],[0,2],[0,0]]]}},
+	{"type":"Feature","properties":{"ADMIN":"Alpha","ISO_A3_EH":"AAA"},
+	 "geometry":{"type":"Polygon",
+	  "coordinates":[[[3,0],[4,0],[4,1],[3,1],[3,0]]]}},
+	{"type":"Feature","properties":{"name_conve":"Alpha City"},
+	 "geometry":{"type":"Polygon",
+	  "coordinates":[[[0.5,0.5],[1,0.5],[1,1],[0.5,1],[0.5,0.5]]]}},
+	{"type":"Feature","properties":{"ADMIN":"Beta","ISO_A3_EH":"BBB",
+	  "name":"North","iso_3166_2":"BB-N"},
+	 "geometry":{"type":"Polygon",
+	  "coordinates":[[[10,1],[12,1],[12,2],[10,2],[10,1]]]}},
+	{"type":"Feature","properties":{"ADMIN":"Beta","ISO_A3_EH":"BBB",
+	  "name":"South","iso_3166_2":"BB-S"},
+	 "geometry":{"type":"Polygon",
+	  "coordinates":[[[10,0],[12,0],[12,1],[10,1],[10,0]]]}}]}`
+
+func TestLookup(t *testing.T) {
+	r, err := New(testDataset(t, lookupTestData))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		method   func(string) (Feature, error)
+		in       string
+		err      error
+		loops    int
+		expected Location
+	}{
+		{
+			name:     "Country in two parts",
+			method:   r.LookupByName,
+			in:       "alpha",
+			loops:    2,
+			expected: Location{Country: "Alpha", CountryCode3: "AAA"},
+		},
+		{
+			name:     "Code",
+			method:   r.LookupByCode3,
+			in:       "AAA",
+			loops:    2,
+			expected: Location{Country: "Alpha", CountryCode3: "AAA"},
+		},
+		{
+			name:     "City",
+			method:   r.LookupByName,
+			in:       "Alpha City",
+			loops:    1,
+			expected: Location{City: "Alpha City"},
+		},
+		{
+			name:   "Provinces",
+			method: r.LookupByCode3,
+			in:     "bbb",
+			loops:  1,
+			expected: Location{
+				Country:      "Beta",
+				CountryCode3: "BBB",
+			},
+		},
+		{
+			name:   "Province",
+			method: r.LookupByName,
+			in:     "North",
+			loops:  1,
+			expected: Location{
+				Country:      "Beta",
+				CountryCode3: "BBB",
+				Province:     "North",
+				ProvinceCode: "BB-N",
+			},
+		},
+		{
+			name:   "Unknown",
+			method: r.LookupByName,
+			in:     "Gamma",
+			err:    ErrLocationNotFound,
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			result, err := test.method(test.in)
+			if err != test.err {
+				t.Errorf("expected error: %s\n got: %s\n", test.err, err)
+			}
+			if err != nil {
+				return
+			}
+			if diff := deep.Equal(test.expected, result.Location); diff != nil {
+				t.Error(diff)
+			}
+			if n := result.Polygon.NumLoops(); n != test.loops {
+				t.Errorf("expected %d loops, got %d", test.loops, n)
+			}
+			if err := result.Polygon.Validate(); err != nil {
+				t.Errorf("expected a valid polygon, got: %s", err)
+			}
+		})
+	}
+
+	// Merging must not change the indexed polygons
+	f, err := r.LookupByCode3("AAA")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !f.Polygon.ContainsPoint(s2.PointFromLatLng(s2.LatLngFromDegrees(0.5, 3.5))) {
+		t.Error("expected merged polygon to contain both parts")
+	}
+	loc, err := r.ReverseGeocode([]float64{3.5, 0.5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := deep.Equal(Location{Country: "Alpha", CountryCode3: "AAA"}, loc); diff != nil {
+		t.Error(diff)
+	}
+
+	// The provinces are joined along their shared border
+	f, err = r.LookupByCode3("BBB")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, ll := range [][2]float64{{0.5, 11}, {1.5, 11}, {1, 11}} {
+		if !f.Polygon.ContainsPoint(s2.PointFromLatLng(s2.LatLngFromDegrees(ll[0], ll[1]))) {
+			t.Errorf("expected merged polygon to contain %v", ll)
+		}
+	}
+
+	// Overlapping polygons can't be merged
+	r, err = New(testDataset(t, `{"type":"FeatureCollection","features":[
+		{"type":"Feature","properties":{"ADMIN":"Delta"},
+		 "geometry":{"type":"Polygon",
+		  "coordinates":[[[0,0],[2,0],[2,2],[0,2],[0,0]]]}},
+		{"type":"Feature","properties":{"ADMIN":"Delta"},
+		 "geometry":{"type":"Polygon",
+		  "coordinates":[[[1,0],[3,0],[3,2],[1,2],[1,0]]]}}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.LookupByName("Delta"); err == nil {
+		t.Error("expected an error for overlapping polygons")
+	}
+}
+
+func TestLookupProvinceCode(t *testing.T) {
+	r, err := New(testDataset(t, lookupTestData))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	loc, err := r.LookupProvinceCode("bb-s")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := Location{
+		Country:      "Beta",
+		CountryCode3: "BBB",
+		Province:     "South",
+		ProvinceCode: "BB-S",
+	}
+	if diff := deep.Equal(expected, loc); diff != nil {
+		t.Error(diff)
+	}
+
+	for _, code := range []string{"BB-X", ""} {
+		if _, err := r.LookupProvinceCode(code); err != ErrLocationNotFound {
+			t.Errorf("%q: expected error: %s\n got: %v\n", code, ErrLocationNotFound, err)
+		}
+	}
+}
+
+func TestLocations(t *testing.T) {
+	r, err := New(testDataset(t, lookupTestData))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []Location{
+		{Country: "Alpha", CountryCode3: "AAA"},
+		{City: "Alpha City"},
+		{Country: "Beta", CountryCode3: "BBB", Province: "North", ProvinceCode: "BB-N"},
+		{Country: "Beta", CountryCode3: "BBB", Province: "South", ProvinceCode: "BB-S"},
+	}
+	if diff := deep.Equal(expected, r.Locations()); diff != nil {
+		t.Error(diff)
+	}
+}
+
+func TestDistinctCountries(t *testing.T) {
+	// Aardvark's EEZ has its own code, which is ignored, and Beta's continent
+	// is only set on one province
+	r, err := New(testDataset(t, lookupTestData[:len(lookupTestData)-2]+`,
+		{"type":"Feature","properties":{"ADMIN":"Beta","ISO_A3_EH":"BBB",
+		  "CONTINENT":"Europe","name":"East","iso_3166_2":"BB-E"},
+		 "geometry":{"type":"Polygon",
+		  "coordinates":[[[12,0],[13,0],[13,2],[12,2],[12,0]]]}},
+		{"type":"Feature","properties":{"ADMIN":"Aardvark","ISO_A3_EH":"ZZZ"},
+		 "geometry":{"type":"Polygon",
+		  "coordinates":[[[20,0],[21,0],[21,1],[20,1],[20,0]]]}},
+		{"type":"Feature","properties":{"ISO_A3_EH":"ZZE","SOVEREIGN1":"Aardvark"},
+		 "geometry":{"type":"Polygon",
+		  "coordinates":[[[21,0],[22,0],[22,1],[21,1],[21,0]]]}}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []Location{
+		{Country: "Aardvark", CountryCode3: "ZZZ"},
+		{Country: "Alpha", CountryCode3: "AAA"},
+		{Country: "Beta", CountryCode3: "BBB", Continent: "Europe"},
+	}
+	if diff := deep.Equal(expected, r.DistinctCountries()); diff != nil {
+		t.Error(diff)
+	}
+}
+
+func TestBoundingBox(t *testing.T) {
+	// Gamma is split at the antimeridian
+	r, err := New(testDataset(t, lookupTestData[:len(lookupTestData)-2]+`,
+		{"type":"Feature","properties":{"ADMIN":"Gamma","ISO_A3_EH":"CCC"},
+		 "geometry":{"type":"MultiPolygon","coordinates":[
+		  [[[170,-20],[180,-20],[180,-10],[170,-10],[170,-20]]],
+		  [[[-180,-20],[-175,-20],[-175,-15],[-180,-15],[-180,-20]]]]}}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		in       string
+		err      error
+		expected [4]float64
+	}{
+		{name: "Country in two parts", in: "aaa", expected: [4]float64{0, 0, 4, 2}},
+		{name: "Provinces", in: "BBB", expected: [4]float64{10, 0, 12, 2}},
+		{name: "Antimeridian", in: "CCC", expected: [4]float64{170, -20, -175, -10}},
+		{name: "Unknown", in: "DDD", err: ErrLocationNotFound},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			minLon, minLat, maxLon, maxLat, err := r.BoundingBox(test.in)
+			if err != test.err {
+				t.Errorf("expected error: %s\n got: %s\n", test.err, err)
+			}
+
+			result := [4]float64{minLon, minLat, maxLon, maxLat}
+			for i := range result {
+				// Edges are geodesics, so they bulge towards the poles
+				if math.Abs(result[i]-test.expected[i]) > 0.1 {
+					t.Errorf("expected %v, got %v", test.expected, result)
+					break
+				}
+			}
+		})
+	}
+}
+
+func TestComplexity(t *testing.T) {
+	// Gamma has a hole
+	r, err := New(testDataset(t, lookupTestData[:len(lookupTestData)-2]+`,
+		{"type":"Feature","properties":{"ADMIN":"Gamma","ISO_A3_EH":"CCC"},
+		 "geometry":{"type":"Polygon","coordinates":[
+		  [[20,0],[25,0],[25,5],[20,5],[20,0]],
+		  [[21,1],[22,1],[21.5,2],[21,1]]]}}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		in       string
+		err      error
+		expected [2]int
+	}{
+		{name: "Country in two parts", in: "aaa", expected: [2]int{2, 8}},
+		{name: "Provinces", in: "BBB", expected: [2]int{2, 8}},
+		{name: "Hole", in: "CCC", expected: [2]int{2, 7}},
+		{name: "Unknown", in: "DDD", err: ErrLocationNotFound},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			loops, vertices, err := r.Complexity(test.in)
+			if err != test.err {
+				t.Errorf("expected error: %s\n got: %s\n", test.err, err)
+			}
+			if diff := deep.Equal(test.expected, [2]int{loops, vertices}); diff != nil {
+				t.Error(diff)
+			}
+		})
+	}
+}
+
+func TestNeighbors(t *testing.T) {
+	// Delta borders the second part of Alpha, Epsilon only touches a corner of
+	// its first part, Zeta borders Delta without sharing a vertex, and Alpha's
+	// EEZ isn't a country
+	r, err := New(testDataset(t, lookupTestData[:len(lookupTestData)-2]+`,
+		{"type":"Feature","properties":{"ADMIN":"Delta","ISO_A3_EH":"DDD"},
+		 "geometry":{"type":"Polygon",
+		  "coordinates":[[[4,0],[5,0],[5,1],[4,1],[4,0]]]}},
+		{"type":"Feature","properties":{"ADMIN":"Zeta","ISO_A3_EH":"ZZZ"},
+		 "geometry":{"type":"Polygon",
+		  "coordinates":[[[5,0.2],[6,0.2],[6,0.8],[5,0.8],[5,0.2]]]}},
+		{"type":"Feature","properties":{"ADMIN":"Epsilon","ISO_A3_EH":"EEE"},
+		 "geometry":{"type":"Polygon",
+		  "coordinates":[[[2,2],[3,2],[3,3],[2,3],[2,2]]]}},
+		{"type":"Feature","properties":{"ISO_A3_EH":"AAA","SOVEREIGN1":"Alpha"},
+		 "geometry":{"type":"Polygon",
+		  "coordinates":[[[-1,0],[0,0],[0,2],[-1,2],[-1,0]]]}}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		in       string
+		err      error
+		expected []Location
+	}{
+		{name: "Country in two parts", in: "aaa", expected: []Location{
+			{Country: "Delta", CountryCode3: "DDD"},
+			{Country: "Epsilon", CountryCode3: "EEE"},
+		}},
+		{name: "Provinces", in: "BBB", expected: nil},
+		{name: "Border", in: "DDD", expected: []Location{
+			{Country: "Alpha", CountryCode3: "AAA"},
+			{Country: "Zeta", CountryCode3: "ZZZ"},
+		}},
+		{name: "Border without shared vertices", in: "ZZZ", expected: []Location{
+			{Country: "Delta", CountryCode3: "DDD"},
+		}},
+		{name: "Unknown", in: "FFF", err: ErrLocationNotFound},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			result, err := r.Neighbors(test.in)
+			if err != test.err {
+				t.Errorf("expected error: %s\n got: %s\n", test.err, err)
+			}
+			if diff := deep.Equal(test.expected, result); diff != nil {
+				t.Error(diff)
+			}
+		})
+	}
+}
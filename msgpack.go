@@ -0,0 +1,498 @@
+package rgeo
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"slices"
+
+	"github.com/golang/geo/s2"
+)
+
+// The features are written as a MessagePack array of maps with the keys
+// "location" and "polygon". Locations are maps keyed by the JSON names of
+// their fields, and polygons are the s2 encoding as binary. Only the subset of
+// MessagePack needed for this is implemented, to avoid a dependency.
+
+// EncodeMsgpack writes the features as MessagePack, for exchanging them with
+// other tools, e.g. to store them in MongoDB. Empty Location fields are
+// omitted, like in JSON. Encode is more compact and remains the format of the
+// included datasets.
+func (fc *FeatureCollection) EncodeMsgpack(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	mw := msgpackWriter{w: bw}
+
+	if mw.arrayHeader(len(*fc)); mw.err != nil {
+		return fmt.Errorf("write header: %w", mw.err)
+	}
+	for i, f := range *fc {
+		polyBuf := bytes.NewBuffer(nil)
+		if err := f.Polygon.Encode(polyBuf); err != nil {
+			return fmt.Errorf("encode polygon of feature %d: %w", i, err)
+		}
+
+		mw.mapHeader(2)
+		mw.string("location")
+		mw.location(f.Location)
+		mw.string("polygon")
+		mw.bin(polyBuf.Bytes())
+		if mw.err != nil {
+			return fmt.Errorf("write feature %d: %w", i, mw.err)
+		}
+	}
+
+	return bw.Flush()
+}
+
+// DecodeMsgpack decodes features written by EncodeMsgpack into fc. Unknown
+// keys are ignored whatever the type of their values, as are nil values. A
+// population can also be a float if it is an integer, as some encoders write
+// all numbers as floats.
+func (fc *FeatureCollection) DecodeMsgpack(r io.Reader) error {
+	mr := msgpackReader{r: bufio.NewReader(r)}
+
+	n, err := mr.arrayHeader()
+	if err != nil {
+		return fmt.Errorf("read header: %w", err)
+	}
+
+	features := make(FeatureCollection, 0, min(n, 1024))
+	for i := uint32(0); i < n; i++ {
+		f, err := mr.feature()
+		if err != nil {
+			return fmt.Errorf("decode feature %d of %d: %w", i, n, unexpectedEOF(err))
+		}
+		features = append(features, f)
+	}
+
+	*fc = features
+	return nil
+}
+
+// msgpackField is the key of a string field of a Location and a pointer to
+// the field.
+type msgpackField struct {
+	key   string
+	value *string
+}
+
+// msgpackLocationFields returns the keys and pointers to the string fields of
+// l, Disputed and the populations are handled separately.
+func msgpackLocationFields(l *Location) []msgpackField {
+	return []msgpackField{
+		{"country", &l.Country},
+		{"country_long", &l.CountryLong},
+		{"country_code_2", &l.CountryCode2},
+		{"country_code_3", &l.CountryCode3},
+		{"country_code_numeric", &l.CountryCodeNumeric},
+		{"sovereignty", &l.Sovereignty},
+		{"continent", &l.Continent},
+		{"region", &l.Region},
+		{"subregion", &l.SubRegion},
+		{"province", &l.Province},
+		{"province_code", &l.ProvinceCode},
+		{"city", &l.City},
+		{"source", &l.Source},
+	}
+}
+
+// msgpackWriter writes MessagePack values to w, keeping the first error.
+type msgpackWriter struct {
+	w   *bufio.Writer
+	err error
+}
+
+func (m *msgpackWriter) write(b ...byte) {
+	if m.err == nil {
+		_, m.err = m.w.Write(b)
+	}
+}
+
+// header writes the header of a value with a length, using the fixed format
+// with fix as prefix for lengths up to fixMax, or one of the formats with an
+// 8 (if f8 isn't zero), 16 or 32 bit length.
+func (m *msgpackWriter) header(n int, fix byte, fixMax int, f8, f16, f32 byte) {
+	switch {
+	case n <= fixMax:
+		m.write(fix | byte(n))
+	case f8 != 0 && n <= math.MaxUint8:
+		m.write(f8, byte(n))
+	case n <= math.MaxUint16:
+		m.write(f16)
+		m.write(binary.BigEndian.AppendUint16(nil, uint16(n))...)
+	default:
+		m.write(f32)
+		m.write(binary.BigEndian.AppendUint32(nil, uint32(n))...)
+	}
+}
+
+func (m *msgpackWriter) arrayHeader(n int) { m.header(n, 0x90, 15, 0, 0xdc, 0xdd) }
+func (m *msgpackWriter) mapHeader(n int)   { m.header(n, 0x80, 15, 0, 0xde, 0xdf) }
+
+func (m *msgpackWriter) string(s string) {
+	m.header(len(s), 0xa0, 31, 0xd9, 0xda, 0xdb)
+	m.write([]byte(s)...)
+}
+
+func (m *msgpackWriter) bin(b []byte) {
+	// bin has no fixed format
+	m.header(len(b), 0, -1, 0xc4, 0xc5, 0xc6)
+	m.write(b...)
+}
+
+func (m *msgpackWriter) int(v int64) {
+	if v >= 0 && v <= 0x7f {
+		m.write(byte(v))
+		return
+	}
+	m.write(0xd3)
+	m.write(binary.BigEndian.AppendUint64(nil, uint64(v))...)
+}
+
+func (m *msgpackWriter) location(l Location) {
+	fields := msgpackLocationFields(&l)
+
+	n := 0
+	for _, f := range fields {
+		if *f.value != "" {
+			n++
+		}
+	}
+	if l.Disputed {
+		n++
+	}
+	if l.Population != 0 {
+		n++
+	}
+	if l.CityPopulation != 0 {
+		n++
+	}
+
+	m.mapHeader(n)
+	for _, f := range fields {
+		if *f.value != "" {
+			m.string(f.key)
+			m.string(*f.value)
+		}
+	}
+	if l.Disputed {
+		m.string("disputed")
+		m.write(0xc3)
+	}
+	if l.Population != 0 {
+		m.string("population")
+		m.int(l.Population)
+	}
+	if l.CityPopulation != 0 {
+		m.string("city_population")
+		m.int(l.CityPopulation)
+	}
+}
+
+// msgpackReader reads MessagePack values from r.
+type msgpackReader struct {
+	r *bufio.Reader
+}
+
+// errMsgpackType is returned for values of unexpected types.
+var errMsgpackType = errors.New("unexpected type")
+
+// value reads the next value, which has to be nil, a bool, a string, binary
+// data, an integer or a float. Strings and binary data are returned as []byte,
+// integers as int64 and floats as float64.
+func (m *msgpackReader) value() (any, error) {
+	b, err := m.r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case b <= 0x7f:
+		return int64(b), nil
+	case b >= 0xe0:
+		return int64(int8(b)), nil
+	case b&0xe0 == 0xa0:
+		return m.bytes(uint32(b & 0x1f))
+	}
+
+	var size int
+	switch b {
+	case 0xc0:
+		return nil, nil
+	case 0xc2, 0xc3:
+		return b == 0xc3, nil
+	case 0xd9, 0xc4, 0xcc, 0xd0:
+		size = 1
+	case 0xda, 0xc5, 0xcd, 0xd1:
+		size = 2
+	case 0xdb, 0xc6, 0xce, 0xd2, 0xca:
+		size = 4
+	case 0xcf, 0xd3, 0xcb:
+		size = 8
+	default:
+		return nil, fmt.Errorf("%w 0x%02x", errMsgpackType, b)
+	}
+
+	buf := make([]byte, 8)
+	if _, err := io.ReadFull(m.r, buf[8-size:]); err != nil {
+		return nil, err
+	}
+	u := binary.BigEndian.Uint64(buf)
+
+	switch b {
+	case 0xd9, 0xda, 0xdb, 0xc4, 0xc5, 0xc6:
+		return m.bytes(uint32(u))
+	case 0xcc, 0xcd, 0xce:
+		return int64(u), nil
+	case 0xcf:
+		if u > math.MaxInt64 {
+			return nil, fmt.Errorf("integer %d out of range", u)
+		}
+		return int64(u), nil
+	case 0xca:
+		return float64(math.Float32frombits(uint32(u))), nil
+	case 0xcb:
+		return math.Float64frombits(u), nil
+	default:
+		// Sign extend the smaller signed integers
+		shift := 64 - 8*size
+		return int64(u<<shift) >> shift, nil
+	}
+}
+
+// skip reads and discards the next value, which can be of any type. The
+// elements of arrays and maps are counted instead of being read recursively,
+// so deeply nested values can't exhaust the stack.
+func (m *msgpackReader) skip() error {
+	for n := uint64(1); n > 0; n-- {
+		b, err := m.r.ReadByte()
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case b <= 0x7f, b >= 0xe0, b == 0xc0, b == 0xc2, b == 0xc3:
+			continue
+		case b&0xe0 == 0xa0:
+			if _, err := m.r.Discard(int(b & 0x1f)); err != nil {
+				return err
+			}
+			continue
+		case b&0xf0 == 0x90:
+			n += uint64(b & 0x0f)
+			continue
+		case b&0xf0 == 0x80:
+			n += 2 * uint64(b&0x0f)
+			continue
+		}
+
+		// The size of the length, if any, and of the data following it, which
+		// includes the type of ext values
+		var lenSize, size int
+		switch b {
+		case 0xcc, 0xd0:
+			size = 1
+		case 0xcd, 0xd1, 0xd4:
+			size = 2
+		case 0xd5:
+			size = 3
+		case 0xca, 0xce, 0xd2:
+			size = 4
+		case 0xd6:
+			size = 5
+		case 0xcb, 0xcf, 0xd3:
+			size = 8
+		case 0xd7:
+			size = 9
+		case 0xd8:
+			size = 17
+		case 0xc4, 0xd9:
+			lenSize = 1
+		case 0xc5, 0xda, 0xdc, 0xde:
+			lenSize = 2
+		case 0xc6, 0xdb, 0xdd, 0xdf:
+			lenSize = 4
+		case 0xc7:
+			lenSize, size = 1, 1
+		case 0xc8:
+			lenSize, size = 2, 1
+		case 0xc9:
+			lenSize, size = 4, 1
+		default:
+			return fmt.Errorf("%w 0x%02x", errMsgpackType, b)
+		}
+
+		buf := make([]byte, 8)
+		if _, err := io.ReadFull(m.r, buf[8-lenSize:]); err != nil {
+			return err
+		}
+		length := binary.BigEndian.Uint64(buf)
+
+		switch b {
+		case 0xdc, 0xdd:
+			n += length
+		case 0xde, 0xdf:
+			n += 2 * length
+		default:
+			if _, err := io.CopyN(io.Discard, m.r, int64(length)+int64(size)); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// bytes reads n bytes, which must not be more than MaxEncodedLength.
+func (m *msgpackReader) bytes(n uint32) ([]byte, error) {
+	if n > MaxEncodedLength {
+		return nil, fmt.Errorf("length %d exceeds maximum of %d", n, MaxEncodedLength)
+	}
+	return readLength(m.r, n)
+}
+
+// header reads the length of an array or map with the given formats.
+func (m *msgpackReader) header(what string, fix byte, f16, f32 byte) (uint32, error) {
+	b, err := m.r.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+
+	switch {
+	case b&0xf0 == fix:
+		return uint32(b & 0x0f), nil
+	case b == f16:
+		var n uint16
+		err := binary.Read(m.r, binary.BigEndian, &n)
+		return uint32(n), err
+	case b == f32:
+		var n uint32
+		err := binary.Read(m.r, binary.BigEndian, &n)
+		return n, err
+	default:
+		return 0, fmt.Errorf("%w 0x%02x, expected %s", errMsgpackType, b, what)
+	}
+}
+
+func (m *msgpackReader) arrayHeader() (uint32, error) { return m.header("array", 0x90, 0xdc, 0xdd) }
+func (m *msgpackReader) mapHeader() (uint32, error)   { return m.header("map", 0x80, 0xde, 0xdf) }
+
+// key reads a map key, which has to be a string.
+func (m *msgpackReader) key() (string, error) {
+	v, err := m.value()
+	if err != nil {
+		return "", err
+	}
+	k, ok := v.([]byte)
+	if !ok {
+		return "", fmt.Errorf("%w %T, expected string key", errMsgpackType, v)
+	}
+	return string(k), nil
+}
+
+func (m *msgpackReader) feature() (Feature, error) {
+	var f Feature
+
+	n, err := m.mapHeader()
+	if err != nil {
+		return Feature{}, err
+	}
+	for i := uint32(0); i < n; i++ {
+		k, err := m.key()
+		if err != nil {
+			return Feature{}, err
+		}
+
+		switch k {
+		case "location":
+			if f.Location, err = m.location(); err != nil {
+				return Feature{}, fmt.Errorf("decode location: %w", err)
+			}
+		case "polygon":
+			v, err := m.value()
+			if err != nil {
+				return Feature{}, fmt.Errorf("read polygon: %w", err)
+			}
+			b, ok := v.([]byte)
+			if !ok {
+				return Feature{}, fmt.Errorf("read polygon: %w %T", errMsgpackType, v)
+			}
+			f.Polygon = &s2.Polygon{}
+			if err := decodePolygon(f.Polygon, b); err != nil {
+				return Feature{}, fmt.Errorf("bad polygon in geometry: %w", unexpectedEOF(err))
+			}
+		default:
+			if err := m.skip(); err != nil {
+				return Feature{}, fmt.Errorf("read %q: %w", k, err)
+			}
+		}
+	}
+
+	if f.Polygon == nil {
+		return Feature{}, errors.New("no polygon")
+	}
+
+	return f, nil
+}
+
+func (m *msgpackReader) location() (Location, error) {
+	var l Location
+	fields := msgpackLocationFields(&l)
+
+	n, err := m.mapHeader()
+	if err != nil {
+		return Location{}, err
+	}
+	for i := uint32(0); i < n; i++ {
+		k, err := m.key()
+		if err != nil {
+			return Location{}, err
+		}
+		// Unknown keys can have values of any type
+		if !slices.ContainsFunc(fields, func(f msgpackField) bool { return f.key == k }) &&
+			k != "disputed" && k != "population" && k != "city_population" {
+			if err := m.skip(); err != nil {
+				return Location{}, fmt.Errorf("read %q: %w", k, err)
+			}
+			continue
+		}
+		v, err := m.value()
+		if err != nil {
+			return Location{}, fmt.Errorf("read %q: %w", k, err)
+		}
+		// Some encoders write all numbers as floats. NaN isn't an integer
+		// either, and the conversion is exact in range.
+		if f, ok := v.(float64); ok && (k == "population" || k == "city_population") {
+			if f != math.Trunc(f) || f < math.MinInt64 || f >= math.MaxInt64 {
+				return Location{}, fmt.Errorf("read %q: %g is not an integer", k, f)
+			}
+			v = int64(f)
+		}
+
+		switch v := v.(type) {
+		case []byte:
+			for _, f := range fields {
+				if f.key == k {
+					*f.value = string(v)
+				}
+			}
+		case bool:
+			if k == "disputed" {
+				l.Disputed = v
+			}
+		case int64:
+			switch k {
+			case "population":
+				l.Population = v
+			case "city_population":
+				l.CityPopulation = v
+			}
+		}
+	}
+
+	return l, nil
+}
@@ -0,0 +1,160 @@
+package rgeo
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"testing"
+
+	"github.com/go-test/deep"
+)
+
+func TestEncodeMsgpack(t *testing.T) {
+	fc := testFeatures(t)
+	fc[0].Location.Population = 1 << 40
+	fc[0].Location.CountryCodeNumeric = "840"
+	fc[1].Location.Population = -5
+	fc[1].Location.CityPopulation = 300
+	fc[1].Location.Disputed = true
+
+	buf := bytes.NewBuffer(nil)
+	if err := fc.EncodeMsgpack(buf); err != nil {
+		t.Fatal(err)
+	}
+
+	var result FeatureCollection
+	if err := result.DecodeMsgpack(bytes.NewReader(buf.Bytes())); err != nil {
+		t.Fatal(err)
+	}
+	compareFeatures(t, fc, result)
+
+	// Truncated data
+	data := buf.Bytes()[:buf.Len()-10]
+	if err := result.DecodeMsgpack(bytes.NewReader(data)); err == nil {
+		t.Error("expected error for truncated data")
+	}
+}
+
+func TestDecodeMsgpack(t *testing.T) {
+	polyBuf := bytes.NewBuffer(nil)
+	if err := testFeatures(t)[0].Polygon.Encode(polyBuf); err != nil {
+		t.Fatal(err)
+	}
+
+	// As written by other encoders, with a 16 bit integer, a nil value, an
+	// unknown key and the polygon before the location
+	data := []byte{0x91, 0x83}
+	data = append(data, 0xa7)
+	data = append(data, "polygon"...)
+	data = append(data, 0xc5, byte(polyBuf.Len()>>8), byte(polyBuf.Len()))
+	data = append(data, polyBuf.Bytes()...)
+	data = append(data, 0xa2)
+	data = append(data, "id"...)
+	data = append(data, 0x2a)
+	data = append(data, 0xa8)
+	data = append(data, "location"...)
+	data = append(data, 0x83, 0xa4)
+	data = append(data, "city"...)
+	data = append(data, 0xa6)
+	data = append(data, "London"...)
+	data = append(data, 0xaa)
+	data = append(data, "population"...)
+	data = append(data, 0xcd, 0x12, 0x34, 0xa8)
+	data = append(data, "province"...)
+	data = append(data, 0xc0)
+
+	var result FeatureCollection
+	if err := result.DecodeMsgpack(bytes.NewReader(data)); err != nil {
+		t.Fatal(err)
+	}
+	if len(result) != 1 {
+		t.Fatalf("expected 1 feature, got %d", len(result))
+	}
+	if diff := deep.Equal(Location{City: "London", Population: 0x1234}, result[0].Location); diff != nil {
+		t.Error(diff)
+	}
+	if result[0].Polygon.NumEdges() != testFeatures(t)[0].Polygon.NumEdges() {
+		t.Error("expected the polygon to be decoded")
+	}
+
+	// A map instead of the array of features
+	if err := result.DecodeMsgpack(bytes.NewReader([]byte{0x80})); err == nil {
+		t.Error("expected error for wrong type")
+	}
+}
+
+func TestDecodeMsgpack_OtherTypes(t *testing.T) {
+	polyBuf := bytes.NewBuffer(nil)
+	if err := testFeatures(t)[0].Polygon.Encode(polyBuf); err != nil {
+		t.Fatal(err)
+	}
+
+	str := func(s string) []byte { return append([]byte{0xa0 | byte(len(s))}, s...) }
+	float64Of := func(f float64) []byte {
+		return binary.BigEndian.AppendUint64([]byte{0xcb}, math.Float64bits(f))
+	}
+	float32Of := func(f float32) []byte {
+		return binary.BigEndian.AppendUint32([]byte{0xca}, math.Float32bits(f))
+	}
+
+	// As written by msgpack for Python, which writes floats with 64 bits, with
+	// a timestamp extension, nested arrays and maps under unknown keys, and a
+	// float32 as written by Go encoders for float32 fields
+	location := func(population []byte) []byte {
+		data := []byte{0x86}
+		data = append(data, str("country")...)
+		data = append(data, str("Test")...)
+		data = append(data, str("population")...)
+		data = append(data, population...)
+		data = append(data, str("area")...)
+		data = append(data, float32Of(2.5)...)
+		data = append(data, str("names")...)
+		data = append(data, 0x82)
+		data = append(data, str("de")...)
+		data = append(data, str("Prüfung")...)
+		data = append(data, str("alt")...)
+		data = append(data, 0x92)
+		data = append(data, str("a")...)
+		data = append(data, 0xdc, 0x00, 0x02, 0xc3, 0xc0)
+		data = append(data, str("updated")...)
+		data = append(data, 0xd6, 0xff, 0x65, 0x53, 0xf1, 0x00)
+		data = append(data, str("blob")...)
+		data = append(data, 0xc7, 0x03, 0x01, 0x01, 0x02, 0x03)
+		return data
+	}
+	feature := func(population []byte) []byte {
+		data := []byte{0x91, 0x83}
+		data = append(data, str("bbox")...)
+		data = append(data, 0x94)
+		for _, f := range []float64{0, -1.5, 1, 2} {
+			data = append(data, float64Of(f)...)
+		}
+		data = append(data, str("location")...)
+		data = append(data, location(population)...)
+		data = append(data, str("polygon")...)
+		data = append(data, 0xc5, byte(polyBuf.Len()>>8), byte(polyBuf.Len()))
+		return append(data, polyBuf.Bytes()...)
+	}
+
+	var result FeatureCollection
+	if err := result.DecodeMsgpack(bytes.NewReader(feature(float64Of(1000)))); err != nil {
+		t.Fatal(err)
+	}
+	if len(result) != 1 {
+		t.Fatalf("expected 1 feature, got %d", len(result))
+	}
+	if diff := deep.Equal(Location{Country: "Test", Population: 1000}, result[0].Location); diff != nil {
+		t.Error(diff)
+	}
+
+	expected := `decode feature 0 of 1: decode location: read "population": 1000.5 is not an integer`
+	if err := result.DecodeMsgpack(bytes.NewReader(feature(float64Of(1000.5)))); err == nil || err.Error() != expected {
+		t.Errorf("expected error: %s\n got: %v\n", expected, err)
+	}
+
+	// Truncated inside the nested values
+	data := feature(float64Of(1000))
+	if err := result.DecodeMsgpack(bytes.NewReader(data[:40])); err == nil {
+		t.Error("expected error for truncated data")
+	}
+}
@@ -0,0 +1,243 @@
+package rgeo
+
+import (
+	"errors"
+	"math"
+	"sort"
+
+	"github.com/golang/geo/s2"
+	"github.com/twpayne/go-geom"
+)
+
+// overlapThreshold is the fraction of the smaller polygon's area from which
+// DetectOverlaps reports an overlap.
+const overlapThreshold = 0.1
+
+// OverlapWarning describes two features of a FeatureCollection whose polygons
+// overlap.
+type OverlapWarning struct {
+	// Indices of the features in the FeatureCollection, with A < B
+	A, B int
+
+	// Locations of the features
+	LocationA, LocationB Location
+
+	// Approximate overlapping area as a fraction of the smaller polygon
+	Fraction float64
+}
+
+// DetectOverlaps returns warnings for all pairs of features at the same
+// administrative level (country, province or city) whose polygons overlap by
+// at least a tenth of the smaller one. Such overlaps lead to ambiguous results
+// from ReverseGeocode, so this can be used to check a dataset before using it.
+//
+// The overlapping area is estimated from cell coverings of the polygons, so
+// polygons which merely share a border aren't reported. Only pairs whose
+// bounds are close, see overlapCandidates, are compared.
+func (fc FeatureCollection) DetectOverlaps() []OverlapWarning {
+	coverer := &s2.RegionCoverer{MaxLevel: 30, MaxCells: 256}
+	coverings := make([]s2.CellUnion, len(fc))
+	covering := func(i int) s2.CellUnion {
+		if coverings[i] == nil {
+			coverings[i] = coverer.InteriorCovering(fc[i].Polygon)
+		}
+		return coverings[i]
+	}
+	areas := make([]float64, len(fc))
+	for i, f := range fc {
+		areas[i] = f.Polygon.Area()
+	}
+
+	var warnings []OverlapWarning
+	for i, candidates := range overlapCandidates(fc) {
+		a := fc[i]
+		for _, j := range candidates {
+			b := fc[j]
+			if adminLevel(a.Location) != adminLevel(b.Location) ||
+				!a.Polygon.RectBound().Intersects(b.Polygon.RectBound()) ||
+				!a.Polygon.Intersects(b.Polygon) {
+				continue
+			}
+
+			smaller := math.Min(areas[i], areas[j])
+			if smaller == 0 {
+				continue
+			}
+
+			overlap := s2.CellUnionFromIntersection(covering(i), covering(j))
+			fraction := overlap.ExactArea() / smaller
+			if fraction >= overlapThreshold {
+				warnings = append(warnings, OverlapWarning{
+					A:         i,
+					B:         j,
+					LocationA: a.Location,
+					LocationB: b.Location,
+					Fraction:  fraction,
+				})
+			}
+		}
+	}
+
+	return warnings
+}
+
+// overlapCandidateCells is the number of cells covering the bound of each
+// feature in overlapCandidates.
+const overlapCandidateCells = 8
+
+// overlapCandidates returns, for each feature of fc, the sorted indices of the
+// later features whose polygons may intersect it, i.e. those for which a cell
+// of the covering of the bound of one contains or is contained in a cell of
+// the covering of the other. The cells are looked up by their ID and those of
+// their ancestors, rather than comparing every pair of features.
+func overlapCandidates(fc FeatureCollection) [][]int {
+	coverer := &s2.RegionCoverer{MaxLevel: 30, MaxCells: overlapCandidateCells}
+	coverings := make([]s2.CellUnion, len(fc))
+	var (
+		// features with a cell in their covering, and with one below it
+		at    = make(map[s2.CellID][]int)
+		below = make(map[s2.CellID][]int)
+	)
+	for i, f := range fc {
+		coverings[i] = coverer.Covering(f.Polygon.RectBound())
+		for _, c := range coverings[i] {
+			at[c] = append(at[c], i)
+			for level := c.Level() - 1; level >= 0; level-- {
+				below[c.Parent(level)] = append(below[c.Parent(level)], i)
+			}
+		}
+	}
+
+	candidates := make([][]int, len(fc))
+	for i, covering := range coverings {
+		seen := make(map[int]bool)
+		add := func(features []int) {
+			for _, j := range features {
+				if j > i && !seen[j] {
+					seen[j] = true
+					candidates[i] = append(candidates[i], j)
+				}
+			}
+		}
+		for _, c := range covering {
+			add(at[c])
+			add(below[c])
+			for level := c.Level() - 1; level >= 0; level-- {
+				add(at[c.Parent(level)])
+			}
+		}
+		sort.Ints(candidates[i])
+	}
+
+	return candidates
+}
+
+// intersectionDepth is the number of levels the boundary cells of the
+// covering are subdivided by intersectionArea.
+const intersectionDepth = 4
+
+// CountriesIntersecting returns the countries whose polygons intersect the
+// given polygon, e.g. a delivery zone, sorted by the area of the intersection,
+// largest first. The Locations have only the country fields, and each is
+// returned once, with the area of all of its polygons at the least specific
+// level, e.g. of its provinces if only Provinces10 is loaded.
+//
+// The areas are estimated from a cell covering of the polygon, like
+// DetectOverlaps, so a country that only touches it has an area of zero and
+// comes last. Polygons without a Country, such as those of Cities10, are
+// ignored. If no country intersects ErrLocationNotFound is returned.
+func (r *Rgeo) CountriesIntersecting(poly *geom.Polygon) ([]Location, error) {
+	if poly == nil {
+		return nil, errors.New("polygon is nil")
+	}
+
+	zone, err := PolygonFromGeometry(poly)
+	if err != nil {
+		return nil, err
+	} else if err := r.checkBuilt(); err != nil {
+		return nil, err
+	}
+
+	type country struct {
+		loc   Location
+		level int
+		area  float64
+	}
+	var (
+		countries []*country
+		byLoc     = make(map[Location]*country)
+		coverer   = &s2.RegionCoverer{MaxLevel: 30, MaxCells: 256}
+		covering  = coverer.Covering(zone)
+	)
+	for _, s := range r.shapes() {
+		p := s.Shape.(*s2.Polygon)
+		if s.loc.Country == "" || !p.RectBound().Intersects(zone.RectBound()) ||
+			!p.Intersects(zone) {
+			continue
+		}
+
+		loc, level := countryFields(s.loc), adminLevel(s.loc)
+		c, ok := byLoc[loc]
+		switch {
+		case !ok:
+			c = &country{loc: loc, level: level}
+			byLoc[loc] = c
+			countries = append(countries, c)
+		case level < c.level:
+			c.level, c.area = level, 0
+		case level > c.level:
+			continue
+		}
+
+		for _, cell := range covering {
+			c.area += intersectionArea(zone, p, s2.CellFromCellID(cell), intersectionDepth)
+		}
+	}
+	if len(countries) == 0 {
+		return nil, ErrLocationNotFound
+	}
+
+	sort.SliceStable(countries, func(i, j int) bool { return countries[i].area > countries[j].area })
+	locs := make([]Location, len(countries))
+	for i, c := range countries {
+		locs[i] = c.loc
+	}
+
+	return locs, nil
+}
+
+// intersectionArea estimates the area of the intersection of a and b within
+// the cell, by subdividing cells on the boundary of either polygon depth more
+// times, and then counting those whose center is in both.
+func intersectionArea(a, b *s2.Polygon, cell s2.Cell, depth int) float64 {
+	switch {
+	case !a.IntersectsCell(cell) || !b.IntersectsCell(cell):
+		return 0
+	case a.ContainsCell(cell) && b.ContainsCell(cell):
+		return cell.ExactArea()
+	case depth == 0 || cell.IsLeaf():
+		if a.ContainsPoint(cell.Center()) && b.ContainsPoint(cell.Center()) {
+			return cell.ExactArea()
+		}
+		return 0
+	}
+
+	var area float64
+	for _, child := range cell.ID().Children() {
+		area += intersectionArea(a, b, s2.CellFromCellID(child), depth-1)
+	}
+	return area
+}
+
+// adminLevel returns how specific a Location is: 0 for countries, 1 for
+// provinces and 2 for cities.
+func adminLevel(l Location) int {
+	switch {
+	case l.City != "":
+		return 2
+	case l.Province != "" || l.ProvinceCode != "":
+		return 1
+	default:
+		return 0
+	}
+}
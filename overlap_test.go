@@ -0,0 +1,129 @@
+package rgeo
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/go-test/deep"
+	"github.com/twpayne/go-geom"
+	"github.com/twpayne/go-geom/encoding/geojson"
+)
+
+func TestDetectOverlaps(t *testing.T) {
+	var in geojson.FeatureCollection
+	if err := json.Unmarshal([]byte(`{"type":"FeatureCollection","features":[
+		{"type":"Feature","properties":{"ISO_A3_EH":"AAA"},
+		 "geometry":{"type":"Polygon",
+		  "coordinates":[[[0,0],[2,0],[2,2],[0,2],[0,0]]]}},
+		{"type":"Feature","properties":{"ISO_A3_EH":"AAA"},
+		 "geometry":{"type":"Polygon",
+		  "coordinates":[[[1,0],[3,0],[3,2],[1,2],[1,0]]]}},
+		{"type":"Feature","properties":{"ISO_A3_EH":"CCC"},
+		 "geometry":{"type":"Polygon",
+		  "coordinates":[[[3,0],[5,0],[5,2],[3,2],[3,0]]]}},
+		{"type":"Feature","properties":{"name_conve":"City"},
+		 "geometry":{"type":"Polygon",
+		  "coordinates":[[[0.5,0.5],[1,0.5],[1,1],[0.5,1],[0.5,0.5]]]}}
+		]}`), &in); err != nil {
+		t.Fatalf("decode GeoJSON: %s", err)
+	}
+	fc, err := LoadGeoJSON(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	warnings := fc.DetectOverlaps()
+	if len(warnings) != 1 {
+		t.Fatalf("expected 1 warning, got %d: %+v", len(warnings), warnings)
+	}
+
+	w := warnings[0]
+	if diff := deep.Equal([]int{0, 1}, []int{w.A, w.B}); diff != nil {
+		t.Error(diff)
+	}
+	if diff := deep.Equal(Location{CountryCode3: "AAA"}, w.LocationA); diff != nil {
+		t.Error(diff)
+	}
+	// The polygons overlap by half, the estimate is a bit lower
+	if w.Fraction < 0.4 || w.Fraction > 0.5 {
+		t.Errorf("expected overlap of about 0.5, got %f", w.Fraction)
+	}
+}
+
+func TestOverlapCandidates(t *testing.T) {
+	fc := FeatureCollection(testDataset(t, `{"type":"FeatureCollection","features":[
+		{"type":"Feature","properties":{"ISO_A3_EH":"AAA"},
+		 "geometry":{"type":"Polygon",
+		  "coordinates":[[[0,0],[2,0],[2,2],[0,2],[0,0]]]}},
+		{"type":"Feature","properties":{"ISO_A3_EH":"FAR"},
+		 "geometry":{"type":"Polygon",
+		  "coordinates":[[[100,0],[102,0],[102,2],[100,2],[100,0]]]}},
+		{"type":"Feature","properties":{"ISO_A3_EH":"BBB"},
+		 "geometry":{"type":"Polygon",
+		  "coordinates":[[[1,1],[3,1],[3,3],[1,3],[1,1]]]}},
+		{"type":"Feature","properties":{"name_conve":"City"},
+		 "geometry":{"type":"Polygon",
+		  "coordinates":[[[0.5,0.5],[0.6,0.5],[0.6,0.6],[0.5,0.6],[0.5,0.5]]]}}
+		]}`)())
+
+	// The bound coverings are coarse, so BBB and City may be candidates too
+	candidates := overlapCandidates(fc)
+	if diff := deep.Equal([]int{2, 3}, candidates[0]); diff != nil {
+		t.Error(diff)
+	}
+	if len(candidates[1]) != 0 {
+		t.Errorf("expected no candidates for FAR, got %v", candidates[1])
+	}
+}
+
+func TestCountriesIntersecting(t *testing.T) {
+	// Gamma is east of Alpha with a small gap, as in TestOnBorder
+	r, err := New(testDataset(t, lookupTestData[:len(lookupTestData)-2]+`,
+		{"type":"Feature","properties":{"ADMIN":"Gamma","ISO_A3_EH":"CCC"},
+		 "geometry":{"type":"Polygon",
+		  "coordinates":[[[2.005,0],[3,0],[3,2],[2.005,2],[2.005,0]]]}}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	alpha := Location{Country: "Alpha", CountryCode3: "AAA"}
+	beta := Location{Country: "Beta", CountryCode3: "BBB"}
+	gamma := Location{Country: "Gamma", CountryCode3: "CCC"}
+
+	box := func(minLon, minLat, maxLon, maxLat float64) *geom.Polygon {
+		return geom.NewPolygon(geom.XY).MustSetCoords([][]geom.Coord{{
+			{minLon, minLat}, {maxLon, minLat}, {maxLon, maxLat},
+			{minLon, maxLat}, {minLon, minLat},
+		}})
+	}
+
+	tests := []struct {
+		name     string
+		in       *geom.Polygon
+		err      error
+		expected []Location
+	}{
+		{"Inside", box(1.9, 0.5, 1.95, 1), nil, []Location{alpha}},
+		{"Mostly Gamma", box(1.9, 0.2, 3.5, 0.8), nil, []Location{gamma, alpha}},
+		{"Mostly Alpha", box(1.5, 0.2, 3.8, 0.8), nil, []Location{alpha, gamma}},
+		{"Provinces", box(10.5, 0.5, 11.5, 1.5), nil, []Location{beta}},
+		{"Ocean", box(5, 5, 6, 6), ErrLocationNotFound, nil},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			locs, err := r.CountriesIntersecting(test.in)
+			if err != test.err {
+				t.Errorf("expected error: %s\n got: %v\n", test.err, err)
+			}
+			if diff := deep.Equal(test.expected, locs); diff != nil {
+				t.Error(diff)
+			}
+		})
+	}
+
+	if _, err := r.CountriesIntersecting(nil); err == nil {
+		t.Error("expected error for nil polygon")
+	}
+}
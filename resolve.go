@@ -0,0 +1,142 @@
+package rgeo
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/golang/geo/s2"
+	"github.com/twpayne/go-geom"
+)
+
+// Level is a step of the fallback chain of ReverseGeocodeResolve.
+type Level int
+
+const (
+	// LevelCountry is satisfied by a country containing the coordinate.
+	LevelCountry Level = iota
+	// LevelProvince is satisfied by a province containing the coordinate.
+	LevelProvince
+	// LevelCity is satisfied by a city containing the coordinate.
+	LevelCity
+	// LevelNearest is satisfied by any location within the snapping distance,
+	// as returned by ReverseGeocodeSnapping.
+	LevelNearest
+	// LevelNearestCity is satisfied by the nearest city within the snapping
+	// distance, see NearestCities, which is the city containing the coordinate
+	// if there is one. Put LevelCity before it to tell approximate cities apart.
+	LevelNearestCity
+)
+
+func (l Level) String() string {
+	switch l {
+	case LevelCountry:
+		return "country"
+	case LevelProvince:
+		return "province"
+	case LevelCity:
+		return "city"
+	case LevelNearest:
+		return "nearest"
+	case LevelNearestCity:
+		return "nearest city"
+	default:
+		return fmt.Sprintf("Level(%d)", int(l))
+	}
+}
+
+// ReverseGeocodeResolve tries each Level of chain in order and returns the
+// Location of the first one that is satisfied, along with that Level. For
+// example, to fall back from the city to the province, the country and
+// finally the nearest location:
+//
+//	r.ReverseGeocodeResolve(coord, []Level{
+//		LevelCity, LevelProvince, LevelCountry, LevelNearest,
+//	})
+//
+// The Location for a containment level combines all containing features up to
+// that level, so LevelProvince returns the province and its country, but no
+// city. For LevelNearestCity it is that of LevelProvince, or LevelCountry
+// without a province, with the City of the nearest city, which may even be in
+// another country close to a border:
+//
+//	r.ReverseGeocodeResolve(coord, []Level{
+//		LevelCity, LevelNearestCity, LevelProvince, LevelCountry,
+//	})
+//
+// ErrLocationNotFound is returned if no Level is satisfied.
+func (r *Rgeo) ReverseGeocodeResolve(coord geom.Coord, chain []Level) (Location, Level, error) {
+	if len(chain) == 0 {
+		return Location{}, 0, errors.New("empty fallback chain")
+	}
+	for _, level := range chain {
+		if level < LevelCountry || level > LevelNearestCity {
+			return Location{}, 0, fmt.Errorf("unknown level %d", int(level))
+		}
+	}
+
+	res, err := r.containingShapesAt(coord)
+	if err != nil {
+		return Location{}, 0, err
+	}
+
+	for _, level := range chain {
+		if level == LevelNearest {
+			loc, err := r.ReverseGeocodeSnapping(coord)
+			if errors.Is(err, ErrLocationNotFound) {
+				continue
+			}
+			return loc, level, err
+		}
+
+		if level == LevelNearestCity {
+			city, ok, err := r.nearestCityWithin(coord, r.snappingDistance)
+			if err != nil {
+				return Location{}, 0, err
+			} else if !ok {
+				continue
+			}
+
+			var shapes []s2.Shape
+			for _, s := range res {
+				if adminLevel(s.(shapeLocation).Location()) < 2 {
+					shapes = append(shapes, s)
+				}
+			}
+			loc := r.combineLocations(shapes)
+			loc.City = city
+			return loc, level, nil
+		}
+
+		var (
+			shapes []s2.Shape
+			found  bool
+		)
+		for _, s := range res {
+			switch l := Level(adminLevel(s.(shapeLocation).Location())); {
+			case l == level:
+				found = true
+				fallthrough
+			case l < level:
+				shapes = append(shapes, s)
+			}
+		}
+		if found {
+			return r.combineLocations(shapes), level, nil
+		}
+	}
+
+	return Location{}, 0, ErrLocationNotFound
+}
+
+// nearestCityWithin returns the name of the nearest city within d kilometers
+// of coord, and whether there is one.
+func (r *Rgeo) nearestCityWithin(coord geom.Coord, d float64) (string, bool, error) {
+	locs, distances, err := r.NearestCities(coord, 1)
+	if errors.Is(err, ErrLocationNotFound) || err == nil && distances[0] > d {
+		return "", false, nil
+	} else if err != nil {
+		return "", false, err
+	}
+
+	return locs[0].City, true, nil
+}
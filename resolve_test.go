@@ -0,0 +1,133 @@
+package rgeo
+
+import (
+	"testing"
+
+	"github.com/go-test/deep"
+	"github.com/twpayne/go-geom"
+)
+
+func TestReverseGeocodeResolve(t *testing.T) {
+	// Alpha has a province with a city, and a stretch of coast without any
+	r, err := New(testDataset(t, `{"type":"FeatureCollection","features":[
+		{"type":"Feature","properties":{"ADMIN":"Alpha","ISO_A3_EH":"AAA"},
+		 "geometry":{"type":"Polygon",
+		  "coordinates":[[[0,0],[2,0],[2,1],[0,1],[0,0]]]}},
+		{"type":"Feature","properties":{"admin":"Alpha","name":"West","iso_3166_2":"AA-W"},
+		 "geometry":{"type":"Polygon",
+		  "coordinates":[[[0,0],[1,0],[1,1],[0,1],[0,0]]]}},
+		{"type":"Feature","properties":{"name_conve":"Alpha City"},
+		 "geometry":{"type":"Polygon",
+		  "coordinates":[[[0.2,0.2],[0.4,0.2],[0.4,0.4],[0.2,0.4],[0.2,0.2]]]}}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	all := []Level{LevelCity, LevelProvince, LevelCountry, LevelNearest}
+
+	tests := []struct {
+		name     string
+		in       geom.Coord
+		chain    []Level
+		err      error
+		level    Level
+		expected Location
+	}{
+		{
+			name:  "City",
+			in:    geom.Coord{0.3, 0.3},
+			chain: all,
+			level: LevelCity,
+			expected: Location{
+				Country:      "Alpha",
+				CountryCode2: "AA",
+				CountryCode3: "AAA",
+				Province:     "West",
+				ProvinceCode: "AA-W",
+				City:         "Alpha City",
+			},
+		},
+		{
+			name:  "Province",
+			in:    geom.Coord{0.3, 0.3},
+			chain: []Level{LevelProvince},
+			level: LevelProvince,
+			expected: Location{
+				Country:      "Alpha",
+				CountryCode2: "AA",
+				CountryCode3: "AAA",
+				Province:     "West",
+				ProvinceCode: "AA-W",
+			},
+		},
+		{
+			name:     "Country",
+			in:       geom.Coord{1.5, 0.5},
+			chain:    all,
+			level:    LevelCountry,
+			expected: Location{Country: "Alpha", CountryCode3: "AAA"},
+		},
+		{
+			name:     "Nearest",
+			in:       geom.Coord{2.01, 0.5},
+			chain:    all,
+			level:    LevelNearest,
+			expected: Location{Country: "Alpha", CountryCode3: "AAA"},
+		},
+		{
+			name:  "Nearest city",
+			in:    geom.Coord{0.3, 0.43},
+			chain: []Level{LevelCity, LevelNearestCity, LevelProvince},
+			level: LevelNearestCity,
+			expected: Location{
+				Country:      "Alpha",
+				CountryCode2: "AA",
+				CountryCode3: "AAA",
+				Province:     "West",
+				ProvinceCode: "AA-W",
+				City:         "Alpha City",
+			},
+		},
+		{
+			name:     "No city in range",
+			in:       geom.Coord{1.5, 0.5},
+			chain:    []Level{LevelNearestCity, LevelCountry},
+			level:    LevelCountry,
+			expected: Location{Country: "Alpha", CountryCode3: "AAA"},
+		},
+		{
+			name:  "Not found",
+			in:    geom.Coord{1.5, 0.5},
+			chain: []Level{LevelCity, LevelProvince},
+			err:   ErrLocationNotFound,
+		},
+		{
+			name:  "Ocean",
+			in:    geom.Coord{3, 0.5},
+			chain: all,
+			err:   ErrLocationNotFound,
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			result, level, err := r.ReverseGeocodeResolve(test.in, test.chain)
+			if err != test.err {
+				t.Errorf("expected error: %s\n got: %s\n", test.err, err)
+			}
+			if level != test.level {
+				t.Errorf("expected level %s, got %s", test.level, level)
+			}
+			if diff := deep.Equal(test.expected, result); diff != nil {
+				t.Error(diff)
+			}
+		})
+	}
+
+	for _, chain := range [][]Level{nil, {LevelCity, Level(7)}} {
+		if _, _, err := r.ReverseGeocodeResolve(geom.Coord{0.3, 0.3}, chain); err == nil {
+			t.Errorf("%v: expected error", chain)
+		}
+	}
+}
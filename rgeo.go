@@ -40,17 +40,33 @@ import (
 	"errors"
 	"fmt"
 	"math"
+	"sort"
 	"strings"
+	"time"
 
 	"github.com/golang/geo/s1"
 	"github.com/golang/geo/s2"
 	"github.com/twpayne/go-geom"
+	"github.com/twpayne/go-geom/encoding/wkb"
+	"github.com/twpayne/go-geom/encoding/wkt"
 )
 
 // ErrLocationNotFound is returned when no country is found for given
-// coordinates.
+// coordinates. The coordinates are valid, they are just not in any of the
+// loaded features, e.g. in the ocean.
 var ErrLocationNotFound = errors.New("country not found")
 
+// ErrInvalidCoordinate is returned, wrapped with a description, by all lookups
+// for coordinates that aren't a valid longitude and latitude, e.g. NaN, a
+// latitude beyond the poles or fewer than two values, and for points that
+// can't be read, e.g. empty WKT points. Unlike ErrLocationNotFound it
+// indicates a problem with the input data rather than a lookup without result.
+var ErrInvalidCoordinate = errors.New("invalid coordinate")
+
+// ErrIndexNotBuilt is returned by lookups after RequireBuild was called, if the
+// index hasn't been built with Build.
+var ErrIndexNotBuilt = errors.New("index not built")
+
 // Location is the return type for ReverseGeocode.
 type Location struct {
 	// Commonly used country name
@@ -63,6 +79,24 @@ type Location struct {
 	CountryCode2 string `json:"country_code_2,omitempty"`
 	CountryCode3 string `json:"country_code_3,omitempty"`
 
+	// ISO 3166-1 numeric code, e.g. "840" for the United States. The included
+	// datasets were generated before it was added, so it is only set for
+	// custom or regenerated datasets.
+	CountryCodeNumeric string `json:"country_code_numeric,omitempty"`
+
+	// Sovereign state of a country, e.g. "United Kingdom" for Bermuda, or of
+	// a maritime Exclusive Economic Zone. EEZs are the features with a
+	// Sovereignty but no Country. The included datasets were generated
+	// before it was read for countries, so it is only set for custom or
+	// regenerated datasets.
+	Sovereignty string `json:"sovereignty,omitempty"`
+
+	// Disputed is set for territories with a contested status, such as
+	// Western Sahara or Kashmir. Natural Earth marks these with a NOTE_BRK,
+	// which the included datasets don't have yet, so it is only set for
+	// custom or regenerated datasets.
+	Disputed bool `json:"disputed,omitempty"`
+
 	Continent string `json:"continent,omitempty"`
 	Region    string `json:"region,omitempty"`
 	SubRegion string `json:"subregion,omitempty"`
@@ -73,12 +107,62 @@ type Location struct {
 	ProvinceCode string `json:"province_code,omitempty"`
 
 	City string `json:"city,omitempty"`
+
+	// Population estimates of the country and of the city. The included
+	// datasets were generated before these were added, so they are only set
+	// for custom or regenerated datasets.
+	Population     int64 `json:"population,omitempty"`
+	CityPopulation int64 `json:"city_population,omitempty"`
+
+	// Source is the upstream dataset the feature was generated from, e.g. the
+	// name of the GeoJSON file, as recorded by datagen. Combined Locations
+	// have the distinct sources of all merged features, separated by ", ".
+	// The included datasets were generated before datagen recorded it, so it
+	// is only set for custom or regenerated datasets.
+	Source string `json:"source,omitempty"`
 }
 
 // Rgeo is the type used to hold pre-created polygons for reverse geocoding.
 type Rgeo struct {
-	index         *s2.ShapeIndex
-	makeEdgeQuery func() *s2.EdgeQuery
+	// MergeFunc merges the Location src of a matching shape into the Location
+	// dst combined from the previous matches, in the order the shapes were
+	// added. If it is nil MergeFirstNonEmpty is used, and merging stops early
+	// once all fields set in any of the shapes are set. It should be set
+	// before the first lookup since cached results aren't updated.
+	MergeFunc func(dst, src Location) Location
+
+	// Hooks are called by ReverseGeocode, ReverseGeocodeSnapping and
+	// AnnotateCoords, e.g. to count queries for metrics.
+	Hooks Hooks
+
+	index        *s2.ShapeIndex
+	cache        *lruCache
+	requireBuild bool
+
+	// setFields has the fields that are set in any of the shapes, see
+	// Location.complete.
+	setFields Location
+
+	// hasEEZ is whether any of the shapes is an EEZ, see isEEZ.
+	hasEEZ bool
+
+	// land is the cached result of LandPolygon.
+	land *lazyPolygon
+
+	// cities is the index of the cities used by NearestCities.
+	cities *lazyIndex
+
+	// alwaysSnap makes ReverseGeocode behave like ReverseGeocodeSnapping, see
+	// WithAlwaysSnap.
+	alwaysSnap bool
+
+	// radius of the sphere in kilometers, used to convert between distances
+	// and angles.
+	radius float64
+
+	// snappingDistance is the distance limit of ReverseGeocodeSnapping in
+	// kilometers.
+	snappingDistance float64
 }
 
 // shapeLocation is used for storing location references in s2.ShapeIndex.
@@ -90,7 +174,11 @@ type shapeLocation interface {
 // shape implements shapeLocation
 type shape struct {
 	s2.Shape
-	loc Location
+	loc     Location
+	dataset string
+
+	// validity of the feature, see Feature.ValidFrom
+	validFrom, validTo time.Time
 }
 
 func (s *shape) Location() Location {
@@ -116,15 +204,92 @@ func New(datasets ...Dataset) (*Rgeo, error) {
 	r := &Rgeo{index: s2.NewShapeIndex()}
 	r.SetSnappingDistanceEarth(5) // kilometers on Earth
 	for _, dataset := range datasets {
-		features := dataset()
-		for _, f := range features {
-			p := f.Polygon
-			r.index.Add(&shape{Shape: p, loc: f.Location})
-		}
+		r.AddDataset(dataset)
 	}
 	return r, nil
 }
 
+// NewGeneric is like New, but takes the features directly, which is useful for
+// custom datasets that aren't administrative areas, e.g. biomes or elevation
+// zones. The fields of Location are only labels to rgeo, so any of them can be
+// used for other data, e.g. Region for the name of a biome and Province for the
+// name of an ecoregion within it. Lookups combine the fields of all containing
+// features with MergeFunc as usual.
+func NewGeneric(features []Feature) (*Rgeo, error) {
+	if len(features) == 0 {
+		return nil, errors.New("no features provided")
+	}
+	return New(func() []Feature { return features })
+}
+
+// AddDataset adds the features of another dataset to r. The index has to be
+// built again afterwards, either by calling Build or implicitly on the next
+// lookup. AddDataset must not be called concurrently with lookups.
+func (r *Rgeo) AddDataset(dataset Dataset) {
+	// s2 deadlocks when adding shapes to an index that has already been built,
+	// so the existing shapes are moved to a new index instead.
+	index := s2.NewShapeIndex()
+	for i := 0; i < r.index.Len(); i++ {
+		index.Add(r.index.Shape(int32(i)))
+	}
+	for _, f := range dataset() {
+		index.Add(&shape{
+			Shape:     f.Polygon,
+			loc:       f.Location,
+			dataset:   f.dataset,
+			validFrom: f.ValidFrom,
+			validTo:   f.ValidTo,
+		})
+		r.hasEEZ = r.hasEEZ || isEEZ(f.Location)
+		r.setFields = MergeFirstNonEmpty(r.setFields, Location{
+			Country:            f.Location.Country,
+			CountryLong:        f.Location.CountryLong,
+			CountryCode2:       f.Location.CountryCode2,
+			CountryCode3:       f.Location.CountryCode3,
+			CountryCodeNumeric: f.Location.CountryCodeNumeric,
+			Sovereignty:        f.Location.Sovereignty,
+			Continent:          f.Location.Continent,
+			Region:             f.Location.Region,
+			SubRegion:          f.Location.SubRegion,
+			Province:           f.Location.Province,
+			ProvinceCode:       f.Location.ProvinceCode,
+			City:               f.Location.City,
+			Population:         f.Location.Population,
+			CityPopulation:     f.Location.CityPopulation,
+		})
+	}
+	r.index = index
+	r.land = &lazyPolygon{}
+	r.cities = &lazyIndex{}
+	r.clearCache()
+}
+
+// Clone returns a copy of r that shares its index, but has its own snapping
+// distance, MergeFunc and cache (which starts out empty). This allows varying
+// the configuration per request without affecting concurrent users of r.
+//
+// The shared index is only read by lookups, and AddDataset on either Rgeo
+// replaces its own index rather than modifying the shared one.
+func (r *Rgeo) Clone() *Rgeo {
+	c := *r
+	if r.cache != nil {
+		c.cache = r.cache.emptyCopy()
+	}
+	return &c
+}
+
+// WithAlwaysSnap returns a Clone of r whose ReverseGeocode behaves like
+// ReverseGeocodeSnapping with a snapping distance of marginKM kilometers, so
+// coordinates just outside of a location, e.g. on the coast, are found without
+// having to use ReverseGeocodeSnapping at every call site. marginKM must be
+// positive. r itself is not changed.
+func (r *Rgeo) WithAlwaysSnap(marginKM float64) *Rgeo {
+	c := r.Clone()
+	c.SetSnappingDistanceCustom(marginKM, r.radius)
+	c.alwaysSnap = true
+	return c
+}
+
 // Build builds the underlying shape index. This ensures that future calls to
 // ReverseGeocode will be fast. If Build is not called, then the first lookup
 // will build the index implicitly and experience a 1s+ delay.
@@ -132,92 +297,542 @@ func (r *Rgeo) Build() {
 	r.index.Build()
 }
 
+// Compact builds the index if necessary and drops the results in the lookup
+// cache, so the GC can reclaim them. The cache stays enabled. Lookups only need
+// the index, and New and AddDataset keep nothing but the Polygon and Location
+// of each Feature, so the slices returned by the datasets can already be
+// reclaimed without calling Compact.
+func (r *Rgeo) Compact() {
+	r.Build()
+	if r.cache != nil {
+		r.cache.shrink()
+	}
+}
+
+// ShapeIndex returns the underlying s2 ShapeIndex, building it first if
+// needed. It can be used to run custom s2 queries on the loaded shapes, the
+// Location of a resulting shape is returned by ShapeLocation. Modifying the
+// index is not supported.
+func (r *Rgeo) ShapeIndex() *s2.ShapeIndex {
+	r.index.Build()
+	return r.index
+}
+
+// ShapeLocation returns the Location of the shape with the given ID in the
+// index returned by ShapeIndex, and whether there is such a shape.
+func (r *Rgeo) ShapeLocation(id int32) (Location, bool) {
+	s, ok := r.index.Shape(id).(shapeLocation)
+	if !ok {
+		return Location{}, false
+	}
+	return s.Location(), true
+}
+
+// RequireBuild makes all future lookups return ErrIndexNotBuilt instead of
+// implicitly building the index, whenever Build wasn't called after New or
+// AddDataset. This avoids unexpected delays in latency sensitive code.
+func (r *Rgeo) RequireBuild() {
+	r.requireBuild = true
+}
+
+// checkBuilt returns ErrIndexNotBuilt if the index has to be built before a
+// lookup, see RequireBuild.
+func (r *Rgeo) checkBuilt() error {
+	if r.requireBuild && !r.index.IsFresh() {
+		return ErrIndexNotBuilt
+	}
+	return nil
+}
+
 // SetSnappingDistanceEarth sets ReverseGeocodeSnapping snap distance on Earth.
 // Only edges within the defined radius around given points will be considered
 // by ReverseGeocodeSnapping.
 //
 // The input is the snapping distance in kilometers. Must be positive.
 func (r *Rgeo) SetSnappingDistanceEarth(d float64) {
-	const earthRadiusKM = 6371
 	r.SetSnappingDistanceCustom(d, earthRadiusKM)
 }
 
-// SetSnappingDistanceCustom recalculates the underlying ChordAngle for the
-// DistanceLimit of nearest-edge queries via ReverseGeocodeSnapping.
+// SetSnappingDistanceCustom sets the distance limit of the nearest-edge
+// search of ReverseGeocodeSnapping.
 //
 // The inputs are the snapping distance on the sphere's surface in kilometers,
-// and the radius of the sphere used in the dataset.
+// and the radius of the sphere used in the dataset. The radius is also used by
+// all other methods taking or returning distances, see Radius.
+//
+// It can also be called after Build, e.g. to try several distances: only the
+// cached results are dropped, the index is kept as it is, so neither New nor
+// Build have to be called again.
 func (r *Rgeo) SetSnappingDistanceCustom(d float64, radius float64) {
-	angle := math.Sin(d / radius)
-	options := s2.NewClosestEdgeQueryOptions().
-		MaxResults(1).
-		DistanceLimit(s1.ChordAngleFromAngle(s1.Angle(angle)).Successor())
-	r.makeEdgeQuery = func() *s2.EdgeQuery {
-		return s2.NewClosestEdgeQuery(r.index, options)
-	}
+	r.radius = radius
+	r.snappingDistance = d
+	r.clearCache()
 }
 
 // ReverseGeocode returns the country in which the given coordinate is located.
 //
 // The input is a geom.Coord, which is just a []float64 with the longitude
 // in the zeroth position and the latitude in the first position
-// (i.e. []float64{lon, lat}).
+// (i.e. []float64{lon, lat}). Further values, such as the altitude of
+// geom.XYZ coordinates, are ignored.
+//
+// If r was returned by WithAlwaysSnap, this is the same as
+// ReverseGeocodeSnapping.
 func (r *Rgeo) ReverseGeocode(loc geom.Coord) (Location, error) {
-	query := s2.NewContainsPointQuery(r.index, s2.VertexModelOpen)
-	res := query.ContainingShapes(pointFromCoord(loc))
-	if len(res) == 0 {
+	if r.alwaysSnap {
+		return r.ReverseGeocodeSnapping(loc)
+	}
+
+	r.Hooks.query()
+	l, err := r.reverseGeocode(loc)
+	r.Hooks.result(loc, l, err)
+
+	return l, err
+}
+
+// reverseGeocode implements ReverseGeocode without calling the Hooks.
+func (r *Rgeo) reverseGeocode(loc geom.Coord) (Location, error) {
+	if err := validateCoord(loc); err != nil {
+		return Location{}, err
+	}
+
+	return r.ReverseGeocodePoint(pointFromCoord(loc))
+}
+
+// ReverseGeocodePoint is like ReverseGeocode, but takes an s2.Point.
+func (r *Rgeo) ReverseGeocodePoint(p s2.Point) (Location, error) {
+	if err := validatePoint(p); err != nil {
+		return Location{}, err
+	}
+
+	res, err := r.containingShapes(p)
+	if err != nil {
+		return Location{}, err
+	} else if len(res) == 0 {
 		return Location{}, ErrLocationNotFound
 	}
 
 	return r.combineLocations(res), nil
 }
 
+// ReverseGeocodeWithSource is like ReverseGeocode, but also returns the names
+// of the datasets the matching shapes came from (see DatasetNamed), in the
+// order they were merged and separated by commas. Datasets without a name are
+// left out.
+func (r *Rgeo) ReverseGeocodeWithSource(loc geom.Coord) (Location, string, error) {
+	res, err := r.containingShapesAt(loc)
+	if err != nil {
+		return Location{}, "", err
+	} else if len(res) == 0 {
+		return Location{}, "", ErrLocationNotFound
+	}
+
+	var sources []string
+	for _, s := range res {
+		name := s.(*shape).dataset
+		if name != "" && !containsString(sources, name) {
+			sources = append(sources, name)
+		}
+	}
+
+	return r.combineLocations(res), strings.Join(sources, ","), nil
+}
+
+// ReverseGeocodeAll returns the Locations of all shapes containing the given
+// coordinate without combining them, smallest polygon first. This makes nested
+// jurisdictions like enclaves visible, where the smallest polygon is usually
+// the most specific one, instead of relying on the order of the datasets.
+//
+// Note that the included Natural Earth datasets are too coarse for some
+// enclaves, e.g. those in Baarle.
+func (r *Rgeo) ReverseGeocodeAll(loc geom.Coord) ([]Location, error) {
+	res, err := r.containingShapesAt(loc)
+	if err != nil {
+		return nil, err
+	} else if len(res) == 0 {
+		return nil, ErrLocationNotFound
+	}
+
+	areas := make(map[s2.Shape]float64, len(res))
+	for _, s := range res {
+		areas[s] = s.(*shape).Shape.(*s2.Polygon).Area()
+	}
+	sort.SliceStable(res, func(i, j int) bool { return areas[res[i]] < areas[res[j]] })
+
+	locs := make([]Location, len(res))
+	for i, s := range res {
+		locs[i] = s.(shapeLocation).Location()
+	}
+	return locs, nil
+}
+
+// CountryAt returns the Country of the location containing the given
+// coordinate, see ReverseGeocode.
+func (r *Rgeo) CountryAt(loc geom.Coord) (string, error) {
+	return r.fieldAt(loc, func(l Location) string { return l.Country })
+}
+
+// CountryCode3At returns the CountryCode3 of the location containing the
+// given coordinate, see ReverseGeocode.
+func (r *Rgeo) CountryCode3At(loc geom.Coord) (string, error) {
+	return r.fieldAt(loc, func(l Location) string { return l.CountryCode3 })
+}
+
+// ProvinceAt returns the Province of the location containing the given
+// coordinate, see ReverseGeocode.
+func (r *Rgeo) ProvinceAt(loc geom.Coord) (string, error) {
+	return r.fieldAt(loc, func(l Location) string { return l.Province })
+}
+
+// CityAt returns the City of the location containing the given coordinate,
+// see ReverseGeocode.
+func (r *Rgeo) CityAt(loc geom.Coord) (string, error) {
+	return r.fieldAt(loc, func(l Location) string { return l.City })
+}
+
+// fieldAt returns the given field of the Location ReverseGeocode returns for
+// loc, so the MergeFunc, WithAlwaysSnap and the Hooks apply as they do there.
+func (r *Rgeo) fieldAt(loc geom.Coord, field func(Location) string) (string, error) {
+	l, err := r.ReverseGeocode(loc)
+	if err != nil {
+		return "", err
+	}
+
+	return field(l), nil
+}
+
+// containingShapesAt is like containingShapes, but validates and converts the
+// given coordinate first.
+func (r *Rgeo) containingShapesAt(loc geom.Coord) ([]s2.Shape, error) {
+	if err := validateCoord(loc); err != nil {
+		return nil, err
+	}
+
+	return r.containingShapes(pointFromCoord(loc))
+}
+
+// containingShapes returns all shapes containing the given point.
+func (r *Rgeo) containingShapes(p s2.Point) ([]s2.Shape, error) {
+	if err := r.checkBuilt(); err != nil {
+		return nil, err
+	}
+
+	query := s2.NewContainsPointQuery(r.index, s2.VertexModelOpen)
+	return query.ContainingShapes(p), nil
+}
+
+// DetectCoordOrder guesses the order of the two values of a coordinate from
+// their ranges. It returns whether a is the longitude, and whether that could
+// be determined at all, which is only the case if exactly one of the values is
+// outside of the latitude range [-90, 90].
+func DetectCoordOrder(a, b float64) (lonFirst bool, ok bool) {
+	aLat, bLat := math.Abs(a) <= 90, math.Abs(b) <= 90
+	if aLat == bLat {
+		return true, false
+	}
+	return bLat, true
+}
+
+// ReverseGeocodeAuto is a forgiving version of ReverseGeocode for interactive
+// use, which accepts the coordinate in either order. If DetectCoordOrder can't
+// tell the order from the values, a is assumed to be the longitude, and only
+// if that doesn't find a location, b is tried as the longitude instead. The
+// returned bool reports whether a was used as the latitude.
+func (r *Rgeo) ReverseGeocodeAuto(a, b float64) (Location, bool, error) {
+	if lonFirst, ok := DetectCoordOrder(a, b); ok {
+		if lonFirst {
+			loc, err := r.ReverseGeocode(geom.Coord{a, b})
+			return loc, false, err
+		}
+		loc, err := r.ReverseGeocode(geom.Coord{b, a})
+		return loc, true, err
+	}
+
+	loc, err := r.ReverseGeocode(geom.Coord{a, b})
+	if !errors.Is(err, ErrLocationNotFound) {
+		return loc, false, err
+	}
+
+	if swapped, err := r.ReverseGeocode(geom.Coord{b, a}); err == nil {
+		return swapped, true, nil
+	}
+
+	return loc, false, err
+}
+
+// ReverseGeocodeWKT is like ReverseGeocode, but takes the coordinate as a WKT
+// POINT string (e.g. "POINT(0 52)") as exported by PostGIS and others.
+func (r *Rgeo) ReverseGeocodeWKT(s string) (Location, error) {
+	g, err := wkt.Unmarshal(s)
+	if err != nil {
+		return Location{}, fmt.Errorf("%w: decode WKT: %w", ErrInvalidCoordinate, err)
+	}
+
+	return r.reverseGeocodeGeometry(g)
+}
+
+// ReverseGeocodeWKB is like ReverseGeocodeWKT, but takes the POINT in WKB.
+func (r *Rgeo) ReverseGeocodeWKB(b []byte) (Location, error) {
+	g, err := wkb.Unmarshal(b)
+	if err != nil {
+		return Location{}, fmt.Errorf("%w: decode WKB: %w", ErrInvalidCoordinate, err)
+	}
+
+	return r.reverseGeocodeGeometry(g)
+}
+
+// ReverseGeocodeGeomPoint is like ReverseGeocode, but takes a geom.Point,
+// which must not be nil or empty.
+func (r *Rgeo) ReverseGeocodeGeomPoint(p *geom.Point) (Location, error) {
+	if p == nil || p.Empty() {
+		return Location{}, fmt.Errorf("%w: empty Point", ErrInvalidCoordinate)
+	}
+
+	return r.ReverseGeocode(p.Coords())
+}
+
+// reverseGeocodeGeometry calls ReverseGeocodeGeomPoint with g, which has to be
+// a Point.
+func (r *Rgeo) reverseGeocodeGeometry(g geom.T) (Location, error) {
+	p, ok := g.(*geom.Point)
+	if !ok {
+		return Location{}, fmt.Errorf("%w: needs Point, got %T", ErrInvalidCoordinate, g)
+	}
+
+	return r.ReverseGeocodeGeomPoint(p)
+}
+
+// ReverseGeocodeSnapping is like ReverseGeocode, but if the coordinate isn't
+// in any location it returns the closest location within the snapping
+// distance instead, see SetSnappingDistanceEarth.
+//
+// Results are cached if EnableCache or EnableCacheTTL was called.
 func (r *Rgeo) ReverseGeocodeSnapping(coord geom.Coord) (Location, error) {
+	r.Hooks.query()
+	loc, err := r.reverseGeocodeSnappingCached(coord)
+	r.Hooks.result(coord, loc, err)
+
+	return loc, err
+}
+
+// reverseGeocodeSnappingCached implements ReverseGeocodeSnapping without
+// calling the Hooks.
+func (r *Rgeo) reverseGeocodeSnappingCached(coord geom.Coord) (Location, error) {
+	if r.cache == nil {
+		return r.reverseGeocodeSnapping(coord, r.snappingDistance)
+	}
+
+	// The key needs both values of the coordinate
+	if err := validateCoord(coord); err != nil {
+		return Location{}, err
+	}
+
+	key := r.cache.key(coord)
+	if e, ok := r.cache.get(key); ok {
+		return e.loc, e.err
+	}
+
+	gen := r.cache.generation()
+	loc, err := r.reverseGeocodeSnapping(coord, r.snappingDistance)
+	if err == nil || errors.Is(err, ErrLocationNotFound) {
+		r.cache.put(key, gen, loc, err)
+	}
+
+	return loc, err
+}
+
+// ReverseGeocodeSnappingWithin is like ReverseGeocodeSnapping, but uses the
+// given snapping distance in kilometers instead of the one set by
+// SetSnappingDistanceEarth. It doesn't change r and doesn't use the cache.
+func (r *Rgeo) ReverseGeocodeSnappingWithin(coord geom.Coord, marginKM float64) (Location, error) {
+	return r.reverseGeocodeSnapping(coord, marginKM)
+}
+
+// ReverseGeocodeSnappingAdaptive is like ReverseGeocodeSnappingWithin, but
+// starts with a snapping distance of baseMarginKM and doubles it until a
+// location is found or maxMarginKM is reached. Small margins are cheaper to
+// query, so this is faster than using maxMarginKM directly when most points
+// are close to a location.
+func (r *Rgeo) ReverseGeocodeSnappingAdaptive(coord geom.Coord, baseMarginKM, maxMarginKM float64) (Location, error) {
+	if baseMarginKM <= 0 || maxMarginKM < baseMarginKM {
+		return Location{}, fmt.Errorf("invalid snapping margins %g to %g km", baseMarginKM, maxMarginKM)
+	}
+
+	for margin := baseMarginKM; ; margin *= 2 {
+		margin = math.Min(margin, maxMarginKM)
+
+		loc, err := r.ReverseGeocodeSnappingWithin(coord, margin)
+		if !errors.Is(err, ErrLocationNotFound) || margin == maxMarginKM {
+			return loc, err
+		}
+	}
+}
+
+// ReverseGeocodeSnappingScored is like ReverseGeocodeSnapping, but also returns
+// a confidence score in [0, 1] for the result. The score is 1 if the
+// coordinate is inside of the location, and decreases linearly with the
+// distance to it otherwise, i.e. 1 - distance/SnappingDistanceKM, reaching 0
+// at the snapping distance. This allows rejecting borderline offshore hits
+// with a threshold. It doesn't use the cache.
+func (r *Rgeo) ReverseGeocodeSnappingScored(coord geom.Coord) (Location, float64, error) {
+	loc, d, err := r.reverseGeocodeSnappingDistance(coord, r.snappingDistance)
+	if err != nil {
+		return Location{}, 0, err
+	} else if r.snappingDistance <= 0 {
+		return loc, 1, nil
+	}
+
+	return loc, math.Max(0, 1-d/r.snappingDistance), nil
+}
+
+// reverseGeocodeSnapping implements ReverseGeocodeSnapping with the given
+// snapping distance in kilometers.
+func (r *Rgeo) reverseGeocodeSnapping(coord geom.Coord, marginKM float64) (Location, error) {
+	loc, _, err := r.reverseGeocodeSnappingDistance(coord, marginKM)
+	return loc, err
+}
+
+// reverseGeocodeSnappingDistance is like reverseGeocodeSnapping, but also
+// returns the distance in kilometers to the Location, which is zero if it
+// contains coord. The limit of the nearest-edge query is the one used by
+// SnapCandidates.
+func (r *Rgeo) reverseGeocodeSnappingDistance(coord geom.Coord, marginKM float64) (Location, float64, error) {
 	// Try to get a hit first, i.e. we are already in a country
-	loc, err := r.ReverseGeocode(coord)
+	loc, err := r.reverseGeocode(coord)
 	if err == nil {
-		return loc, nil
+		return loc, 0, nil
 	} else if !errors.Is(err, ErrLocationNotFound) {
-		return Location{}, err
+		return Location{}, 0, err
 	}
 
 	// Not in a country, so look for the closest country in the defined margin
+	options := s2.NewClosestEdgeQueryOptions().
+		MaxResults(1).
+		DistanceLimit(s1.ChordAngleFromAngle(r.distanceLimit(marginKM)).Successor())
 	point := pointFromCoord(coord)
-	res := r.makeEdgeQuery().FindEdges(s2.NewMinDistanceToPointTarget(point))
+	res := s2.NewClosestEdgeQuery(r.index, options).FindEdges(s2.NewMinDistanceToPointTarget(point))
 	if len(res) == 0 {
-		return Location{}, ErrLocationNotFound
+		return Location{}, 0, ErrLocationNotFound
 	}
 
 	// Get shape of the closest country in our margin
 	shape := r.index.Shape(res[0].ShapeID())
 	if shape == nil {
-		return Location{}, ErrLocationNotFound
+		return Location{}, 0, ErrLocationNotFound
 	}
 
-	return r.combineLocations([]s2.Shape{shape}), nil
+	return r.combineLocations([]s2.Shape{shape}), r.ChordAngleToKM(res[0].Distance()), nil
 }
 
-// combineLocations combines the Locations for the given s2 Shapes.
+// combineLocations combines the Locations for the given s2 Shapes. The
+// result is Disputed if any of the shapes is, regardless of the MergeFunc, and
+// a missing CountryCode2 is derived from the ProvinceCode.
 func (r *Rgeo) combineLocations(shapes []s2.Shape) (l Location) {
-	for _, s := range shapes {
-		loc := s.(shapeLocation).Location()
-		l = Location{
-			Country:      firstNonEmpty(l.Country, loc.Country),
-			CountryLong:  firstNonEmpty(l.CountryLong, loc.CountryLong),
-			CountryCode2: firstNonEmpty(l.CountryCode2, loc.CountryCode2),
-			CountryCode3: firstNonEmpty(l.CountryCode3, loc.CountryCode3),
-			Continent:    firstNonEmpty(l.Continent, loc.Continent),
-			Region:       firstNonEmpty(l.Region, loc.Region),
-			SubRegion:    firstNonEmpty(l.SubRegion, loc.SubRegion),
-			Province:     firstNonEmpty(l.Province, loc.Province),
-			ProvinceCode: firstNonEmpty(l.ProvinceCode, loc.ProvinceCode),
-			City:         firstNonEmpty(l.City, loc.City),
+	defer func() { l.CountryCode2 = firstNonEmpty(l.CountryCode2, countryCodeOfProvince(l.ProvinceCode)) }()
+
+	if r.MergeFunc != nil {
+		disputed := false
+		for _, s := range shapes {
+			src := s.(shapeLocation).Location()
+			l = r.MergeFunc(l, src)
+			disputed = disputed || src.Disputed
+		}
+		l.Disputed = l.Disputed || disputed
+		return
+	}
+
+	for i, s := range shapes {
+		l = MergeFirstNonEmpty(l, s.(shapeLocation).Location())
+
+		// Further shapes can't change a complete Location, apart from making
+		// it Disputed and adding their Source
+		if l.complete(r.setFields) {
+			for _, s := range shapes[i+1:] {
+				src := s.(shapeLocation).Location()
+				l.Disputed = l.Disputed || src.Disputed
+				l.Source = joinSources(l.Source, src.Source)
+			}
+			break
 		}
 	}
 
 	return
 }
 
+// countryCodeOfProvince returns the ISO 3166-1 alpha-2 code at the start of an
+// ISO 3166-2 code, e.g. "DE" for "DE-BY", or an empty string if code doesn't
+// have that form.
+func countryCodeOfProvince(code string) string {
+	if len(code) < 4 || code[2] != '-' {
+		return ""
+	}
+	for _, c := range code[:2] {
+		if (c < 'A' || c > 'Z') && (c < 'a' || c > 'z') {
+			return ""
+		}
+	}
+
+	return strings.ToUpper(code[:2])
+}
+
+// complete reports whether l has all of the fields set that are set in fields,
+// i.e. no other shape could fill in one of its empty fields. Disputed and
+// Source aren't checked, since they are combined from all shapes rather than
+// taken from the first.
+func (l Location) complete(fields Location) bool {
+	has := func(v, f string) bool { return v != "" || f == "" }
+	return has(l.Country, fields.Country) && has(l.CountryLong, fields.CountryLong) &&
+		has(l.CountryCode2, fields.CountryCode2) && has(l.CountryCode3, fields.CountryCode3) &&
+		has(l.CountryCodeNumeric, fields.CountryCodeNumeric) &&
+		has(l.Sovereignty, fields.Sovereignty) && has(l.Continent, fields.Continent) &&
+		has(l.Region, fields.Region) && has(l.SubRegion, fields.SubRegion) &&
+		has(l.Province, fields.Province) && has(l.ProvinceCode, fields.ProvinceCode) &&
+		has(l.City, fields.City) && (l.Population != 0 || fields.Population == 0) &&
+		(l.CityPopulation != 0 || fields.CityPopulation == 0)
+}
+
+// MergeFirstNonEmpty is the default Rgeo.MergeFunc. It keeps the fields of dst
+// and only fills in those that are empty from src, so the first shape with a
+// field set wins. Disputed is set if it is set in either, and the Source of
+// src is added to that of dst.
+func MergeFirstNonEmpty(dst, src Location) Location {
+	return Location{
+		Country:            firstNonEmpty(dst.Country, src.Country),
+		CountryLong:        firstNonEmpty(dst.CountryLong, src.CountryLong),
+		CountryCode2:       firstNonEmpty(dst.CountryCode2, src.CountryCode2),
+		CountryCode3:       firstNonEmpty(dst.CountryCode3, src.CountryCode3),
+		CountryCodeNumeric: firstNonEmpty(dst.CountryCodeNumeric, src.CountryCodeNumeric),
+		Sovereignty:        firstNonEmpty(dst.Sovereignty, src.Sovereignty),
+		Disputed:           dst.Disputed || src.Disputed,
+		Continent:          firstNonEmpty(dst.Continent, src.Continent),
+		Region:             firstNonEmpty(dst.Region, src.Region),
+		SubRegion:          firstNonEmpty(dst.SubRegion, src.SubRegion),
+		Province:           firstNonEmpty(dst.Province, src.Province),
+		ProvinceCode:       firstNonEmpty(dst.ProvinceCode, src.ProvinceCode),
+		City:               firstNonEmpty(dst.City, src.City),
+		Population:         firstNonZero(dst.Population, src.Population),
+		CityPopulation:     firstNonZero(dst.CityPopulation, src.CityPopulation),
+		Source:             joinSources(dst.Source, src.Source),
+	}
+}
+
+// joinSources adds source b to the list of sources a, unless it is already in
+// it.
+func joinSources(a, b string) string {
+	switch {
+	case b == "":
+		return a
+	case a == "":
+		return b
+	case containsString(strings.Split(a, sourceSeparator), b):
+		return a
+	}
+	return a + sourceSeparator + b
+}
+
+// sourceSeparator separates the sources in Location.Source.
+const sourceSeparator = ", "
+
 // firstNonEmpty returns the first non empty parameter.
 func firstNonEmpty(s ...string) string {
 	for _, i := range s {
@@ -229,19 +844,57 @@ func firstNonEmpty(s ...string) string {
 	return ""
 }
 
+// containsString reports whether s is in the slice.
+func containsString(slice []string, s string) bool {
+	for _, i := range slice {
+		if i == s {
+			return true
+		}
+	}
+
+	return false
+}
+
+// firstNonZero returns the first non zero parameter.
+func firstNonZero(n ...int64) int64 {
+	for _, i := range n {
+		if i != 0 {
+			return i
+		}
+	}
+
+	return 0
+}
+
 // Get the relevant strings from the GeoJSON properties.
-func getLocationStrings(p map[string]interface{}) Location {
+func getLocationStrings(p map[string]interface{}, opts GeoJSONOptions) Location {
+	m := opts.Mapping
+	if m == nil {
+		m = &defaultPropertyMapping
+	}
+
+	city := getPropertyString(p, m.City...)
+	if opts.TrimCitySuffix {
+		city = strings.TrimSuffix(city, "2")
+	}
+
 	return Location{
-		Country:      getPropertyString(p, "ADMIN", "admin"),
-		CountryLong:  getPropertyString(p, "FORMAL_EN"),
-		CountryCode2: getPropertyString(p, "ISO_A2_EH"),
-		CountryCode3: getPropertyString(p, "ISO_A3_EH"),
-		Continent:    getPropertyString(p, "CONTINENT"),
-		Region:       getPropertyString(p, "REGION_UN"),
-		SubRegion:    getPropertyString(p, "SUBREGION"),
-		Province:     getPropertyString(p, "name"),
-		ProvinceCode: getPropertyString(p, "iso_3166_2"),
-		City:         strings.TrimSuffix(getPropertyString(p, "name_conve"), "2"),
+		Country:            getPropertyString(p, m.Country...),
+		CountryLong:        getPropertyString(p, m.CountryLong...),
+		CountryCode2:       getPropertyString(p, m.CountryCode2...),
+		CountryCode3:       getPropertyString(p, m.CountryCode3...),
+		CountryCodeNumeric: getPropertyString(p, m.CountryCodeNumeric...),
+		Sovereignty:        getPropertyString(p, m.Sovereignty...),
+		Disputed:           getPropertyString(p, m.Disputed...) != "",
+		Continent:          getPropertyString(p, m.Continent...),
+		Region:             getPropertyString(p, m.Region...),
+		SubRegion:          getPropertyString(p, m.SubRegion...),
+		Province:           getPropertyString(p, m.Province...),
+		ProvinceCode:       getPropertyString(p, m.ProvinceCode...),
+		City:               city,
+		Population:         getPropertyInt(p, m.Population...),
+		CityPopulation:     getPropertyInt(p, m.CityPopulation...),
+		Source:             opts.Source,
 	}
 }
 
@@ -259,8 +912,32 @@ func getPropertyString(m map[string]interface{}, keys ...string) (s string) {
 	return
 }
 
-// polygonFromGeometry converts a geom.T to an s2 Polygon.
-func polygonFromGeometry(g geom.T) (*s2.Polygon, error) {
+// getPropertyInt is like getPropertyString, but for integer values. Numbers
+// decoded from JSON are float64, so those are accepted as well.
+func getPropertyInt(m map[string]interface{}, keys ...string) int64 {
+	for _, k := range keys {
+		switch v := m[k].(type) {
+		case float64:
+			return int64(v)
+		case int64:
+			return v
+		case int:
+			return int64(v)
+		}
+	}
+
+	return 0
+}
+
+// PolygonFromGeometry converts a geom.Polygon or geom.MultiPolygon to an s2
+// Polygon. The rings may be in either orientation, as is common in WKB and
+// GeoJSON, and are oriented counter-clockwise as s2 requires, assuming that
+// each ring covers less than a hemisphere. Altitudes and other extra
+// coordinate values are ignored.
+//
+// An error is returned for other geometries, and for rings that have less than
+// four coordinates or aren't closed.
+func PolygonFromGeometry(g geom.T) (*s2.Polygon, error) {
 	var (
 		polygon *s2.Polygon
 		err     error
@@ -323,6 +1000,9 @@ func loopSliceFromPolygon(p *geom.Polygon) ([]*s2.Loop, error) {
 				"last coordinate not same as first for polygon: %+v", p.FlatCoords())
 		}
 
+		// All loops are made CCW, including holes, since PolygonFromLoops
+		// determines which loops are holes from their nesting.
+		//
 		// S2 specifies that the orientation of the polygons should be CCW.
 		// However there is no restriction on the orientation in WKB (or
 		// GeoJSON). To get the correct orientation we assume that the polygons
@@ -331,6 +1011,12 @@ func loopSliceFromPolygon(p *geom.Polygon) ([]*s2.Loop, error) {
 		reverse := isClockwise(r)
 		l := loopFromRing(r, reverse)
 
+		// Dropping repeated vertices can leave too few for a loop, and s2
+		// treats a loop with one vertex in the southern hemisphere as full
+		if l.NumVertices() < 3 {
+			return nil, errors.New("can't convert ring with less than 3 distinct points")
+		}
+
 		// Since our clockwise check was approximate, we check the cap and
 		// reverse if needed.
 		if l.CapBound().Radius().Degrees() > 90 {
@@ -371,8 +1057,10 @@ func loopFromRing(r *geom.LinearRing, reverse bool) *s2.Loop {
 	// In WKB, the last coordinate is repeated for a ring to form a closed loop.
 	// For s2 the points aren't allowed to repeat and the loop is assumed to be
 	// closed, so we skip the last point.
+	// Repeated vertices are dropped too, since they make degenerate edges that
+	// fail validation.
 	n := r.NumCoords()
-	pts := make([]s2.Point, n-1)
+	pts := make([]s2.Point, 0, n-1)
 
 	for i := 0; i < n-1; i++ {
 		var c geom.Coord
@@ -382,10 +1070,65 @@ func loopFromRing(r *geom.LinearRing, reverse bool) *s2.Loop {
 			c = r.Coord(i)
 		}
 
-		pts[i] = pointFromCoord(c)
+		pts = append(pts, pointFromCoord(c))
+	}
+
+	return s2.LoopFromPoints(dropRepeatedPoints(pts))
+}
+
+// dropRepeatedPoints removes the points of a loop that are equal to the point
+// before them, including the last point if it is equal to the first. pts is
+// modified in place.
+func dropRepeatedPoints(pts []s2.Point) []s2.Point {
+	n := 0
+	for _, p := range pts {
+		if n == 0 || pts[n-1] != p {
+			pts[n] = p
+			n++
+		}
+	}
+	if n > 1 && pts[0] == pts[n-1] {
+		n--
+	}
+
+	return pts[:n]
+}
+
+// validateCoord returns an error wrapping ErrInvalidCoordinate if loc doesn't
+// have a finite longitude and a latitude between -90 and 90 degrees. Values
+// after the latitude aren't checked.
+func validateCoord(loc geom.Coord) error {
+	if len(loc) < 2 {
+		return fmt.Errorf("%w: needs longitude and latitude, got %d values",
+			ErrInvalidCoordinate, len(loc))
 	}
 
-	return s2.LoopFromPoints(pts)
+	lon, lat := loc.X(), loc.Y()
+	switch {
+	case math.IsNaN(lon) || math.IsInf(lon, 0):
+		return fmt.Errorf("%w: longitude is %v", ErrInvalidCoordinate, lon)
+	case math.IsNaN(lat) || math.IsInf(lat, 0):
+		return fmt.Errorf("%w: latitude is %v", ErrInvalidCoordinate, lat)
+	case math.Abs(lat) > 90:
+		return fmt.Errorf("%w: latitude %v out of range", ErrInvalidCoordinate, lat)
+	}
+
+	return nil
+}
+
+// validatePoint returns an error wrapping ErrInvalidCoordinate if p has
+// non-finite components or is the zero vector, which has no direction.
+func validatePoint(p s2.Point) error {
+	for _, v := range []float64{p.X, p.Y, p.Z} {
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return fmt.Errorf("%w: point %v isn't finite", ErrInvalidCoordinate, p)
+		}
+	}
+	if p.Norm2() == 0 {
+		return fmt.Errorf("%w: zero point", ErrInvalidCoordinate)
+	}
+
+	return nil
 }
 
 // From github.com/dgraph-io/dgraph
@@ -396,6 +1139,13 @@ func pointFromCoord(r geom.Coord) s2.Point {
 	return s2.PointFromLatLng(ll)
 }
 
+// coordFromPoint converts an s2 Point to a geom Coord, the inverse of
+// pointFromCoord.
+func coordFromPoint(p s2.Point) geom.Coord {
+	ll := s2.LatLngFromPoint(p)
+	return geom.Coord{ll.Lng.Degrees(), ll.Lat.Degrees()}
+}
+
 // String method for type Location.
 func (l Location) String() string {
 	ret := "<Location>"
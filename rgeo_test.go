@@ -17,15 +17,23 @@ package rgeo
 
 import (
 	"bytes"
+	"encoding/binary"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"math"
 	"math/rand"
+	"runtime"
 	"testing"
+	"time"
 
 	"github.com/go-test/deep"
+	"github.com/golang/geo/r3"
+	"github.com/golang/geo/s1"
+	"github.com/golang/geo/s2"
 	"github.com/twpayne/go-geom"
 	"github.com/twpayne/go-geom/encoding/geojson"
+	"github.com/twpayne/go-geom/encoding/wkb"
 )
 
 var testdata = []struct {
@@ -270,6 +278,1159 @@ func TestReverseGeocode(t *testing.T) {
 	}
 }
 
+func TestReverseGeocode_InvalidCoordinate(t *testing.T) {
+	r, err := New(testDataset(t, distanceTestData))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testdata := []struct {
+		name string
+		in   geom.Coord
+		err  string
+	}{
+		{"NaN longitude", geom.Coord{math.NaN(), 0}, "invalid coordinate: longitude is NaN"},
+		{"NaN latitude", geom.Coord{0, math.NaN()}, "invalid coordinate: latitude is NaN"},
+		{"Inf longitude", geom.Coord{math.Inf(1), 0}, "invalid coordinate: longitude is +Inf"},
+		{"Inf latitude", geom.Coord{0, math.Inf(-1)}, "invalid coordinate: latitude is -Inf"},
+		{"Latitude too large", geom.Coord{0, 90.5}, "invalid coordinate: latitude 90.5 out of range"},
+		{"Latitude too small", geom.Coord{0, -91}, "invalid coordinate: latitude -91 out of range"},
+		{"Too short", geom.Coord{0}, "invalid coordinate: needs longitude and latitude, got 1 values"},
+		{"Empty", nil, "invalid coordinate: needs longitude and latitude, got 0 values"},
+	}
+
+	for _, test := range testdata {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			for name, lookup := range map[string]func(geom.Coord) (Location, error){
+				"ReverseGeocode":         r.ReverseGeocode,
+				"ReverseGeocodeSnapping": r.ReverseGeocodeSnapping,
+			} {
+				_, err := lookup(test.in)
+				if !errors.Is(err, ErrInvalidCoordinate) || err.Error() != test.err {
+					t.Errorf("%s: expected error: %s\n got: %v\n", name, test.err, err)
+				}
+			}
+		})
+	}
+
+	// All other lookups return the same error
+	for name, lookup := range map[string]func(geom.Coord) error{
+		"ReverseGeocodeAll": func(c geom.Coord) error { _, err := r.ReverseGeocodeAll(c); return err },
+		"ReverseGeocodeResolve": func(c geom.Coord) error {
+			_, _, err := r.ReverseGeocodeResolve(c, []Level{LevelCountry})
+			return err
+		},
+		"CountryAt":          func(c geom.Coord) error { _, err := r.CountryAt(c); return err },
+		"Hierarchy":          func(c geom.Coord) error { _, err := r.Hierarchy(c); return err },
+		"NearestFeature":     func(c geom.Coord) error { _, _, _, err := r.NearestFeature(c); return err },
+		"NearestCities":      func(c geom.Coord) error { _, _, err := r.NearestCities(c, 1); return err },
+		"CitiesWithinRadius": func(c geom.Coord) error { _, err := r.CitiesWithinRadius(c, 1); return err },
+		"SnapCandidates":     func(c geom.Coord) error { _, err := r.SnapCandidates(c, 1); return err },
+		"SnapToBorder":       func(c geom.Coord) error { _, _, err := r.SnapToBorder(c); return err },
+		"OnBorder":           func(c geom.Coord) error { _, _, err := r.OnBorder(c, 1); return err },
+		"IsInternationalWaters": func(c geom.Coord) error {
+			_, err := r.IsInternationalWaters(c)
+			return err
+		},
+	} {
+		for _, c := range []geom.Coord{{0}, {0, 91}} {
+			if err := lookup(c); !errors.Is(err, ErrInvalidCoordinate) {
+				t.Errorf("%s(%v): expected error: %s\n got: %v\n", name, c, ErrInvalidCoordinate, err)
+			}
+		}
+	}
+}
+
+func TestReverseGeocode_Altitude(t *testing.T) {
+	r, err := New(testDataset(t, distanceTestData))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := Location{CountryCode3: "TST", City: "In"}
+	for _, in := range []geom.Coord{
+		{0, 0, 8848},
+		{0, 0, -400, 1.5},
+		{0, 0, math.NaN()},
+	} {
+		loc, err := r.ReverseGeocode(in)
+		if err != nil {
+			t.Errorf("%v: %s", in, err)
+		} else if diff := deep.Equal(expected, loc); diff != nil {
+			t.Errorf("%v: %v", in, diff)
+		}
+	}
+
+	p := geom.NewPointFlat(geom.XYZ, []float64{0, 0, 100})
+	loc, err := r.ReverseGeocodeGeomPoint(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := deep.Equal(expected, loc); diff != nil {
+		t.Error(diff)
+	}
+}
+
+func TestReverseGeocodeWKT(t *testing.T) {
+	r, err := New(testDataset(t, `{
+		"type":"FeatureCollection",
+			"features":[
+				{"type":"Feature",
+				"properties":{"ISO_A3_EH":"TST"},
+				"geometry":{"type":"Polygon",
+					"coordinates":[[[0,52],[1,52],[1,53],[0,53],[0,52]]]}}
+			]
+		}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testdata := []struct {
+		name     string
+		in       string
+		err      string
+		expected Location
+	}{
+		{
+			name:     "in",
+			in:       "POINT(0.5 52.5)",
+			expected: Location{CountryCode3: "TST"},
+		},
+		{
+			name: "out",
+			in:   "POINT(0 0)",
+			err:  ErrLocationNotFound.Error(),
+		},
+		{
+			name: "Not a point",
+			in:   "LINESTRING(0 0, 1 1)",
+			err:  "invalid coordinate: needs Point, got *geom.LineString",
+		},
+		{
+			name: "Empty point",
+			in:   "POINT EMPTY",
+			err:  "invalid coordinate: empty Point",
+		},
+	}
+
+	for _, test := range testdata {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			result, err := r.ReverseGeocodeWKT(test.in)
+			if (err == nil && test.err != "") ||
+				(err != nil && err.Error() != test.err) {
+				t.Errorf("expected error: %s\n got: %s\n", test.err, err)
+			}
+			if diff := deep.Equal(test.expected, result); diff != nil {
+				t.Error(diff)
+			}
+		})
+	}
+
+	if _, err := r.ReverseGeocodeWKT("POINT(0.5"); !errors.Is(err, ErrInvalidCoordinate) {
+		t.Errorf("expected error: %s\n got: %v\n", ErrInvalidCoordinate, err)
+	}
+
+	b, err := wkb.Marshal(geom.NewPointFlat(geom.XY, []float64{0.5, 52.5}),
+		binary.LittleEndian)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := r.ReverseGeocodeWKB(b)
+	if err != nil {
+		t.Error(err)
+	}
+	if diff := deep.Equal(Location{CountryCode3: "TST"}, result); diff != nil {
+		t.Error(diff)
+	}
+}
+
+func TestReverseGeocodePoint(t *testing.T) {
+	r, err := New(testDataset(t, distanceTestData))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	loc, err := r.ReverseGeocodePoint(s2.PointFromLatLng(s2.LatLngFromDegrees(0.5, 2)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := deep.Equal(Location{CountryCode3: "TST"}, loc); diff != nil {
+		t.Error(diff)
+	}
+
+	if _, err := r.ReverseGeocodePoint(s2.PointFromLatLng(s2.LatLngFromDegrees(10, 2))); err != ErrLocationNotFound {
+		t.Errorf("expected error: %s\n got: %s\n", ErrLocationNotFound, err)
+	}
+
+	loc, err = r.ReverseGeocodeGeomPoint(geom.NewPointFlat(geom.XY, []float64{2, 0.5}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := deep.Equal(Location{CountryCode3: "TST"}, loc); diff != nil {
+		t.Error(diff)
+	}
+
+	for _, p := range []*geom.Point{nil, geom.NewPointEmpty(geom.XY)} {
+		if _, err := r.ReverseGeocodeGeomPoint(p); err == nil || err.Error() != "invalid coordinate: empty Point" {
+			t.Errorf("expected error: invalid coordinate: empty Point\n got: %v\n", err)
+		}
+	}
+
+	for _, p := range []s2.Point{{}, {Vector: r3.Vector{X: math.NaN()}}} {
+		if _, err := r.ReverseGeocodePoint(p); !errors.Is(err, ErrInvalidCoordinate) {
+			t.Errorf("expected error: %s\n got: %v\n", ErrInvalidCoordinate, err)
+		}
+	}
+}
+
+func TestReverseGeocodeWithSource(t *testing.T) {
+	big := testDataset(t, `{"type":"FeatureCollection","features":[
+		{"type":"Feature","properties":{"ISO_A3_EH":"BIG"},
+		 "geometry":{"type":"Polygon",
+		  "coordinates":[[[0,0],[10,0],[10,10],[0,10],[0,0]]]}}]}`)
+	small := testDataset(t, `{"type":"FeatureCollection","features":[
+		{"type":"Feature","properties":{"name_conve":"Small"},
+		 "geometry":{"type":"Polygon",
+		  "coordinates":[[[1,1],[2,1],[2,2],[1,2],[1,1]]]}}]}`)
+	unnamed := testDataset(t, `{"type":"FeatureCollection","features":[
+		{"type":"Feature","properties":{"name":"Unnamed"},
+		 "geometry":{"type":"Polygon",
+		  "coordinates":[[[5,5],[6,5],[6,6],[5,6],[5,5]]]}}]}`)
+
+	r, err := New(DatasetNamed("big", big), DatasetNamed("small", small),
+		unnamed)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testdata := []struct {
+		name     string
+		in       []float64
+		source   string
+		err      error
+		expected Location
+	}{
+		{
+			name:     "big",
+			in:       []float64{8, 8},
+			source:   "big",
+			expected: Location{CountryCode3: "BIG"},
+		},
+		{
+			name:     "both",
+			in:       []float64{1.5, 1.5},
+			source:   "big,small",
+			expected: Location{CountryCode3: "BIG", City: "Small"},
+		},
+		{
+			name:     "unnamed",
+			in:       []float64{5.5, 5.5},
+			source:   "big",
+			expected: Location{CountryCode3: "BIG", Province: "Unnamed"},
+		},
+		{
+			name: "out",
+			in:   []float64{20, 20},
+			err:  ErrLocationNotFound,
+		},
+	}
+
+	for _, test := range testdata {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			result, source, err := r.ReverseGeocodeWithSource(test.in)
+			if err != test.err {
+				t.Errorf("expected error: %s\n got: %s\n", test.err, err)
+			}
+			if diff := deep.Equal(test.expected, result); diff != nil {
+				t.Error(diff)
+			}
+			if diff := deep.Equal(test.source, source); diff != nil {
+				t.Error(diff)
+			}
+		})
+	}
+}
+
+func TestReverseGeocodeAll(t *testing.T) {
+	// Simplified Baarle: a Belgian enclave in the Netherlands, with a Dutch
+	// counter-enclave in it. The datasets are added largest first.
+	r, err := New(testDataset(t, `{"type":"FeatureCollection","features":[
+		{"type":"Feature","properties":{"ISO_A3_EH":"NLD","name":"Noord-Brabant"},
+		 "geometry":{"type":"Polygon",
+		  "coordinates":[[[4.8,51.4],[5.1,51.4],[5.1,51.5],[4.8,51.5],[4.8,51.4]]]}},
+		{"type":"Feature","properties":{"ISO_A3_EH":"BEL","name":"Baarle-Hertog"},
+		 "geometry":{"type":"Polygon",
+		  "coordinates":[[[4.92,51.435],[4.94,51.435],[4.94,51.45],[4.92,51.45],[4.92,51.435]]]}},
+		{"type":"Feature","properties":{"ISO_A3_EH":"NLD","name":"Baarle-Nassau"},
+		 "geometry":{"type":"Polygon",
+		  "coordinates":[[[4.928,51.44],[4.932,51.44],[4.932,51.443],[4.928,51.443],[4.928,51.44]]]}}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		in       geom.Coord
+		err      error
+		expected []string
+	}{
+		{"Counter-enclave", geom.Coord{4.93, 51.441}, nil,
+			[]string{"Baarle-Nassau", "Baarle-Hertog", "Noord-Brabant"}},
+		{"Enclave", geom.Coord{4.925, 51.441}, nil,
+			[]string{"Baarle-Hertog", "Noord-Brabant"}},
+		{"Outside", geom.Coord{4.85, 51.45}, nil, []string{"Noord-Brabant"}},
+		{"Ocean", geom.Coord{0, 0}, ErrLocationNotFound, nil},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			locs, err := r.ReverseGeocodeAll(test.in)
+			if err != test.err {
+				t.Errorf("expected error: %s\n got: %s\n", test.err, err)
+			}
+			var names []string
+			for _, l := range locs {
+				names = append(names, l.Province)
+			}
+			if diff := deep.Equal(test.expected, names); diff != nil {
+				t.Error(diff)
+			}
+		})
+	}
+}
+
+func TestFieldAt(t *testing.T) {
+	r, err := New(testDataset(t, distanceTestData))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		method   func(geom.Coord) (string, error)
+		in       geom.Coord
+		err      error
+		expected string
+	}{
+		{"CountryCode3", r.CountryCode3At, geom.Coord{0, 0}, nil, "TST"},
+		{"City", r.CityAt, geom.Coord{0, 0}, nil, "In"},
+		{"No city", r.CityAt, geom.Coord{2, 0}, nil, ""},
+		{"Country", r.CountryAt, geom.Coord{0, 0}, nil, ""},
+		{"Province", r.ProvinceAt, geom.Coord{0, 0}, nil, ""},
+		{"Ocean", r.CountryCode3At, geom.Coord{10, 10}, ErrLocationNotFound, ""},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			result, err := test.method(test.in)
+			if err != test.err {
+				t.Errorf("expected error: %s\n got: %s\n", test.err, err)
+			}
+			if diff := deep.Equal(test.expected, result); diff != nil {
+				t.Error(diff)
+			}
+		})
+	}
+}
+
+func TestReverseGeocodeSnappingWithin(t *testing.T) {
+	r, err := New(testDataset(t, distanceTestData))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// About 11km north of the country
+	coord := geom.Coord{2, 1.1}
+
+	if _, err := r.ReverseGeocodeSnappingWithin(coord, 5); err != ErrLocationNotFound {
+		t.Errorf("expected error: %s\n got: %s\n", ErrLocationNotFound, err)
+	}
+
+	loc, err := r.ReverseGeocodeSnappingWithin(coord, 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := deep.Equal(Location{CountryCode3: "TST"}, loc); diff != nil {
+		t.Error(diff)
+	}
+
+	// The default snapping distance of 5km is unchanged
+	if _, err := r.ReverseGeocodeSnapping(coord); err != ErrLocationNotFound {
+		t.Errorf("expected error: %s\n got: %s\n", ErrLocationNotFound, err)
+	}
+}
+
+func TestSetSnappingDistanceAfterBuild(t *testing.T) {
+	r, err := New(testDataset(t, distanceTestData))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Build()
+	r.RequireBuild()
+	r.EnableCache(10, 3)
+	index := r.index
+
+	// About 11km north of the country
+	coord := geom.Coord{2, 1.1}
+
+	for _, test := range []struct {
+		distance float64
+		err      error
+	}{{5, ErrLocationNotFound}, {20, nil}, {10, ErrLocationNotFound}, {12, nil}} {
+		r.SetSnappingDistanceEarth(test.distance)
+		if _, err := r.ReverseGeocodeSnapping(coord); err != test.err {
+			t.Errorf("%gkm: expected error: %s\n got: %v\n", test.distance, test.err, err)
+		}
+	}
+
+	if r.index != index || !r.index.IsFresh() {
+		t.Error("expected the index to be kept")
+	}
+}
+
+func TestReverseGeocodeSnappingScored(t *testing.T) {
+	r, err := New(testDataset(t, distanceTestData))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.SetSnappingDistanceEarth(20)
+
+	tests := []struct {
+		name     string
+		in       geom.Coord
+		err      error
+		expected Location
+		score    float64
+	}{
+		{"Inside", geom.Coord{2, 0}, nil, Location{CountryCode3: "TST"}, 1},
+		// About 11km of 20km, the edge bulges north a bit
+		{"Snapped", geom.Coord{2, 1.1}, nil, Location{CountryCode3: "TST"}, 0.449},
+		// About 19.96km west of the western edge, which is a meridian
+		{"Just inside", geom.Coord{-1.1795, 0}, nil, Location{CountryCode3: "TST"}, 0.002},
+		{"Out of range", geom.Coord{2, 1.3}, ErrLocationNotFound, Location{}, 0},
+		{"Just outside", geom.Coord{-1.1805, 0}, ErrLocationNotFound, Location{}, 0},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			loc, score, err := r.ReverseGeocodeSnappingScored(test.in)
+			if err != test.err {
+				t.Errorf("expected error: %s\n got: %s\n", test.err, err)
+			}
+			if diff := deep.Equal(test.expected, loc); diff != nil {
+				t.Error(diff)
+			}
+			if math.Abs(score-test.score) > 0.001 {
+				t.Errorf("expected score %g, got %g", test.score, score)
+			}
+		})
+	}
+}
+
+func TestReverseGeocodeSnappingAdaptive(t *testing.T) {
+	r, err := New(testDataset(t, distanceTestData))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		in       geom.Coord
+		base     float64
+		max      float64
+		err      bool
+		expected Location
+	}{
+		{"In country", geom.Coord{2, 0.5}, 1, 1, false, Location{CountryCode3: "TST"}},
+		{"Expanded", geom.Coord{2, 1.1}, 1, 100, false, Location{CountryCode3: "TST"}},
+		{"Capped at max", geom.Coord{2, 1.1}, 1, 10, true, Location{}},
+		{"Bad margins", geom.Coord{2, 1.1}, 0, 10, true, Location{}},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			result, err := r.ReverseGeocodeSnappingAdaptive(test.in, test.base, test.max)
+			if (err != nil) != test.err {
+				t.Errorf("unexpected error: %v", err)
+			}
+			if diff := deep.Equal(test.expected, result); diff != nil {
+				t.Error(diff)
+			}
+		})
+	}
+
+	if _, err := r.ReverseGeocodeSnappingAdaptive(geom.Coord{2, 1.1}, 1, 10); err != ErrLocationNotFound {
+		t.Errorf("expected error: %s\n got: %s\n", ErrLocationNotFound, err)
+	}
+}
+
+func TestMergeFunc(t *testing.T) {
+	r, err := New(testDataset(t, `{"type":"FeatureCollection","features":[
+		{"type":"Feature","properties":{"ADMIN":"Alpha","ISO_A3_EH":"AAA"},
+		 "geometry":{"type":"Polygon",
+		  "coordinates":[[[0,0],[2,0],[2,2],[0,2],[0,0]]]}},
+		{"type":"Feature","properties":{"ISO_A3_EH":"BBB"},
+		 "geometry":{"type":"Polygon",
+		  "coordinates":[[[1,0],[3,0],[3,2],[1,2],[1,0]]]}}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	coord := geom.Coord{1.5, 1}
+
+	loc, err := r.ReverseGeocode(coord)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := deep.Equal(Location{Country: "Alpha", CountryCode3: "AAA"}, loc); diff != nil {
+		t.Error(diff)
+	}
+
+	// Last wins
+	r.MergeFunc = func(dst, src Location) Location {
+		return MergeFirstNonEmpty(src, dst)
+	}
+	loc, err = r.ReverseGeocode(coord)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := deep.Equal(Location{Country: "Alpha", CountryCode3: "BBB"}, loc); diff != nil {
+		t.Error(diff)
+	}
+
+	// The per-field lookups merge the same way
+	code, err := r.CountryCode3At(coord)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code != "BBB" {
+		t.Errorf("expected BBB, got %q", code)
+	}
+}
+
+func TestPopulation(t *testing.T) {
+	r, err := New(testDataset(t, `{"type":"FeatureCollection","features":[
+		{"type":"Feature","properties":{"ISO_A3_EH":"TST","POP_EST":1000.0},
+		 "geometry":{"type":"Polygon",
+		  "coordinates":[[[0,0],[4,0],[4,4],[0,4],[0,0]]]}},
+		{"type":"Feature","properties":{"name_conve":"City","pop_max":50},
+		 "geometry":{"type":"Polygon",
+		  "coordinates":[[[1,1],[2,1],[2,2],[1,2],[1,1]]]}},
+		{"type":"Feature","properties":{"name_conve":"Other","pop_max":"20"},
+		 "geometry":{"type":"Polygon",
+		  "coordinates":[[[6,1],[7,1],[7,2],[6,2],[6,1]]]}},
+		{"type":"Feature","properties":{"name_conve":"Village","pop_max":20},
+		 "geometry":{"type":"Polygon",
+		  "coordinates":[[[8,1],[9,1],[9,2],[8,2],[8,1]]]}}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		in       geom.Coord
+		expected Location
+	}{
+		{
+			name:     "Country",
+			in:       geom.Coord{3, 3},
+			expected: Location{CountryCode3: "TST", Population: 1000},
+		},
+		{
+			name: "Country and city",
+			in:   geom.Coord{1.5, 1.5},
+			expected: Location{
+				CountryCode3:   "TST",
+				City:           "City",
+				Population:     1000,
+				CityPopulation: 50,
+			},
+		},
+		{
+			name:     "Not a number",
+			in:       geom.Coord{6.5, 1.5},
+			expected: Location{City: "Other"},
+		},
+		{
+			name:     "City",
+			in:       geom.Coord{8.5, 1.5},
+			expected: Location{City: "Village", CityPopulation: 20},
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			result, err := r.ReverseGeocode(test.in)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := deep.Equal(test.expected, result); diff != nil {
+				t.Error(diff)
+			}
+		})
+	}
+}
+
+func TestSovereignty(t *testing.T) {
+	// EEZs are large, one is clockwise and one counter-clockwise to check
+	// that both are oriented correctly.
+	r, err := New(testDataset(t, `{"type":"FeatureCollection","features":[
+		{"type":"Feature","properties":{"ISO_A3_EH":"TST"},
+		 "geometry":{"type":"Polygon",
+		  "coordinates":[[[0,0],[4,0],[4,4],[0,4],[0,0]]]}},
+		{"type":"Feature","properties":{"SOVEREIGN1":"Testland"},
+		 "geometry":{"type":"Polygon",
+		  "coordinates":[[[4,-40],[4,40],[80,40],[80,-40],[4,-40]]]}},
+		{"type":"Feature","properties":{"SOVEREIGN1":"Otherland"},
+		 "geometry":{"type":"Polygon",
+		  "coordinates":[[[-80,-40],[0,-40],[0,40],[-80,40],[-80,-40]]]}},
+		{"type":"Feature","properties":{"ADMIN":"Dependency","SOVEREIGNT":"Otherland"},
+		 "geometry":{"type":"Polygon",
+		  "coordinates":[[[-100,0],[-90,0],[-90,10],[-100,10],[-100,0]]]}}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		in       geom.Coord
+		err      error
+		expected Location
+	}{
+		{"Land", geom.Coord{2, 2}, nil, Location{CountryCode3: "TST"}},
+		{"Clockwise EEZ", geom.Coord{40, 0}, nil, Location{Sovereignty: "Testland"}},
+		{"Counter-clockwise EEZ", geom.Coord{-40, 0}, nil, Location{Sovereignty: "Otherland"}},
+		{"Dependency", geom.Coord{-95, 5}, nil, Location{Country: "Dependency", Sovereignty: "Otherland"}},
+		{"High seas", geom.Coord{120, 0}, ErrLocationNotFound, Location{}},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			result, err := r.ReverseGeocode(test.in)
+			if err != test.err {
+				t.Errorf("expected error: %s\n got: %s\n", test.err, err)
+			}
+			if diff := deep.Equal(test.expected, result); diff != nil {
+				t.Error(diff)
+			}
+		})
+	}
+}
+
+func TestCountryCodeFromProvince(t *testing.T) {
+	// Germany has no ISO codes, France has its own and Nowhere's code is
+	// not an ISO 3166-2 code
+	r, err := New(testDataset(t, `{"type":"FeatureCollection","features":[
+		{"type":"Feature","properties":{"ADMIN":"Germany"},
+		 "geometry":{"type":"Polygon",
+		  "coordinates":[[[0,0],[4,0],[4,4],[0,4],[0,0]]]}},
+		{"type":"Feature","properties":{"ADMIN":"France","ISO_A2_EH":"FR"},
+		 "geometry":{"type":"Polygon",
+		  "coordinates":[[[-4,0],[0,0],[0,4],[-4,4],[-4,0]]]}},
+		{"type":"Feature","properties":{"name":"Bayern","iso_3166_2":"de-BY"},
+		 "geometry":{"type":"Polygon",
+		  "coordinates":[[[1,1],[2,1],[2,2],[1,2],[1,1]]]}},
+		{"type":"Feature","properties":{"name":"Nowhere","iso_3166_2":"-99"},
+		 "geometry":{"type":"Polygon",
+		  "coordinates":[[[2,2],[3,2],[3,3],[2,3],[2,2]]]}},
+		{"type":"Feature","properties":{"name":"Grand Est","iso_3166_2":"DE-XX"},
+		 "geometry":{"type":"Polygon",
+		  "coordinates":[[[-2,1],[-1,1],[-1,2],[-2,2],[-2,1]]]}}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		in       geom.Coord
+		expected Location
+	}{
+		{"Province", geom.Coord{1.5, 1.5},
+			Location{Country: "Germany", CountryCode2: "DE", Province: "Bayern", ProvinceCode: "de-BY"}},
+		{"Invalid code", geom.Coord{2.5, 2.5},
+			Location{Country: "Germany", Province: "Nowhere", ProvinceCode: "-99"}},
+		{"Country code", geom.Coord{-1.5, 1.5},
+			Location{Country: "France", CountryCode2: "FR", Province: "Grand Est", ProvinceCode: "DE-XX"}},
+		{"No province", geom.Coord{3.5, 0.5}, Location{Country: "Germany"}},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			result, err := r.ReverseGeocode(test.in)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := deep.Equal(test.expected, result); diff != nil {
+				t.Error(diff)
+			}
+		})
+	}
+}
+
+func TestCountryCodeNumeric(t *testing.T) {
+	// Natural Earth has ISO_N3_EH like ISO_A3_EH, other datasets may only have
+	// ISO_N3 or iso_n3. The city is combined with the country.
+	r, err := New(testDataset(t, `{"type":"FeatureCollection","features":[
+		{"type":"Feature","properties":{"ISO_A3_EH":"USA","ISO_N3_EH":"840","ISO_N3":"-99"},
+		 "geometry":{"type":"Polygon",
+		  "coordinates":[[[0,0],[4,0],[4,4],[0,4],[0,0]]]}},
+		{"type":"Feature","properties":{"ISO_A3_EH":"CAN","ISO_N3":"124"},
+		 "geometry":{"type":"Polygon",
+		  "coordinates":[[[0,4],[4,4],[4,8],[0,8],[0,4]]]}},
+		{"type":"Feature","properties":{"ISO_A3_EH":"MEX","iso_n3":"484"},
+		 "geometry":{"type":"Polygon",
+		  "coordinates":[[[0,-4],[4,-4],[4,0],[0,0],[0,-4]]]}},
+		{"type":"Feature","properties":{"name_conve":"Town"},
+		 "geometry":{"type":"Polygon",
+		  "coordinates":[[[1,1],[2,1],[2,2],[1,2],[1,1]]]}}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		in       geom.Coord
+		expected Location
+	}{
+		{"ISO_N3_EH", geom.Coord{3, 3}, Location{CountryCode3: "USA", CountryCodeNumeric: "840"}},
+		{"ISO_N3", geom.Coord{3, 5}, Location{CountryCode3: "CAN", CountryCodeNumeric: "124"}},
+		{"iso_n3", geom.Coord{3, -3}, Location{CountryCode3: "MEX", CountryCodeNumeric: "484"}},
+		{"City", geom.Coord{1.5, 1.5}, Location{CountryCode3: "USA", CountryCodeNumeric: "840", City: "Town"}},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			result, err := r.ReverseGeocode(test.in)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := deep.Equal(test.expected, result); diff != nil {
+				t.Error(diff)
+			}
+		})
+	}
+
+	b, err := json.Marshal(Location{CountryCodeNumeric: "840"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := `{"country_code_numeric":"840"}`; string(b) != expected {
+		t.Errorf("expected %s, got %s", expected, b)
+	}
+}
+
+func TestLocationComplete(t *testing.T) {
+	// Fields set by Provinces10 and Cities10
+	fields := Location{Country: "x", CountryCode3: "x", Province: "x", City: "x"}
+
+	tests := []struct {
+		name     string
+		in       Location
+		expected bool
+	}{
+		{"Empty", Location{}, false},
+		{"No city", Location{Country: "A", CountryCode3: "AAA", Province: "P"}, false},
+		{"All set fields", Location{Country: "A", CountryCode3: "AAA", Province: "P", City: "C"}, true},
+		{"Disputed", Location{Country: "A", CountryCode3: "AAA", Province: "P", City: "C", Disputed: true}, true},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			if result := test.in.complete(fields); result != test.expected {
+				t.Errorf("expected: %v\n got: %v\n", test.expected, result)
+			}
+		})
+	}
+}
+
+func TestDisputed(t *testing.T) {
+	// The disputed region overlaps the country and a city, and comes last
+	data := `{"type":"FeatureCollection","features":[
+		{"type":"Feature","properties":{"ADMIN":"Alpha","SOVEREIGNT":"Alpha"},
+		 "geometry":{"type":"Polygon",
+		  "coordinates":[[[0,0],[4,0],[4,4],[0,4],[0,0]]]}},
+		{"type":"Feature","properties":{"name_conve":"Town"},
+		 "geometry":{"type":"Polygon",
+		  "coordinates":[[[1,1],[2,1],[2,2],[1,2],[1,1]]]}},
+		{"type":"Feature","properties":{"ADMIN":"Beta","SOVEREIGNT":"Gamma",
+		  "NOTE_BRK":"Claimed by Alpha"},
+		 "geometry":{"type":"Polygon",
+		  "coordinates":[[[1,0],[3,0],[3,3],[1,3],[1,0]]]}}]}`
+
+	sovereignFirst := func(dst, src Location) Location {
+		dst.Sovereignty = firstNonEmpty(dst.Sovereignty, src.Sovereignty)
+		return dst
+	}
+
+	tests := []struct {
+		name     string
+		in       geom.Coord
+		merge    func(dst, src Location) Location
+		expected Location
+	}{
+		{"Undisputed", geom.Coord{0.5, 0.5}, nil,
+			Location{Country: "Alpha", Sovereignty: "Alpha"}},
+		{"Disputed", geom.Coord{2.5, 2.5}, nil,
+			Location{Country: "Alpha", Sovereignty: "Alpha", Disputed: true}},
+		{"City and disputed", geom.Coord{1.5, 1.5}, nil,
+			Location{Country: "Alpha", Sovereignty: "Alpha", City: "Town", Disputed: true}},
+		{"MergeFunc", geom.Coord{2.5, 2.5}, sovereignFirst,
+			Location{Sovereignty: "Alpha", Disputed: true}},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			r, err := New(testDataset(t, data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			r.MergeFunc = test.merge
+
+			result, err := r.ReverseGeocode(test.in)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := deep.Equal(test.expected, result); diff != nil {
+				t.Error(diff)
+			}
+		})
+	}
+}
+
+func TestSource(t *testing.T) {
+	withSource := func(d Dataset, source string) Dataset {
+		return func() []Feature {
+			features := d()
+			for i := range features {
+				features[i].Location.Source = source
+			}
+			return features
+		}
+	}
+
+	r, err := New(
+		withSource(testDataset(t, distanceTestData), "cities.geojson"),
+		withSource(testDataset(t, `{"type":"FeatureCollection","features":[
+			{"type":"Feature","properties":{"ADMIN":"Test"},
+			 "geometry":{"type":"Polygon",
+			  "coordinates":[[[-1,-1],[1,-1],[1,1],[-1,1],[-1,-1]]]}}]}`), "countries.geojson"),
+		// Only adds its source once the other fields are complete
+		withSource(testDataset(t, `{"type":"FeatureCollection","features":[
+			{"type":"Feature","properties":{"ADMIN":"Other"},
+			 "geometry":{"type":"Polygon",
+			  "coordinates":[[[0,-1],[1,-1],[1,1],[0,1],[0,-1]]]}}]}`), "other.geojson"),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		in       geom.Coord
+		expected Location
+	}{
+		{"One source", geom.Coord{2, 0}, Location{CountryCode3: "TST", Source: "cities.geojson"}},
+		{"Repeated source", geom.Coord{0.55, 0}, Location{
+			CountryCode3: "TST",
+			City:         "Near",
+			Country:      "Test",
+			Source:       "cities.geojson, countries.geojson, other.geojson",
+		}},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			result, err := r.ReverseGeocode(test.in)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := deep.Equal(test.expected, result); diff != nil {
+				t.Error(diff)
+			}
+		})
+	}
+}
+
+func TestRequireBuild(t *testing.T) {
+	r, err := New(testDataset(t, distanceTestData))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.RequireBuild()
+
+	coord := geom.Coord{0, 0}
+	if _, err := r.ReverseGeocode(coord); err != ErrIndexNotBuilt {
+		t.Errorf("expected error: %s\n got: %s\n", ErrIndexNotBuilt, err)
+	}
+	if _, err := r.ReverseGeocodeSnapping(coord); err != ErrIndexNotBuilt {
+		t.Errorf("expected error: %s\n got: %s\n", ErrIndexNotBuilt, err)
+	}
+	if _, err := r.CitiesWithinRadius(coord, 1); err != ErrIndexNotBuilt {
+		t.Errorf("expected error: %s\n got: %s\n", ErrIndexNotBuilt, err)
+	}
+	if _, err := r.Neighbors("TST"); err != ErrIndexNotBuilt {
+		t.Errorf("expected error: %s\n got: %s\n", ErrIndexNotBuilt, err)
+	}
+	if _, err := r.CoverageInCell(s2.CellIDFromFace(0)); err != ErrIndexNotBuilt {
+		t.Errorf("expected error: %s\n got: %s\n", ErrIndexNotBuilt, err)
+	}
+
+	r.Build()
+	if _, err := r.ReverseGeocode(coord); err != nil {
+		t.Error(err)
+	}
+
+	// Adding data requires another build
+	r.AddDataset(testDataset(t, lookupTestData))
+	if _, err := r.ReverseGeocode(coord); err != ErrIndexNotBuilt {
+		t.Errorf("expected error: %s\n got: %s\n", ErrIndexNotBuilt, err)
+	}
+	r.Build()
+	if _, err := r.ReverseGeocode(coord); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestCompact(t *testing.T) {
+	heapAlloc := func() uint64 {
+		runtime.GC()
+		var m runtime.MemStats
+		runtime.ReadMemStats(&m)
+		return m.HeapAlloc
+	}
+
+	// The features returned by the dataset must not be retained by New
+	freed := make(chan struct{})
+	d := testDataset(t, distanceTestData)
+	r, err := New(func() []Feature {
+		features := append([]Feature(nil), d()...)
+		runtime.SetFinalizer(&features[0], func(*Feature) { close(freed) })
+		return features
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.EnableCache(100000, 4)
+
+	for i := 0; i < 100000; i++ {
+		coord := geom.Coord{float64(i%1000) / 1000, float64(i/1000) / 1000}
+		if _, err := r.ReverseGeocodeSnapping(coord); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	before := heapAlloc()
+	r.Compact()
+	after := heapAlloc()
+
+	// Each cache entry takes more than 100 bytes
+	if before < after+10<<20 {
+		t.Errorf("expected Compact to free at least 10 MiB, heap went from %d to %d", before, after)
+	}
+	if len(r.cache.entries) != 0 {
+		t.Errorf("expected empty cache, got %d entries", len(r.cache.entries))
+	}
+
+	select {
+	case <-freed:
+	case <-time.After(time.Second):
+		t.Error("expected features to be freed")
+	}
+
+	loc, err := r.ReverseGeocode(geom.Coord{0, 0})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := deep.Equal(Location{CountryCode3: "TST", City: "In"}, loc); diff != nil {
+		t.Error(diff)
+	}
+}
+
+func TestClone(t *testing.T) {
+	r, err := New(testDataset(t, distanceTestData))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.EnableCache(10, 3)
+
+	// About 11km north of the country
+	coord := geom.Coord{2, 1.1}
+	if _, err := r.ReverseGeocodeSnapping(coord); err != ErrLocationNotFound {
+		t.Errorf("expected error: %s\n got: %s\n", ErrLocationNotFound, err)
+	}
+
+	c := r.Clone()
+	c.SetSnappingDistanceEarth(20)
+	loc, err := c.ReverseGeocodeSnapping(coord)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := deep.Equal(Location{CountryCode3: "TST"}, loc); diff != nil {
+		t.Error(diff)
+	}
+
+	if r.SnappingDistanceKM() != 5 {
+		t.Errorf("expected snapping distance of original to be 5km, got %f", r.SnappingDistanceKM())
+	}
+	if _, err := r.ReverseGeocodeSnapping(coord); err != ErrLocationNotFound {
+		t.Errorf("expected error: %s\n got: %s\n", ErrLocationNotFound, err)
+	}
+
+	// Adding data to the clone doesn't change the original
+	c.AddDataset(testDataset(t, `{"type":"FeatureCollection","features":[
+		{"type":"Feature","properties":{"ISO_A3_EH":"NEW"},
+		 "geometry":{"type":"Polygon",
+		  "coordinates":[[[10,10],[11,10],[11,11],[10,11],[10,10]]]}}]}`))
+	if _, err := c.ReverseGeocode(geom.Coord{10.5, 10.5}); err != nil {
+		t.Error(err)
+	}
+	if _, err := r.ReverseGeocode(geom.Coord{10.5, 10.5}); err != ErrLocationNotFound {
+		t.Errorf("expected error: %s\n got: %s\n", ErrLocationNotFound, err)
+	}
+}
+
+func TestWithAlwaysSnap(t *testing.T) {
+	r, err := New(testDataset(t, distanceTestData))
+	if err != nil {
+		t.Fatal(err)
+	}
+	snap := r.WithAlwaysSnap(20)
+
+	// About 11km north of the country
+	coord := geom.Coord{2, 1.1}
+
+	loc, err := snap.ReverseGeocode(coord)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := deep.Equal(Location{CountryCode3: "TST"}, loc); diff != nil {
+		t.Error(diff)
+	}
+	if _, err := snap.ReverseGeocode(geom.Coord{2, 1.3}); err != ErrLocationNotFound {
+		t.Errorf("expected error: %s\n got: %s\n", ErrLocationNotFound, err)
+	}
+	if code, err := snap.CountryCode3At(coord); err != nil || code != "TST" {
+		t.Errorf("expected TST, got %q, %v", code, err)
+	}
+
+	// The original is unchanged
+	if _, err := r.ReverseGeocode(coord); err != ErrLocationNotFound {
+		t.Errorf("expected error: %s\n got: %s\n", ErrLocationNotFound, err)
+	}
+	if d := r.SnappingDistanceKM(); d != 5 {
+		t.Errorf("expected snapping distance of 5km, got %g", d)
+	}
+}
+
+func TestShapeIndex(t *testing.T) {
+	r, err := New(testDataset(t, distanceTestData))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.RequireBuild()
+
+	index := r.ShapeIndex()
+	if !index.IsFresh() {
+		t.Error("expected index to be built")
+	}
+
+	// Shapes containing the point have a distance of zero
+	opts := s2.NewClosestEdgeQueryOptions().
+		DistanceLimit(s1.ChordAngle(0).Successor())
+	query := s2.NewClosestEdgeQuery(index, opts)
+	target := s2.NewMinDistanceToPointTarget(pointFromCoord(geom.Coord{0, 0}))
+
+	var result []Location
+	for _, res := range query.FindEdges(target) {
+		loc, ok := r.ShapeLocation(res.ShapeID())
+		if !ok {
+			t.Errorf("no location for shape %d", res.ShapeID())
+		}
+		result = append(result, loc)
+	}
+
+	expected := []Location{{CountryCode3: "TST"}, {City: "In"}}
+	if diff := deep.Equal(expected, result); diff != nil {
+		t.Error(diff)
+	}
+
+	if _, ok := r.ShapeLocation(100); ok {
+		t.Error("expected no location for unknown shape")
+	}
+}
+
+func TestReverseGeocodeAuto(t *testing.T) {
+	r, err := New(testDataset(t, `{"type":"FeatureCollection","features":[
+		{"type":"Feature","properties":{"ISO_A3_EH":"FAR"},
+		 "geometry":{"type":"Polygon",
+		  "coordinates":[[[100,10],[101,10],[101,11],[100,11],[100,10]]]}},
+		{"type":"Feature","properties":{"ISO_A3_EH":"EUR"},
+		 "geometry":{"type":"Polygon",
+		  "coordinates":[[[10,50],[11,50],[11,51],[10,51],[10,50]]]}}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		a, b     float64
+		swapped  bool
+		err      error
+		expected Location
+	}{
+		{"Lon first", 100.5, 10.5, false, nil, Location{CountryCode3: "FAR"}},
+		{"Lat first", 10.5, 100.5, true, nil, Location{CountryCode3: "FAR"}},
+		{"Ambiguous", 10.5, 50.5, false, nil, Location{CountryCode3: "EUR"}},
+		{"Ambiguous swapped", 50.5, 10.5, true, nil, Location{CountryCode3: "EUR"}},
+		{"Ocean", 0, 0, false, ErrLocationNotFound, Location{}},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			result, swapped, err := r.ReverseGeocodeAuto(test.a, test.b)
+			if err != test.err {
+				t.Errorf("expected error: %s\n got: %s\n", test.err, err)
+			}
+			if swapped != test.swapped {
+				t.Errorf("expected swapped to be %t", test.swapped)
+			}
+			if diff := deep.Equal(test.expected, result); diff != nil {
+				t.Error(diff)
+			}
+		})
+	}
+
+	for _, test := range []struct {
+		a, b         float64
+		lonFirst, ok bool
+	}{
+		{100, 10, true, true},
+		{10, -100, false, true},
+		{10, 50, true, false},
+		{100, 120, true, false},
+	} {
+		lonFirst, ok := DetectCoordOrder(test.a, test.b)
+		if lonFirst != test.lonFirst || ok != test.ok {
+			t.Errorf("DetectCoordOrder(%f, %f): expected %t, %t got %t, %t",
+				test.a, test.b, test.lonFirst, test.ok, lonFirst, ok)
+		}
+	}
+}
+
 func TestReverseGeocode_Countries(t *testing.T) {
 	if testing.Short() {
 		t.Skip("Skipping integration test (countries) for short mode")
@@ -289,18 +1450,154 @@ func TestReverseGeocode_Countries(t *testing.T) {
 			test.expected.ProvinceCode = ""
 			test.expected.City = ""
 
-			t.Run(test.name, func(t *testing.T) {
-				t.Parallel()
+			t.Run(test.name, func(t *testing.T) {
+				t.Parallel()
+
+				result, err := r.ReverseGeocode(test.in)
+				if err != test.err {
+					t.Errorf("expected error: %s\n got: %s\n", test.err, err)
+				}
+				if diff := deep.Equal(test.expected, result); diff != nil {
+					t.Error(diff)
+				}
+			})
+		}
+	}
+}
+
+func TestReverseGeocode_Enclaves(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test (enclaves) for short mode")
+	}
+
+	r, err := New(Countries10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// South Africa and Italy have holes for these, so only one is found
+	for _, test := range []struct {
+		in       geom.Coord
+		expected string
+	}{
+		{geom.Coord{27.48, -29.31}, "LSO"}, // Maseru
+		{geom.Coord{12.4534, 41.9029}, "VAT"},
+		{geom.Coord{12.45, 43.94}, "SMR"},
+	} {
+		locs, err := r.ReverseGeocodeAll(test.in)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(locs) != 1 || locs[0].CountryCode3 != test.expected {
+			t.Errorf("%v: expected only %s, got %v", test.in, test.expected, locs)
+		}
+	}
+}
+
+func TestReverseGeocode_InlandWaters(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test (inland waters) in short mode")
+	}
+
+	// Natural Earth splits lakes along the borders, but treats the Caspian
+	// as sea, so it isn't part of any country, see "Data inaccuracy" in the
+	// README
+	tests := []struct {
+		name     string
+		in       geom.Coord
+		err      error
+		expected string
+	}{
+		{"Caspian Sea", geom.Coord{51, 42}, ErrLocationNotFound, ""},
+		{"Northern Caspian Sea", geom.Coord{50, 45.5}, ErrLocationNotFound, ""},
+		{"Southern Caspian Sea", geom.Coord{51.5, 38}, ErrLocationNotFound, ""},
+		{"Dead Sea", geom.Coord{35.55, 31.5}, nil, "JOR"},
+		{"Lake Superior", geom.Coord{-87.5, 47.5}, nil, "USA"},
+		{"Lake Michigan", geom.Coord{-87, 44}, nil, "USA"},
+		{"Lake Huron", geom.Coord{-82.5, 44.8}, nil, "USA"},
+		{"Lake Erie", geom.Coord{-81.5, 42.2}, nil, "CAN"},
+		{"Lake Ontario south", geom.Coord{-77.8, 43.6}, nil, "USA"},
+		{"Lake Ontario north", geom.Coord{-79, 43.8}, nil, "CAN"},
+	}
+
+	for _, dataset := range []Dataset{Countries110, Countries10, Provinces10} {
+		r, err := New(dataset)
+		if err != nil {
+			t.Fatal(err)
+		}
 
+		for _, test := range tests {
+			test := test
+			t.Run(test.name, func(t *testing.T) {
 				result, err := r.ReverseGeocode(test.in)
 				if err != test.err {
-					t.Errorf("expected error: %s\n got: %s\n", test.err, err)
+					t.Errorf("expected error: %s\n got: %v\n", test.err, err)
 				}
-				if diff := deep.Equal(test.expected, result); diff != nil {
-					t.Error(diff)
+				if result.CountryCode3 != test.expected {
+					t.Errorf("expected %q, got %q", test.expected, result.CountryCode3)
+				}
+
+				// The default snapping distance doesn't reach the shore
+				if _, err := r.ReverseGeocodeSnapping(test.in); err != test.err {
+					t.Errorf("snapping: expected error: %s\n got: %v\n", test.err, err)
 				}
 			})
 		}
+
+		// A larger radius finds the countries on the shore
+		locs, err := r.ReverseGeocodeWithUncertainty(geom.Coord{51, 42}, 250)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var codes []string
+		for _, l := range locs {
+			codes = append(codes, l.CountryCode3)
+		}
+		if diff := deep.Equal([]string{"KAZ", "TKM", "AZE", "RUS"}, codes); diff != nil {
+			t.Error(diff)
+		}
+	}
+}
+
+func TestReverseGeocode_Holes(t *testing.T) {
+	// The holes are in either orientation, GeoJSON requires it to be
+	// opposite to the outer ring but not all files follow that.
+	r, err := New(testDataset(t, `{"type":"FeatureCollection","features":[
+		{"type":"Feature","properties":{"ISO_A3_EH":"AAA"},
+		 "geometry":{"type":"Polygon","coordinates":[
+		  [[0,0],[4,0],[4,4],[0,4],[0,0]],
+		  [[1,1],[1,2],[2,2],[2,1],[1,1]]]}},
+		{"type":"Feature","properties":{"ISO_A3_EH":"BBB"},
+		 "geometry":{"type":"Polygon","coordinates":[
+		  [[10,0],[14,0],[14,4],[10,4],[10,0]],
+		  [[11,1],[12,1],[12,2],[11,2],[11,1]]]}}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		in       geom.Coord
+		err      error
+		expected Location
+	}{
+		{"Outside hole", geom.Coord{3, 3}, nil, Location{CountryCode3: "AAA"}},
+		{"Hole", geom.Coord{1.5, 1.5}, ErrLocationNotFound, Location{}},
+		{"Outside same orientation hole", geom.Coord{13, 3}, nil, Location{CountryCode3: "BBB"}},
+		{"Same orientation hole", geom.Coord{11.5, 1.5}, ErrLocationNotFound, Location{}},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			result, err := r.ReverseGeocode(test.in)
+			if err != test.err {
+				t.Errorf("expected error: %s\n got: %s\n", test.err, err)
+			}
+			if diff := deep.Equal(test.expected, result); diff != nil {
+				t.Error(diff)
+			}
+		})
 	}
 }
 
@@ -372,7 +1669,7 @@ func TestLoadGeoJSON_BadData(t *testing.T) {
 			in: `{"type":"FeatureCollection","features":
 				  [{"type":"Feature","geometry":
 				    {"type":"Point","coordinates":[0,0]}}]}`,
-			err: "bad polygon in geometry: needs Polygon or MultiPolygon",
+			err: "bad polygon in geometry of feature 0: needs Polygon or MultiPolygon",
 		},
 		{
 			name: "Small polygon",
@@ -380,7 +1677,23 @@ func TestLoadGeoJSON_BadData(t *testing.T) {
 				  [{"type":"Feature","geometry":
 				    {"type":"Polygon",
 					 "coordinates":[[[1,2],[3,4],[1,2]]]}}]}`,
-			err: "bad polygon in geometry: can't convert ring with less than 4 points",
+			err: "bad polygon in geometry of feature 0: can't convert ring with less than 4 points",
+		},
+		{
+			name: "Repeated points",
+			in: `{"type":"FeatureCollection","features":
+				  [{"type":"Feature","geometry":
+				    {"type":"Polygon",
+					 "coordinates":[[[1,2],[1,2],[3,4],[3,4],[1,2]]]}}]}`,
+			err: "bad polygon in geometry of feature 0: can't convert ring with less than 3 distinct points",
+		},
+		{
+			name: "Single point",
+			in: `{"type":"FeatureCollection","features":
+				  [{"type":"Feature","geometry":
+				    {"type":"Polygon",
+					 "coordinates":[[[1,-2],[1,-2],[1,-2],[1,-2]]]}}]}`,
+			err: "bad polygon in geometry of feature 0: can't convert ring with less than 3 distinct points",
 		},
 		{
 			name: "No repeated end",
@@ -388,7 +1701,7 @@ func TestLoadGeoJSON_BadData(t *testing.T) {
 				  [{"type":"Feature","geometry":
 				    {"type":"Polygon",
 					 "coordinates":[[[1,2],[3,4],[5,6],[7,8]]]}}]}`,
-			err: "bad polygon in geometry: " +
+			err: "bad polygon in geometry of feature 0: " +
 				"last coordinate not same as first for polygon: [1 2 3 4 5 6 7 8]",
 		},
 		{
@@ -397,7 +1710,7 @@ func TestLoadGeoJSON_BadData(t *testing.T) {
 				  [{"type":"Feature","geometry":
 				    {"type":"MultiPolygon",
 					 "coordinates":[[[[1,2],[3,4],[5,6],[7,8]]]]}}]}`,
-			err: "bad polygon in geometry: " +
+			err: "bad polygon in geometry of feature 0: " +
 				"last coordinate not same as first for polygon: [1 2 3 4 5 6 7 8]",
 		},
 	}
@@ -407,13 +1720,46 @@ func TestLoadGeoJSON_BadData(t *testing.T) {
 			var fc geojson.FeatureCollection
 			if err := json.Unmarshal([]byte(test.in), &fc); err != nil {
 				t.Errorf("decode GeoJSON: %s", err)
-			} else if _, err := LoadGeoJSON(fc); err != nil && err.Error() != test.err {
+			} else if _, err := LoadGeoJSON(fc); err == nil || err.Error() != test.err {
 				t.Errorf("expected error: %s\n got: %s\n", test.err, err)
 			}
 		})
 	}
 }
 
+func TestPolygonFromGeometry(t *testing.T) {
+	ccw := []geom.Coord{{0, 0}, {1, 0}, {1, 1}, {0, 1}, {0, 0}}
+	cw := []geom.Coord{{0, 0}, {0, 1}, {1, 1}, {1, 0}, {0, 0}}
+	inside := s2.PointFromLatLng(s2.LatLngFromDegrees(0.5, 0.5))
+	outside := s2.PointFromLatLng(s2.LatLngFromDegrees(10, 10))
+
+	tests := []struct {
+		name string
+		in   geom.T
+	}{
+		{"Counter-clockwise", geom.Must(geom.NewPolygon(geom.XY).SetCoords([][]geom.Coord{ccw}))},
+		{"Clockwise", geom.Must(geom.NewPolygon(geom.XY).SetCoords([][]geom.Coord{cw}))},
+		{"MultiPolygon", geom.Must(geom.NewMultiPolygon(geom.XY).SetCoords([][][]geom.Coord{{cw}}))},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			p, err := PolygonFromGeometry(test.in)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !p.ContainsPoint(inside) || p.ContainsPoint(outside) {
+				t.Error("expected polygon to contain only the inside point")
+			}
+		})
+	}
+
+	if _, err := PolygonFromGeometry(geom.NewPoint(geom.XY)); err == nil {
+		t.Error("expected error for a point")
+	}
+}
+
 func TestString(t *testing.T) {
 	tests := []struct {
 		name     string
@@ -474,6 +1820,202 @@ func TestString(t *testing.T) {
 	}
 }
 
+func TestMarshalText(t *testing.T) {
+	tests := []struct {
+		name     string
+		in       Location
+		text     string
+		expected Location
+	}{
+		{
+			name: "London",
+			in: Location{
+				Country:      "United Kingdom",
+				CountryCode3: "GBR",
+				Province:     "Tower Hamlets",
+				City:         "London",
+			},
+			text: "GBR|Tower Hamlets|London",
+			expected: Location{
+				CountryCode3: "GBR",
+				Province:     "Tower Hamlets",
+				City:         "London",
+			},
+		},
+		{
+			name:     "Country only",
+			in:       Location{Country: "Algeria", CountryCode3: "DZA"},
+			text:     "DZA||",
+			expected: Location{CountryCode3: "DZA"},
+		},
+		{
+			name:     "Empty",
+			in:       Location{},
+			text:     "||",
+			expected: Location{},
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			text, err := test.in.MarshalText()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := deep.Equal(test.text, string(text)); diff != nil {
+				t.Error(diff)
+			}
+
+			var result Location
+			if err := result.UnmarshalText(text); err != nil {
+				t.Fatal(err)
+			}
+			if diff := deep.Equal(test.expected, result); diff != nil {
+				t.Error(diff)
+			}
+		})
+	}
+
+	if _, err := (Location{City: "a|b"}).MarshalText(); err == nil {
+		t.Error("expected error for field containing separator")
+	}
+
+	var l Location
+	if err := l.UnmarshalText([]byte("GBR|London")); err == nil {
+		t.Error("expected error for wrong number of fields")
+	}
+}
+
+func TestLocationDiff(t *testing.T) {
+	a := Location{Country: "Alpha", CountryCode3: "AAA", Province: "West"}
+	b := Location{Country: "Alpha", CountryCode3: "AAA", Population: 100}
+
+	if !a.Equal(a) || a.Equal(b) {
+		t.Error("expected a to only equal itself")
+	}
+	if diff := a.Diff(a); diff != nil {
+		t.Errorf("expected no differences, got %v", diff)
+	}
+
+	expected := map[string]string{
+		"Province":   `"West" -> ""`,
+		"Population": "0 -> 100",
+	}
+	if diff := deep.Equal(expected, a.Diff(b)); diff != nil {
+		t.Error(diff)
+	}
+}
+
+func TestMarshalJSON(t *testing.T) {
+	in := map[Location]int{{CountryCode3: "GBR", City: "London"}: 1}
+
+	buf, err := json.Marshal(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := deep.Equal(`{"GBR||London":1}`, string(buf)); diff != nil {
+		t.Error(diff)
+	}
+
+	loc := Location{Country: "United Kingdom", City: "London"}
+	buf, err = json.Marshal(loc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := deep.Equal(`{"country":"United Kingdom","city":"London"}`,
+		string(buf)); diff != nil {
+		t.Error(diff)
+	}
+
+	var result Location
+	if err := json.Unmarshal(buf, &result); err != nil {
+		t.Fatal(err)
+	}
+	if diff := deep.Equal(loc, result); diff != nil {
+		t.Error(diff)
+	}
+}
+
+func TestDisplayName(t *testing.T) {
+	tests := []struct {
+		name     string
+		in       Location
+		expected string
+	}{
+		{"Empty", Location{}, ""},
+		{"City", Location{Country: "Japan", Province: "Hokkaidō", City: "Sapporo"},
+			"Sapporo, Hokkaidō, Japan"},
+		{"Long name", Location{CountryLong: "Republic of Austria", City: "Vienna"},
+			"Vienna, Republic of Austria"},
+		{"Province", Location{Country: "Japan", Province: "Hokkaidō"}, "Hokkaidō, Japan"},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			if result := test.in.DisplayName(); result != test.expected {
+				t.Errorf("expected %q, got %q", test.expected, result)
+			}
+		})
+	}
+
+	buf, err := json.Marshal(Location{Country: "United Kingdom", City: "London"}.WithDisplayName())
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `{"country":"United Kingdom","city":"London","display_name":"London, United Kingdom"}`
+	if diff := deep.Equal(expected, string(buf)); diff != nil {
+		t.Error(diff)
+	}
+
+	// Empty Locations stay empty objects
+	if buf, err = json.Marshal(Location{}.WithDisplayName()); err != nil {
+		t.Fatal(err)
+	} else if string(buf) != "{}" {
+		t.Errorf("expected {}, got %s", buf)
+	}
+}
+
+func TestRedact(t *testing.T) {
+	loc := Location{
+		Country:        "Alpha",
+		CountryCode3:   "AAA",
+		Province:       "West",
+		ProvinceCode:   "AA-W",
+		City:           "Alpha City",
+		Population:     1000,
+		CityPopulation: 10,
+		Source:         "Cities10",
+	}
+
+	tests := []struct {
+		level    Level
+		expected Location
+	}{
+		{LevelCountry, Location{Country: "Alpha", CountryCode3: "AAA", Population: 1000, Source: "Cities10"}},
+		{LevelProvince, Location{
+			Country:      "Alpha",
+			CountryCode3: "AAA",
+			Province:     "West",
+			ProvinceCode: "AA-W",
+			Population:   1000,
+			Source:       "Cities10",
+		}},
+		{LevelCity, loc},
+		{LevelNearest, loc},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.level.String(), func(t *testing.T) {
+			if diff := deep.Equal(test.expected, loc.Redact(test.level)); diff != nil {
+				t.Error(diff)
+			}
+		})
+	}
+}
+
 func ExampleRgeo_ReverseGeocode() {
 	r, err := New(Countries110)
 	if err != nil {
@@ -502,6 +2044,49 @@ func ExampleRgeo_ReverseGeocode() {
 	// Northern Europe
 }
 
+func ExampleNewDefault() {
+	r, err := NewDefault()
+	if err != nil {
+		// Handle error
+	}
+
+	loc, err := r.ReverseGeocode([]float64{2.35, 48.86})
+	if err != nil {
+		// Handle error
+	}
+
+	fmt.Println(loc)
+	// Output: <Location> France (FRA), Europe
+}
+
+func ExampleNewGeneric() {
+	// rectangle returns a polygon between the given longitudes and latitudes
+	rectangle := func(lon0, lat0, lon1, lat1 float64) *s2.Polygon {
+		var points []s2.Point
+		for _, ll := range [][2]float64{{lat0, lon0}, {lat0, lon1}, {lat1, lon1}, {lat1, lon0}} {
+			points = append(points, s2.PointFromLatLng(s2.LatLngFromDegrees(ll[0], ll[1])))
+		}
+		return s2.PolygonFromLoops([]*s2.Loop{s2.LoopFromPoints(points)})
+	}
+
+	r, err := NewGeneric([]Feature{
+		{Location: Location{Region: "Tundra"}, Polygon: rectangle(150, 60, 170, 75)},
+		{Location: Location{Region: "Taiga"}, Polygon: rectangle(150, 50, 170, 60)},
+		{Location: Location{Province: "Kamchatka"}, Polygon: rectangle(155, 51, 163, 62)},
+	})
+	if err != nil {
+		// Handle error
+	}
+
+	loc, err := r.ReverseGeocode([]float64{158, 55})
+	if err != nil {
+		// Handle error
+	}
+
+	fmt.Println(loc.Region, loc.Province)
+	// Output: Taiga Kamchatka
+}
+
 func ExampleRgeo_ReverseGeocode_city() {
 	r, err := New(Provinces10, Cities10)
 	if err != nil {
@@ -600,6 +2185,55 @@ func TestTable(t *testing.T) {
 	}
 }
 
+func TestReverseGeocodeSnapping_Coast(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test (coast) in short mode")
+	}
+
+	r, err := New(Countries10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Points a few kilometers offshore, which the ClosestEdgeQuery of older s2
+	// versions missed
+	tests := []struct {
+		name     string
+		in       geom.Coord
+		expected string
+	}{
+		{"Denmark", geom.Coord{11.2728, 54.9837}, "DNK"},
+		{"Norway", geom.Coord{7.5155, 63.1033}, "NOR"},
+		{"Canada", geom.Coord{-134.3369, 69.7586}, "CAN"},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := r.ReverseGeocode(test.in); err != ErrLocationNotFound {
+				t.Errorf("expected error: %s\n got: %v\n", ErrLocationNotFound, err)
+			}
+
+			loc, err := r.ReverseGeocodeSnapping(test.in)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if loc.CountryCode3 != test.expected {
+				t.Errorf("expected %q, got %q", test.expected, loc.CountryCode3)
+			}
+
+			// The same polygon is the closest snapping candidate
+			candidates, err := r.SnapCandidates(test.in, 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if candidates[0].Location.CountryCode3 != loc.CountryCode3 {
+				t.Errorf("expected candidate %q, got %q", loc.CountryCode3, candidates[0].Location.CountryCode3)
+			}
+		})
+	}
+}
+
 func BenchmarkReverseGeocode_110(b *testing.B) {
 	r, err := New(Countries110)
 	if err != nil {
@@ -664,6 +2298,78 @@ func BenchmarkReverseGeocode_City10(b *testing.B) {
 	}
 }
 
+func BenchmarkReverseGeocodeSnapping(b *testing.B) {
+	r, err := New(Countries10, Provinces10, Cities10)
+	if err != nil {
+		b.Error(err)
+	}
+	r.Build()
+
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		_, _ = r.ReverseGeocodeSnapping([]float64{
+			(rand.Float64() * 360) - 180,
+			(rand.Float64() * 180) - 90,
+		})
+	}
+}
+
+func BenchmarkReverseGeocode_Layered(b *testing.B) {
+	full := Location{
+		Country:      "Test",
+		CountryLong:  "Republic of Test",
+		CountryCode2: "TS",
+		CountryCode3: "TST",
+		Sovereignty:  "Test",
+		Continent:    "Test",
+		Region:       "Test",
+		SubRegion:    "Test",
+		Province:     "Test",
+		ProvinceCode: "TS-T",
+		City:         "Test",
+		Population:   1,
+	}
+	poly := rectangle(0, 0, 1, 1)
+
+	// The first layer sets all fields, so the others can be skipped
+	features := make([]Feature, 200)
+	for i := range features {
+		features[i] = Feature{Location: Location{Province: fmt.Sprint(i)}, Polygon: poly}
+	}
+	features[0].Location = full
+
+	r, err := NewGeneric(features)
+	if err != nil {
+		b.Fatal(err)
+	}
+	r.Build()
+
+	for name, merge := range map[string]func(dst, src Location) Location{
+		"early exit": nil,
+		"all shapes": MergeFirstNonEmpty,
+	} {
+		merge := merge
+		b.Run(name, func(b *testing.B) {
+			r.MergeFunc = merge
+			for i := 0; i < b.N; i++ {
+				if loc, _ := r.ReverseGeocode(geom.Coord{0.5, 0.5}); loc != full {
+					b.Fatalf("unexpected location %v", loc)
+				}
+			}
+		})
+	}
+}
+
+// rectangle returns a polygon between the given longitudes and latitudes.
+func rectangle(lon0, lat0, lon1, lat1 float64) *s2.Polygon {
+	var points []s2.Point
+	for _, ll := range [][2]float64{{lat0, lon0}, {lat0, lon1}, {lat1, lon1}, {lat1, lon0}} {
+		points = append(points, s2.PointFromLatLng(s2.LatLngFromDegrees(ll[0], ll[1])))
+	}
+	return s2.PolygonFromLoops([]*s2.Loop{s2.LoopFromPoints(points)})
+}
+
 func BenchmarkNew(b *testing.B) {
 	for i := 0; i < b.N; i++ {
 		_, err := New(Countries110)
@@ -682,16 +2388,14 @@ func BenchmarkNewCity(b *testing.B) {
 	}
 }
 
-func testDataset(t *testing.T, text string) Dataset {
+func testDataset(t testing.TB, text string) Dataset {
 	var fc geojson.FeatureCollection
 	if err := json.NewDecoder(bytes.NewReader([]byte(text))).Decode(&fc); err != nil {
 		t.Fatalf("decode GeoJSON: %s", err)
 	}
-	features, err := LoadGeoJSON(fc)
+	d, err := DatasetFromGeoJSON(fc)
 	if err != nil {
 		t.Fatalf("features from GeoJSON: %s", err)
 	}
-	return func() []Feature {
-		return features
-	}
+	return d
 }
@@ -0,0 +1,106 @@
+/*
+Package rgeohttp serves rgeo reverse geocoding over HTTP.
+
+	r, err := rgeo.New(rgeo.Countries110)
+	if err != nil {
+		// Handle error
+	}
+	log.Fatal(http.ListenAndServe(":8080", rgeohttp.Handler(r)))
+
+The handler serves GET /reverse?lat=..&lon=.. and responds with the Location as
+JSON. If no location is found the status is 404, and for invalid coordinates
+it is 400. With snap=1 the coordinate is looked up with ReverseGeocodeSnapping
+instead of ReverseGeocode, and with display=1 the Location has an additional
+"display_name", see Location.WithDisplayName.
+
+GET /stats responds with the estimated memory use of the index, as returned
+by MemoryStats.
+*/
+package rgeohttp
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/sams96/rgeo"
+	"github.com/twpayne/go-geom"
+)
+
+// Handler returns an http.Handler serving reverse geocoding lookups on r at
+// /reverse, and the memory statistics of r at /stats.
+func Handler(r *rgeo.Rgeo) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/reverse", func(w http.ResponseWriter, req *http.Request) {
+		reverse(w, req, r)
+	})
+	mux.HandleFunc("/stats", func(w http.ResponseWriter, req *http.Request) {
+		if !allowGet(w, req) {
+			return
+		}
+		writeJSON(w, http.StatusOK, r.MemoryStats())
+	})
+	return mux
+}
+
+func reverse(w http.ResponseWriter, req *http.Request, r *rgeo.Rgeo) {
+	if !allowGet(w, req) {
+		return
+	}
+
+	q := req.URL.Query()
+	lat, err := strconv.ParseFloat(q.Get("lat"), 64)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("bad lat: %w", err))
+		return
+	}
+	lon, err := strconv.ParseFloat(q.Get("lon"), 64)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("bad lon: %w", err))
+		return
+	}
+
+	lookup := r.ReverseGeocode
+	if q.Get("snap") == "1" {
+		lookup = r.ReverseGeocodeSnapping
+	}
+
+	loc, err := lookup(geom.Coord{lon, lat})
+	switch {
+	case errors.Is(err, rgeo.ErrLocationNotFound):
+		writeError(w, http.StatusNotFound, err)
+	case errors.Is(err, rgeo.ErrInvalidCoordinate):
+		writeError(w, http.StatusBadRequest, err)
+	case err != nil:
+		writeError(w, http.StatusInternalServerError, err)
+	case q.Get("display") == "1":
+		writeJSON(w, http.StatusOK, loc.WithDisplayName())
+	default:
+		writeJSON(w, http.StatusOK, loc)
+	}
+}
+
+// allowGet writes an error and returns false if req isn't a GET request.
+func allowGet(w http.ResponseWriter, req *http.Request) bool {
+	if req.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		writeError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+		return false
+	}
+	return true
+}
+
+// writeError writes err as a JSON object with an "error" field.
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, struct {
+		Error string `json:"error"`
+	}{err.Error()})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,137 @@
+package rgeohttp
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-test/deep"
+	"github.com/sams96/rgeo"
+	"github.com/twpayne/go-geom/encoding/geojson"
+)
+
+func TestHandler(t *testing.T) {
+	var fc geojson.FeatureCollection
+	if err := json.Unmarshal([]byte(`{"type":"FeatureCollection","features":[
+		{"type":"Feature","properties":{"ADMIN":"Test","ISO_A3_EH":"TST"},
+		 "geometry":{"type":"Polygon",
+		  "coordinates":[[[0,0],[1,0],[1,1],[0,1],[0,0]]]}}]}`), &fc); err != nil {
+		t.Fatalf("decode GeoJSON: %s", err)
+	}
+	d, err := rgeo.DatasetFromGeoJSON(fc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := rgeo.New(d)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := httptest.NewServer(Handler(r))
+	defer srv.Close()
+
+	tests := []struct {
+		name     string
+		method   string
+		query    string
+		status   int
+		expected rgeo.Location
+		display  string
+	}{
+		{"Found", http.MethodGet, "lat=0.5&lon=0.5", http.StatusOK,
+			rgeo.Location{Country: "Test", CountryCode3: "TST"}, ""},
+		{"Display name", http.MethodGet, "lat=0.5&lon=0.5&display=1", http.StatusOK,
+			rgeo.Location{Country: "Test", CountryCode3: "TST"}, "Test"},
+		{"Not found", http.MethodGet, "lat=0.5&lon=1.01", http.StatusNotFound, rgeo.Location{}, ""},
+		{"Snapping", http.MethodGet, "lat=0.5&lon=1.01&snap=1", http.StatusOK,
+			rgeo.Location{Country: "Test", CountryCode3: "TST"}, ""},
+		{"Missing lon", http.MethodGet, "lat=0.5", http.StatusBadRequest, rgeo.Location{}, ""},
+		{"Bad lat", http.MethodGet, "lat=x&lon=0.5", http.StatusBadRequest, rgeo.Location{}, ""},
+		{"Lat out of range", http.MethodGet, "lat=91&lon=0.5", http.StatusBadRequest, rgeo.Location{}, ""},
+		{"Wrong method", http.MethodPost, "lat=0.5&lon=0.5", http.StatusMethodNotAllowed, rgeo.Location{}, ""},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			req, err := http.NewRequest(test.method, srv.URL+"/reverse?"+test.query, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+
+			if resp.StatusCode != test.status {
+				t.Errorf("expected status %d, got %d", test.status, resp.StatusCode)
+			}
+			if resp.StatusCode != http.StatusOK {
+				return
+			}
+
+			body, err := io.ReadAll(resp.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var loc rgeo.Location
+			if err := json.Unmarshal(body, &loc); err != nil {
+				t.Fatal(err)
+			}
+			if diff := deep.Equal(test.expected, loc); diff != nil {
+				t.Error(diff)
+			}
+
+			var display struct {
+				DisplayName string `json:"display_name"`
+			}
+			if err := json.Unmarshal(body, &display); err != nil {
+				t.Fatal(err)
+			}
+			if display.DisplayName != test.display {
+				t.Errorf("expected display name %q, got %q", test.display, display.DisplayName)
+			}
+		})
+	}
+}
+
+func TestHandlerStats(t *testing.T) {
+	var fc geojson.FeatureCollection
+	if err := json.Unmarshal([]byte(`{"type":"FeatureCollection","features":[
+		{"type":"Feature","properties":{"ADMIN":"Test","ISO_A3_EH":"TST"},
+		 "geometry":{"type":"Polygon",
+		  "coordinates":[[[0,0],[1,0],[1,1],[0,1],[0,0]]]}}]}`), &fc); err != nil {
+		t.Fatalf("decode GeoJSON: %s", err)
+	}
+	d, err := rgeo.DatasetFromGeoJSON(fc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := rgeo.New(d)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := httptest.NewServer(Handler(r))
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/stats")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, resp.StatusCode)
+	}
+	var stats rgeo.IndexMemStats
+	if err := json.NewDecoder(resp.Body).Decode(&stats); err != nil {
+		t.Fatal(err)
+	}
+	if diff := deep.Equal(r.MemoryStats(), stats); diff != nil {
+		t.Error(diff)
+	}
+}
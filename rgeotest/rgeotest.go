@@ -0,0 +1,95 @@
+/*
+Package rgeotest provides a small synthetic dataset and random coordinates for
+testing and benchmarking code that uses rgeo, without having to load the much
+larger included datasets.
+
+	r, err := rgeo.New(rgeotest.TestData)
+	if err != nil {
+		// Handle error
+	}
+	loc, err := r.ReverseGeocode(geom.Coord{2.5, 2.5})
+	// loc is Alpha City, South, Alpha (AAA)
+*/
+package rgeotest
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/rand"
+
+	"github.com/sams96/rgeo"
+	"github.com/twpayne/go-geom"
+	"github.com/twpayne/go-geom/encoding/geojson"
+)
+
+// testData is the GeoJSON of TestData, with the properties used by the Natural
+// Earth datasets.
+const testData = `{"type":"FeatureCollection","features":[
+	{"type":"Feature","properties":{"ADMIN":"Alpha","FORMAL_EN":"Republic of Alpha",
+	  "ISO_A2_EH":"AA","ISO_A3_EH":"AAA","CONTINENT":"Europe","REGION_UN":"Europe",
+	  "SUBREGION":"Western Europe","POP_EST":1000000},
+	 "geometry":{"type":"Polygon",
+	  "coordinates":[[[0,0],[10,0],[10,10],[0,10],[0,0]]]}},
+	{"type":"Feature","properties":{"ADMIN":"Beta","FORMAL_EN":"Kingdom of Beta",
+	  "ISO_A2_EH":"BB","ISO_A3_EH":"BBB","CONTINENT":"Europe","REGION_UN":"Europe",
+	  "SUBREGION":"Western Europe","POP_EST":500000},
+	 "geometry":{"type":"Polygon",
+	  "coordinates":[[[10,0],[20,0],[20,10],[10,10],[10,0]]]}},
+	{"type":"Feature","properties":{"ADMIN":"Gamma","FORMAL_EN":"Gamma Islands",
+	  "ISO_A2_EH":"CC","ISO_A3_EH":"CCC","CONTINENT":"Oceania","REGION_UN":"Oceania",
+	  "SUBREGION":"Polynesia","POP_EST":2000},
+	 "geometry":{"type":"MultiPolygon","coordinates":[
+	  [[[0,20],[2,20],[2,22],[0,22],[0,20]]],
+	  [[[4,20],[5,20],[5,21],[4,21],[4,20]]]]}},
+	{"type":"Feature","properties":{"name":"North","iso_3166_2":"AA-N"},
+	 "geometry":{"type":"Polygon",
+	  "coordinates":[[[0,5],[10,5],[10,10],[0,10],[0,5]]]}},
+	{"type":"Feature","properties":{"name":"South","iso_3166_2":"AA-S"},
+	 "geometry":{"type":"Polygon",
+	  "coordinates":[[[0,0],[10,0],[10,5],[0,5],[0,0]]]}},
+	{"type":"Feature","properties":{"name_conve":"Alpha City"},
+	 "geometry":{"type":"Polygon",
+	  "coordinates":[[[2,2],[3,2],[3,3],[2,3],[2,2]]]}}]}`
+
+// TestData is a Dataset with a few synthetic features, which can be passed to
+// rgeo.New like the included datasets:
+//   - Alpha (AA, AAA) from 0 to 10 degrees longitude and latitude, with the
+//     provinces South (AA-S) below 5 degrees latitude and North (AA-N) above,
+//     and the city Alpha City from 2 to 3 degrees longitude and latitude
+//   - Beta (BB, BBB) from 10 to 20 degrees longitude and 0 to 10 latitude,
+//     sharing a border with Alpha
+//   - Gamma (CC, CCC), two islands between 0 and 5 degrees longitude and
+//     20 and 22 latitude
+//
+// Everything else is ocean. The features are decoded on every call, so they
+// aren't shared between Rgeo instances.
+func TestData() []rgeo.Feature {
+	var fc geojson.FeatureCollection
+	if err := json.Unmarshal([]byte(testData), &fc); err != nil {
+		panic(fmt.Sprintf("rgeotest: decode test data: %s", err))
+	}
+
+	features, err := rgeo.LoadGeoJSON(fc)
+	if err != nil {
+		panic(fmt.Sprintf("rgeotest: load test data: %s", err))
+	}
+
+	return rgeo.DatasetNamed("TestData", func() []rgeo.Feature { return features })()
+}
+
+// RandomCoords returns n coordinates distributed uniformly over the sphere,
+// which are the same for the same seed, e.g. for comparing benchmarks.
+func RandomCoords(n int, seed int64) []geom.Coord {
+	rnd := rand.New(rand.NewSource(seed))
+
+	coords := make([]geom.Coord, n)
+	for i := range coords {
+		// The sine of the latitude is uniform, so that the poles aren't
+		// oversampled
+		lat := math.Asin(2*rnd.Float64()-1) * 180 / math.Pi
+		coords[i] = geom.Coord{rnd.Float64()*360 - 180, lat}
+	}
+
+	return coords
+}
@@ -0,0 +1,139 @@
+package rgeotest
+
+import (
+	"testing"
+
+	"github.com/go-test/deep"
+	"github.com/sams96/rgeo"
+	"github.com/twpayne/go-geom"
+)
+
+func TestTestData(t *testing.T) {
+	r, err := rgeo.New(TestData)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	alpha := rgeo.Location{
+		Country:      "Alpha",
+		CountryLong:  "Republic of Alpha",
+		CountryCode2: "AA",
+		CountryCode3: "AAA",
+		Continent:    "Europe",
+		Region:       "Europe",
+		SubRegion:    "Western Europe",
+		Population:   1000000,
+	}
+	with := func(l rgeo.Location, province, code, city string) rgeo.Location {
+		l.Province, l.ProvinceCode, l.City = province, code, city
+		return l
+	}
+
+	tests := []struct {
+		name     string
+		in       geom.Coord
+		err      error
+		expected rgeo.Location
+	}{
+		{"City", geom.Coord{2.5, 2.5}, nil, with(alpha, "South", "AA-S", "Alpha City")},
+		{"Province", geom.Coord{5, 7}, nil, with(alpha, "North", "AA-N", "")},
+		{"Neighbour", geom.Coord{15, 5}, nil, rgeo.Location{
+			Country:      "Beta",
+			CountryLong:  "Kingdom of Beta",
+			CountryCode2: "BB",
+			CountryCode3: "BBB",
+			Continent:    "Europe",
+			Region:       "Europe",
+			SubRegion:    "Western Europe",
+			Population:   500000,
+		}},
+		{"Island", geom.Coord{4.5, 20.5}, nil, rgeo.Location{
+			Country:      "Gamma",
+			CountryLong:  "Gamma Islands",
+			CountryCode2: "CC",
+			CountryCode3: "CCC",
+			Continent:    "Oceania",
+			Region:       "Oceania",
+			SubRegion:    "Polynesia",
+			Population:   2000,
+		}},
+		{"Ocean", geom.Coord{3, 21}, rgeo.ErrLocationNotFound, rgeo.Location{}},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			result, err := r.ReverseGeocode(test.in)
+			if err != test.err {
+				t.Errorf("expected error: %s\n got: %v\n", test.err, err)
+			}
+			if diff := deep.Equal(test.expected, result); diff != nil {
+				t.Error(diff)
+			}
+		})
+	}
+
+	if TestData()[0].Polygon == TestData()[0].Polygon {
+		t.Error("expected the features to be decoded on every call")
+	}
+}
+
+func TestRandomCoords(t *testing.T) {
+	coords := RandomCoords(1000, 1)
+	if diff := deep.Equal(coords, RandomCoords(1000, 1)); diff != nil {
+		t.Errorf("expected the same coordinates for the same seed: %v", diff)
+	}
+	if diff := deep.Equal(coords, RandomCoords(1000, 2)); diff == nil {
+		t.Error("expected different coordinates for another seed")
+	}
+
+	for _, c := range coords {
+		if c.X() < -180 || c.X() > 180 || c.Y() < -90 || c.Y() > 90 {
+			t.Fatalf("coordinate out of range: %v", c)
+		}
+	}
+}
+
+func BenchmarkReverseGeocode(b *testing.B) {
+	coords := RandomCoords(10000, 1)
+
+	for _, d := range []struct {
+		name    string
+		dataset rgeo.Dataset
+	}{
+		{"TestData", TestData},
+		{"Countries110", rgeo.Countries110},
+		{"Countries10", rgeo.Countries10},
+		{"Provinces10", rgeo.Provinces10},
+		{"Cities10", rgeo.Cities10},
+	} {
+		d := d
+		b.Run(d.name, func(b *testing.B) {
+			r, err := rgeo.New(d.dataset)
+			if err != nil {
+				b.Fatal(err)
+			}
+			r.Build()
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				_, _ = r.ReverseGeocode(coords[i%len(coords)])
+			}
+		})
+	}
+}
+
+func BenchmarkReverseGeocodeSnapping(b *testing.B) {
+	coords := RandomCoords(10000, 1)
+
+	r, err := rgeo.New(rgeo.Countries10)
+	if err != nil {
+		b.Fatal(err)
+	}
+	r.Build()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, _ = r.ReverseGeocodeSnapping(coords[i%len(coords)])
+	}
+}
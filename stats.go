@@ -0,0 +1,63 @@
+package rgeo
+
+import (
+	"unsafe"
+
+	"github.com/golang/geo/s2"
+)
+
+// indexCellOverhead estimates the bytes used per cell of an s2.ShapeIndex
+// beyond the edge IDs: the CellID in the sorted list and as map key, the map
+// entry and the cell with its clipped shape.
+const indexCellOverhead = 128
+
+// IndexMemStats is an estimate of the memory used by the loaded datasets.
+type IndexMemStats struct {
+	// Number of shapes in the index, loops of all shapes and their vertices
+	Shapes   int `json:"shapes"`
+	Loops    int `json:"loops"`
+	Vertices int `json:"vertices"`
+
+	// Number of cells in the shape index
+	Cells int `json:"cells"`
+
+	// Estimated bytes used by the polygon and loop structures, by the vertices
+	// and by the shape index
+	LoopBytes  int64 `json:"loop_bytes"`
+	PointBytes int64 `json:"point_bytes"`
+	IndexBytes int64 `json:"index_bytes"`
+
+	// Sum of the above
+	TotalBytes int64 `json:"total_bytes"`
+}
+
+// MemoryStats returns an estimate of the memory used by the loaded datasets,
+// e.g. to compare Cities10 with lighter datasets. It is derived from the
+// number of loops, vertices and index cells, and doesn't include the Locations
+// or the lookup cache. This builds the index if it hasn't been built yet.
+func (r *Rgeo) MemoryStats() IndexMemStats {
+	var s IndexMemStats
+
+	for _, sh := range r.shapes() {
+		p := sh.Shape.(*s2.Polygon)
+		s.Shapes++
+		s.Loops += p.NumLoops()
+		for _, l := range p.Loops() {
+			s.Vertices += l.NumVertices()
+		}
+	}
+
+	for it := r.index.Iterator(); !it.Done(); it.Next() {
+		s.Cells++
+	}
+
+	s.LoopBytes = int64(s.Shapes)*int64(unsafe.Sizeof(s2.Polygon{})) +
+		int64(s.Loops)*int64(unsafe.Sizeof(s2.Loop{})+unsafe.Sizeof(&s2.Loop{}))
+	s.PointBytes = int64(s.Vertices) * int64(unsafe.Sizeof(s2.Point{}))
+	// Every edge is in at least one cell
+	s.IndexBytes = int64(s.Cells)*indexCellOverhead +
+		int64(s.Vertices)*int64(unsafe.Sizeof(int(0)))
+	s.TotalBytes = s.LoopBytes + s.PointBytes + s.IndexBytes
+
+	return s
+}
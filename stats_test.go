@@ -0,0 +1,35 @@
+package rgeo
+
+import (
+	"testing"
+)
+
+func TestMemoryStats(t *testing.T) {
+	r, err := New(testDataset(t, lookupTestData))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := r.MemoryStats()
+	if s.Shapes != 5 || s.Loops != 5 || s.Vertices != 20 {
+		t.Errorf("expected 5 shapes, 5 loops and 20 vertices, got %+v", s)
+	}
+	if s.Cells == 0 {
+		t.Error("expected index cells")
+	}
+	if s.PointBytes != 20*24 {
+		t.Errorf("expected %d point bytes, got %d", 20*24, s.PointBytes)
+	}
+	if s.TotalBytes != s.LoopBytes+s.PointBytes+s.IndexBytes {
+		t.Errorf("expected total to be the sum, got %+v", s)
+	}
+
+	// More data uses more memory
+	r2, err := New(testDataset(t, lookupTestData), testDataset(t, lookupTestData))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s2 := r2.MemoryStats(); s2.TotalBytes <= s.TotalBytes {
+		t.Errorf("expected more than %d bytes, got %d", s.TotalBytes, s2.TotalBytes)
+	}
+}
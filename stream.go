@@ -0,0 +1,90 @@
+package rgeo
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/golang/geo/s2"
+	"github.com/twpayne/go-geom"
+)
+
+// ReverseGeocodeStream reads coordinates from in as "lon,lat" lines, e.g. a
+// CSV file without header, and writes the Location of each to w as a line of
+// JSON, as ReverseGeocode would return it. Coordinates outside of any location
+// are written as an empty object, so the output has a line for every input
+// line, except for empty lines, which are skipped.
+//
+// The input is processed line by line, so it doesn't have to fit in memory.
+// Unlike ReverseGeocode this doesn't call the Hooks or snap. An error is
+// returned for the first line that can't be parsed or has an invalid
+// coordinate, after writing the results of all lines before it.
+func (r *Rgeo) ReverseGeocodeStream(in io.Reader, w io.Writer) error {
+	if err := r.checkBuilt(); err != nil {
+		return err
+	}
+
+	var (
+		scanner = bufio.NewScanner(in)
+		bw      = bufio.NewWriter(w)
+		enc     = json.NewEncoder(bw)
+
+		// Reused for all coordinates, since it only depends on the index
+		query = s2.NewContainsPointQuery(r.index, s2.VertexModelOpen)
+	)
+
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		coord, err := parseCoordLine(line)
+		if err != nil {
+			_ = bw.Flush()
+			return fmt.Errorf("line %d: %w", n, err)
+		}
+
+		var loc Location
+		if shapes := query.ContainingShapes(pointFromCoord(coord)); len(shapes) > 0 {
+			loc = r.combineLocations(shapes)
+		}
+		if err := enc.Encode(loc); err != nil {
+			return fmt.Errorf("line %d: write location: %w", n, err)
+		}
+	}
+
+	if err := bw.Flush(); err != nil {
+		return fmt.Errorf("write locations: %w", err)
+	} else if err := scanner.Err(); err != nil {
+		return fmt.Errorf("read coordinates: %w", err)
+	}
+
+	return nil
+}
+
+// parseCoordLine parses a "lon,lat" line, surrounding spaces of the values
+// are ignored.
+func parseCoordLine(line string) (geom.Coord, error) {
+	a, b, ok := strings.Cut(line, ",")
+	if !ok {
+		return nil, fmt.Errorf("%w: expected lon,lat, got %q", ErrInvalidCoordinate, line)
+	}
+
+	lon, errLon := strconv.ParseFloat(strings.TrimSpace(a), 64)
+	lat, errLat := strconv.ParseFloat(strings.TrimSpace(b), 64)
+	if err := errors.Join(errLon, errLat); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidCoordinate, err)
+	}
+
+	coord := geom.Coord{lon, lat}
+	if err := validateCoord(coord); err != nil {
+		return nil, err
+	}
+
+	return coord, nil
+}
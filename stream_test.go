@@ -0,0 +1,59 @@
+package rgeo
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestReverseGeocodeStream(t *testing.T) {
+	r, err := New(testDataset(t, `{"type":"FeatureCollection","features":[
+		{"type":"Feature","properties":{"ADMIN":"Alpha","ISO_A3_EH":"AAA"},
+		 "geometry":{"type":"Polygon",
+		  "coordinates":[[[0,0],[2,0],[2,2],[0,2],[0,0]]]}},
+		{"type":"Feature","properties":{"name_conve":"Alpha City"},
+		 "geometry":{"type":"Polygon",
+		  "coordinates":[[[0.5,0.5],[1,0.5],[1,1],[0.5,1],[0.5,0.5]]]}}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		in       string
+		err      error
+		expected string
+	}{
+		{
+			name: "Locations",
+			in:   "1.5,1.5\n0.75, 0.75\r\n\n10,10\n",
+			expected: `{"country":"Alpha","country_code_3":"AAA"}` + "\n" +
+				`{"country":"Alpha","country_code_3":"AAA","city":"Alpha City"}` + "\n" +
+				"{}\n",
+		},
+		{name: "Empty", in: "", expected: ""},
+		{
+			name:     "Malformed",
+			in:       "1.5,1.5\n1.5 1.5\n0.75,0.75\n",
+			err:      ErrInvalidCoordinate,
+			expected: `{"country":"Alpha","country_code_3":"AAA"}` + "\n",
+		},
+		{name: "Not a number", in: "lon,lat\n", err: ErrInvalidCoordinate},
+		{name: "Out of range", in: "0,91\n", err: ErrInvalidCoordinate},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			var out bytes.Buffer
+			err := r.ReverseGeocodeStream(strings.NewReader(test.in), &out)
+			if !errors.Is(err, test.err) {
+				t.Errorf("expected error: %s\n got: %v\n", test.err, err)
+			}
+			if out.String() != test.expected {
+				t.Errorf("expected:\n%s\ngot:\n%s", test.expected, out.String())
+			}
+		})
+	}
+}